@@ -0,0 +1,510 @@
+package prototyping
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/go-phings/umbrella"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const passwordMinLength = 8
+
+// AccountsConfig enables self-service account pages and API endpoints
+type AccountsConfig struct {
+	// Registration allows users to sign up and confirm their email
+	Registration bool
+	// PasswordReset allows users to reset a forgotten password
+	PasswordReset bool
+	// Mailer is used to send confirmation and password reset emails
+	Mailer Mailer
+	// BaseURL is prepended to links in emails, eg. https://example.com
+	BaseURL string
+	// Templates overwrite default email subjects and bodies
+	Templates *MailTemplates
+	// DefaultPermissionOps and DefaultPermissionTypes describe the permission given to registered users
+	DefaultPermissionOps   int64
+	DefaultPermissionTypes []string
+}
+
+// MailTemplates are text/template templates of emails. Bodies get .Email, .Name and .URL values.
+type MailTemplates struct {
+	ConfirmationSubject  string
+	ConfirmationBody     string
+	PasswordResetSubject string
+	PasswordResetBody    string
+}
+
+// UserHooks are called after changes to user accounts. Context carries the span of the request, so spans started
+// in a hook are part of the same trace.
+type UserHooks struct {
+	// Created is called after a user has been registered, provisioned from an identity provider or created with
+	// CreateUser
+	Created func(ctx context.Context, userID int64)
+	// EmailConfirmed is called after a user has confirmed the email
+	EmailConfirmed func(ctx context.Context, userID int64)
+	// PasswordReset is called after a user has set a new password with the reset link
+	PasswordReset func(ctx context.Context, userID int64)
+}
+
+// callUserHook runs hook in a child span of the one in ctx
+func (p *Prototype) callUserHook(ctx context.Context, name string, hook func(context.Context, int64), userID int64) {
+	if hook == nil {
+		return
+	}
+	ctx, span := p.tracer.Start(ctx, fmt.Sprintf("UserHooks.%s", name), trace.WithAttributes(attribute.Int64("user_id", userID)))
+	defer span.End()
+	hook(ctx, userID)
+}
+
+func getDefaultMailTemplates() *MailTemplates {
+	return &MailTemplates{
+		ConfirmationSubject:  "Confirm your email",
+		ConfirmationBody:     "Hello {{.Name}},\n\nplease confirm your email by opening the link below:\n\n{{.URL}}\n",
+		PasswordResetSubject: "Reset your password",
+		PasswordResetBody:    "Hello {{.Name}},\n\nyou can set a new password by opening the link below:\n\n{{.URL}}\n\nThe link expires in 1 hour. If you did not request it, please ignore this email.\n",
+	}
+}
+
+type accounts struct {
+	cfg       *AccountsConfig
+	templates *MailTemplates
+}
+
+func newAccounts(cfg *AccountsConfig) *accounts {
+	a := &accounts{
+		cfg:       cfg,
+		templates: getDefaultMailTemplates(),
+	}
+	if cfg.Templates != nil {
+		if cfg.Templates.ConfirmationSubject != "" {
+			a.templates.ConfirmationSubject = cfg.Templates.ConfirmationSubject
+		}
+		if cfg.Templates.ConfirmationBody != "" {
+			a.templates.ConfirmationBody = cfg.Templates.ConfirmationBody
+		}
+		if cfg.Templates.PasswordResetSubject != "" {
+			a.templates.PasswordResetSubject = cfg.Templates.PasswordResetSubject
+		}
+		if cfg.Templates.PasswordResetBody != "" {
+			a.templates.PasswordResetBody = cfg.Templates.PasswordResetBody
+		}
+	}
+	return a
+}
+
+func (a *accounts) send(to string, subject string, body string, data map[string]string) error {
+	tpl, err := texttemplate.New("body").Parse(body)
+	if err != nil {
+		return fmt.Errorf("error parsing email template: %w", err)
+	}
+	var b bytes.Buffer
+	err = tpl.Execute(&b, data)
+	if err != nil {
+		return fmt.Errorf("error executing email template: %w", err)
+	}
+	return a.cfg.Mailer.Send(to, subject, b.String())
+}
+
+var errAccountInvalidInput = errors.New("invalid input")
+
+func validatePassword(password string) error {
+	if len(password) < passwordMinLength {
+		return fmt.Errorf("password must have at least %d characters", passwordMinLength)
+	}
+	return nil
+}
+
+// register creates a user with default permissions and sends the email confirmation link
+func (p *Prototype) register(ctx context.Context, email string, password string, name string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errAccountInvalidInput
+	}
+	if validatePassword(password) != nil {
+		return errAccountInvalidInput
+	}
+	if name == "" {
+		name = email
+	}
+
+	// registering an existing email succeeds without doing anything so that emails cannot be enumerated
+	user := p.getUserInterface(ctx)
+	found, err := user.GetByEmail(email)
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+	if found {
+		return nil
+	}
+
+	key, errUmb := p.umbrella.CreateUser(email, password, map[string]string{
+		"Name": name,
+	})
+	if errUmb != nil {
+		return fmt.Errorf("error creating user: %w", errUmb.Unwrap())
+	}
+
+	user = p.getUserInterface(ctx)
+	found, err = user.GetByEmail(email)
+	if err != nil || !found {
+		return fmt.Errorf("error getting created user: %w", err)
+	}
+	for _, t := range p.accounts.cfg.DefaultPermissionTypes {
+		perm := &umbrella.Permission{
+			Flags:   umbrella.FlagTypeAllow,
+			ForType: umbrella.ForTypeUser,
+			ForItem: user.GetID(),
+			Ops:     p.accounts.cfg.DefaultPermissionOps,
+			ToType:  t,
+		}
+		err = p.ormWithContext(ctx).Save(perm)
+		if err != nil {
+			return fmt.Errorf("error saving default permission: %w", err)
+		}
+	}
+	p.callUserHook(ctx, "Created", p.userHooks.Created, user.GetID())
+
+	return p.accounts.send(email, p.accounts.templates.ConfirmationSubject, p.accounts.templates.ConfirmationBody, map[string]string{
+		"Email": email,
+		"Name":  name,
+		"URL":   fmt.Sprintf("%s%s%s/?key=%s", p.accounts.cfg.BaseURL, p.uriUI, "r/confirm", url.QueryEscape(key)),
+	})
+}
+
+// CreateUser creates an active user with permissions to struct names, eg. {"Item": umbrella.OpsRead | umbrella.OpsList},
+// and returns its ID. It can be called only after Run or Handler.
+func (p *Prototype) CreateUser(email string, password string, name string, permissions map[string]int64) (int64, error) {
+	if name == "" {
+		name = email
+	}
+
+	key, errUmb := p.umbrella.CreateUser(email, password, map[string]string{
+		"Name": name,
+	})
+	if errUmb != nil {
+		return 0, fmt.Errorf("error creating user: %w", errUmb.Unwrap())
+	}
+	errUmb = p.umbrella.ConfirmEmail(key)
+	if errUmb != nil {
+		return 0, fmt.Errorf("error activating user: %w", errUmb.Unwrap())
+	}
+
+	ctx := context.Background()
+	user := p.getUserInterface(ctx)
+	found, err := user.GetByEmail(email)
+	if err != nil || !found {
+		return 0, fmt.Errorf("error getting created user: %w", err)
+	}
+	for t, ops := range permissions {
+		perm := &umbrella.Permission{
+			Flags:   umbrella.FlagTypeAllow,
+			ForType: umbrella.ForTypeUser,
+			ForItem: user.GetID(),
+			Ops:     ops,
+			ToType:  t,
+		}
+		err = p.ormWithContext(ctx).Save(perm)
+		if err != nil {
+			return 0, fmt.Errorf("error saving permission: %w", err)
+		}
+	}
+	p.callUserHook(ctx, "Created", p.userHooks.Created, user.GetID())
+
+	return user.GetID(), nil
+}
+
+func (p *Prototype) confirm(ctx context.Context, key string) error {
+	if key == "" {
+		return errAccountInvalidInput
+	}
+	user := p.getUserInterface(ctx)
+	found, err := user.GetByEmailActivationKey(key)
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+	if !found {
+		return errAccountInvalidInput
+	}
+	errUmb := p.umbrella.ConfirmEmail(key)
+	if errUmb != nil {
+		return fmt.Errorf("error confirming email: %w", errUmb.Unwrap())
+	}
+	p.callUserHook(ctx, "EmailConfirmed", p.userHooks.EmailConfirmed, user.GetID())
+	return nil
+}
+
+// getPasswordFingerprint is put into the password reset token so that the token cannot be used after the password
+// has been changed
+func getPasswordFingerprint(password string) string {
+	h := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(h[:8])
+}
+
+// requestPasswordReset sends the password reset link. Nothing is returned when user does not exist so that
+// emails cannot be enumerated.
+func (p *Prototype) requestPasswordReset(ctx context.Context, email string) error {
+	user := p.getUserInterface(ctx)
+	found, err := user.GetByEmail(email)
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+	if !found {
+		return nil
+	}
+
+	token, err := p.sign(&signedValue{
+		UserID:    user.GetID(),
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Values: map[string]string{
+			"purpose":     "password_reset",
+			"fingerprint": getPasswordFingerprint(user.GetPassword()),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating token: %w", err)
+	}
+
+	return p.accounts.send(email, p.accounts.templates.PasswordResetSubject, p.accounts.templates.PasswordResetBody, map[string]string{
+		"Email": email,
+		"Name":  user.GetExtraField("name"),
+		"URL":   fmt.Sprintf("%s%s%s/?token=%s", p.accounts.cfg.BaseURL, p.uriUI, "r/password", url.QueryEscape(token)),
+	})
+}
+
+func (p *Prototype) resetPassword(ctx context.Context, token string, password string) error {
+	v, err := p.verify(token)
+	if err != nil || v.Values["purpose"] != "password_reset" {
+		return errAccountInvalidInput
+	}
+	if validatePassword(password) != nil {
+		return errAccountInvalidInput
+	}
+
+	user := p.getUserInterface(ctx)
+	found, err := user.GetByID(v.UserID)
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+	if !found || getPasswordFingerprint(user.GetPassword()) != v.Values["fingerprint"] {
+		return errAccountInvalidInput
+	}
+
+	passForDB, err := p.umbrella.GeneratePassword(password)
+	if err != nil {
+		return fmt.Errorf("error generating password: %w", err)
+	}
+	user.SetPassword(passForDB)
+	err = user.Save()
+	if err != nil {
+		return fmt.Errorf("error saving user: %w", err)
+	}
+	err = p.revokeUserCredentials(ctx, user.GetID())
+	if err != nil {
+		return err
+	}
+	p.callUserHook(ctx, "PasswordReset", p.userHooks.PasswordReset, user.GetID())
+	return nil
+}
+
+// revokeUserCredentials removes sessions of a user and deactivates API keys tied to the user, so that whoever got
+// the old password is logged out
+func (p *Prototype) revokeUserCredentials(ctx context.Context, userID int64) error {
+	orm := p.ormWithContext(ctx)
+	err := orm.DeleteMultiple(&umbrella.Session{}, map[string]interface{}{"UserID": userID})
+	if err != nil {
+		return fmt.Errorf("error deleting sessions: %w", err)
+	}
+
+	keys, err := orm.Get(func() interface{} { return &APIKey{} }, []string{"ID", "asc"}, 0, 0, map[string]interface{}{"UserID": userID}, nil)
+	if err != nil {
+		return fmt.Errorf("error getting api keys: %w", err)
+	}
+	for _, k := range keys {
+		apiKey := k.(*APIKey)
+		if apiKey.Flags&APIKeyFlagActive == 0 {
+			continue
+		}
+		apiKey.Flags &^= APIKeyFlagActive
+		apiKey.LastModifiedAt = time.Now().Unix()
+		apiKey.LastModifiedBy = userID
+		err = orm.Save(apiKey)
+		if err != nil {
+			return fmt.Errorf("error deactivating api key: %w", err)
+		}
+	}
+	return nil
+}
+
+var accountsTemplate = template.Must(template.New("accounts").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+{{if .Message}}<p>{{.Message}}</p>
+{{else if eq .Page "register"}}
+<form method="post" action="{{.URI}}">
+<p><label>Name <input type="text" name="name" maxlength="50"></label></p>
+<p><label>Email <input type="email" name="email" required></label></p>
+<p><label>Password <input type="password" name="password" required minlength="8"></label></p>
+<p><input type="submit" value="Register"></p>
+</form>
+{{else if eq .Page "password-reset"}}
+<form method="post" action="{{.URI}}">
+<p><label>Email <input type="email" name="email" required></label></p>
+<p><input type="submit" value="Send reset link"></p>
+</form>
+{{else if eq .Page "password"}}
+<form method="post" action="{{.URI}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<p><label>New password <input type="password" name="password" required minlength="8"></label></p>
+<p><input type="submit" value="Set password"></p>
+</form>
+{{end}}
+<p><a href="{{.LoginURI}}">Log in</a></p>
+</body>
+</html>
+`))
+
+func (p *Prototype) writeAccountsPage(w http.ResponseWriter, data map[string]interface{}) {
+	data["LoginURI"] = fmt.Sprintf("%s%s/", p.uriUI, "login")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	accountsTemplate.Execute(w, data)
+}
+
+func (p *Prototype) getRegisterHTTPHandler(uri string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]interface{}{"Title": "Register", "Page": "register", "URI": uri}
+		if r.Method == http.MethodPost {
+			err := p.register(r.Context(), strings.TrimSpace(r.PostFormValue("email")), r.PostFormValue("password"), strings.TrimSpace(r.PostFormValue("name")))
+			if err != nil {
+				p.logAccountsError("error with registration", err)
+				data["Error"] = "Registration failed. Please check the email and use a password of at least 8 characters."
+			} else {
+				data["Message"] = "Please check your email to confirm the account."
+			}
+		}
+		p.writeAccountsPage(w, data)
+	})
+}
+
+func (p *Prototype) getConfirmHTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]interface{}{"Title": "Email confirmation"}
+		err := p.confirm(r.Context(), r.URL.Query().Get("key"))
+		if err != nil {
+			p.logAccountsError("error with email confirmation", err)
+			data["Error"] = "Invalid or expired confirmation link."
+		} else {
+			data["Message"] = "Your email has been confirmed. You can now log in."
+		}
+		p.writeAccountsPage(w, data)
+	})
+}
+
+func (p *Prototype) getPasswordResetHTTPHandler(uri string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]interface{}{"Title": "Reset password", "Page": "password-reset", "URI": uri}
+		if r.Method == http.MethodPost {
+			err := p.requestPasswordReset(r.Context(), strings.TrimSpace(r.PostFormValue("email")))
+			if err != nil {
+				p.logAccountsError("error with password reset", err)
+			}
+			data["Message"] = "If the account exists, an email with the reset link has been sent."
+		}
+		p.writeAccountsPage(w, data)
+	})
+}
+
+func (p *Prototype) getPasswordHTTPHandler(uri string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]interface{}{"Title": "Set new password", "Page": "password", "URI": uri, "Token": r.URL.Query().Get("token")}
+		if r.Method == http.MethodPost {
+			data["Token"] = r.PostFormValue("token")
+			err := p.resetPassword(r.Context(), r.PostFormValue("token"), r.PostFormValue("password"))
+			if err != nil {
+				p.logAccountsError("error with setting password", err)
+				data["Error"] = "Invalid or expired link, or the password is shorter than 8 characters."
+			} else {
+				data["Message"] = "Your password has been changed. You can now log in."
+			}
+		}
+		p.writeAccountsPage(w, data)
+	})
+}
+
+func (p *Prototype) logAccountsError(msg string, err error) {
+	if errors.Is(err, errAccountInvalidInput) {
+		return
+	}
+	p.logger.Error(msg, slog.Any("error", err))
+}
+
+func writeAccountsJSON(w http.ResponseWriter, status int, errText string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if errText != "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": 0, "err_text": errText})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": 1})
+}
+
+// getAccountsAPIHandler returns JSON endpoints for registration, email confirmation and password reset
+func (p *Prototype) getAccountsAPIHandler(uri string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAccountsJSON(w, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+
+		req := map[string]string{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req)
+		if err != nil {
+			writeAccountsJSON(w, http.StatusBadRequest, "invalid_json")
+			return
+		}
+
+		action := strings.Trim(strings.TrimPrefix(r.URL.Path, uri), "/")
+		switch {
+		case action == "register" && p.accounts.cfg.Registration:
+			err = p.register(r.Context(), strings.TrimSpace(req["email"]), req["password"], strings.TrimSpace(req["name"]))
+		case action == "confirm" && p.accounts.cfg.Registration:
+			err = p.confirm(r.Context(), req["key"])
+		case action == "password-reset" && p.accounts.cfg.PasswordReset:
+			err = p.requestPasswordReset(r.Context(), strings.TrimSpace(req["email"]))
+		case action == "password" && p.accounts.cfg.PasswordReset:
+			err = p.resetPassword(r.Context(), req["token"], req["password"])
+		default:
+			writeAccountsJSON(w, http.StatusNotFound, "not_found")
+			return
+		}
+
+		if errors.Is(err, errAccountInvalidInput) {
+			writeAccountsJSON(w, http.StatusBadRequest, "invalid_input")
+			return
+		}
+		if err != nil {
+			p.logAccountsError("error with accounts api", err)
+			writeAccountsJSON(w, http.StatusInternalServerError, "internal_error")
+			return
+		}
+		writeAccountsJSON(w, http.StatusOK, "")
+	})
+}
@@ -0,0 +1,56 @@
+package prototyping
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccountsSend(t *testing.T) {
+	dir := t.TempDir()
+	a := newAccounts(&AccountsConfig{
+		Mailer: NewFileMailer(dir),
+		Templates: &MailTemplates{
+			ConfirmationBody: "Hi {{.Name}}, open {{.URL}}",
+		},
+	})
+	if a.templates.ConfirmationSubject != getDefaultMailTemplates().ConfirmationSubject {
+		t.Fatalf("expected default subject, got %s", a.templates.ConfirmationSubject)
+	}
+
+	err := a.send("a@example.com", "Confirm\r\nBcc: b@example.com", a.templates.ConfirmationBody, map[string]string{"Name": "A", "URL": "http://x/"})
+	if err != nil {
+		t.Fatalf("error sending email: %s", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*_a_at_example.com.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 email, got %d", len(files))
+	}
+	b, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(b), "Subject: ConfirmBcc: b@example.com\r\n") || !strings.Contains(string(b), "Hi A, open http://x/") {
+		t.Fatalf("unexpected email: %q", string(b))
+	}
+}
+
+func TestResetPasswordInvalidToken(t *testing.T) {
+	p := &Prototype{sessionKey: []byte("key")}
+
+	token, err := p.sign(&signedValue{UserID: 1, ExpiresAt: time.Now().Add(time.Hour).Unix(), Values: map[string]string{"purpose": "other"}})
+	if err != nil {
+		t.Fatalf("error signing token: %s", err)
+	}
+	for _, tk := range []string{"", "invalid", token} {
+		if err := p.resetPassword(context.Background(), tk, "password123"); !errors.Is(err, errAccountInvalidInput) {
+			t.Errorf("expected invalid input with token %q, got %v", tk, err)
+		}
+	}
+
+	token, _ = p.sign(&signedValue{UserID: 1, ExpiresAt: time.Now().Add(time.Hour).Unix(), Values: map[string]string{"purpose": "password_reset"}})
+	if err := p.resetPassword(context.Background(), token, "short"); !errors.Is(err, errAccountInvalidInput) {
+		t.Errorf("expected invalid input with short password, got %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package prototyping
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+	"github.com/go-phings/umbrella"
+)
+
+const apiKeyPrefix = "proto"
+
+// apiKeyLastUsedInterval limits how often last used time of an API key is written to the database
+const apiKeyLastUsedInterval = 60
+
+// apiKeyPrefixLength is the number of random bytes in the public prefix of a key. Prefix is unique and it is used
+// to find the key in the database.
+const apiKeyPrefixLength = 8
+
+// apiKeySecretLength is the number of random bytes in the secret part of a key
+const apiKeySecretLength = 32
+
+// apiKeyPrefixAttempts limits generating new key when its prefix already exists
+const apiKeyPrefixAttempts = 3
+
+// credentialStructNames are builtin structs holding secrets, which are managed by their own handlers and are not
+// exposed in REST and GraphQL APIs
+var credentialStructNames = map[string]bool{
+	"APIKey":       true,
+	"UserTOTP":     true,
+	"UserIdentity": true,
+}
+
+// getAPIConstructors returns exposed constructors without the ones in credentialStructNames
+func (p *Prototype) getAPIConstructors() []func() interface{} {
+	constructors := []func() interface{}{}
+	for _, f := range p.getExposedConstructors() {
+		if !credentialStructNames[sqldb.GetStructName(f())] {
+			constructors = append(constructors, f)
+		}
+	}
+	return constructors
+}
+
+// generateAPIKey returns a new random key and its public prefix that is used to look the key up
+func generateAPIKey() (string, string, error) {
+	b := make([]byte, apiKeyPrefixLength+apiKeySecretLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", fmt.Errorf("error generating random bytes: %w", err)
+	}
+	prefix := hex.EncodeToString(b[:apiKeyPrefixLength])
+	return fmt.Sprintf("%s_%s_%s", apiKeyPrefix, prefix, hex.EncodeToString(b[apiKeyPrefixLength:])), prefix, nil
+}
+
+func hashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// getAPIKeyPrefix returns the lookup prefix of a key or an empty string when key is not in a valid format
+func getAPIKeyPrefix(key string) string {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return ""
+	}
+	return parts[1]
+}
+
+// getAPIKeyFromRequest returns API key from X-API-Key or Authorization: Bearer header
+func getAPIKeyFromRequest(r *http.Request) string {
+	if k := r.Header.Get("X-API-Key"); k != "" {
+		return k
+	}
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		k := strings.TrimPrefix(auth, "Bearer ")
+		if getAPIKeyPrefix(k) != "" {
+			return k
+		}
+	}
+	return ""
+}
+
+// wrapHandlerWithAPIKey passes requests containing an API key straight to next, bypassing umbrella's JWT handler
+func wrapHandlerWithAPIKey(umbrellaHandler http.Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if getAPIKeyFromRequest(r) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		umbrellaHandler.ServeHTTP(w, r)
+	})
+}
+
+// CreateAPIKey generates a new API key and stores its hash in the database. The key itself is returned and it cannot
+// be retrieved later. Pass userID 0 to create a service account key, and expiresAt 0 for a key that does not expire.
+func (p *Prototype) CreateAPIKey(name string, userID int64, ops int64, types []string, expiresAt int64) (string, error) {
+	return p.createAPIKey(context.Background(), &APIKey{
+		Name:      name,
+		UserID:    userID,
+		Ops:       ops,
+		Types:     strings.Join(types, ","),
+		ExpiresAt: expiresAt,
+		CreatedBy: userID,
+	})
+}
+
+// createAPIKey generates key with a prefix that does not exist yet, and saves apiKey with its hash
+func (p *Prototype) createAPIKey(ctx context.Context, apiKey *APIKey) (string, error) {
+	orm := p.ormWithContext(ctx)
+	for i := 0; i < apiKeyPrefixAttempts; i++ {
+		key, prefix, err := generateAPIKey()
+		if err != nil {
+			return "", fmt.Errorf("error generating api key: %w", err)
+		}
+		cnt, err := orm.GetCount(func() interface{} { return &APIKey{} }, map[string]interface{}{"KeyPrefix": prefix})
+		if err != nil {
+			return "", fmt.Errorf("error checking api key prefix: %w", err)
+		}
+		if cnt > 0 {
+			continue
+		}
+
+		apiKey.Flags = APIKeyFlagActive
+		apiKey.KeyPrefix = prefix
+		apiKey.KeyHash = hashAPIKey(key)
+		apiKey.CreatedAt = time.Now().Unix()
+		err = orm.Save(apiKey)
+		if err != nil {
+			return "", fmt.Errorf("error saving api key: %w", err)
+		}
+		return key, nil
+	}
+	return "", errors.New("error generating api key with unique prefix")
+}
+
+// getAPIKeyUser authenticates API key and returns logged user with allowed types limited to the key's scopes.
+// When key is invalid, nil is returned.
+func (p *Prototype) getAPIKeyUser(ctx context.Context, key string) (*loggedUser, error) {
+	prefix := getAPIKeyPrefix(key)
+	if prefix == "" {
+		return nil, nil
+	}
+
+	orm := p.ormWithContext(ctx)
+	keys, err := orm.Get(func() interface{} { return &APIKey{} }, []string{"ID", "asc"}, 2, 0, map[string]interface{}{"KeyPrefix": prefix}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting api key: %w", err)
+	}
+	// prefix is unique so more than one row means the keys have been tampered with
+	if len(keys) != 1 {
+		return nil, nil
+	}
+
+	apiKey := keys[0].(*APIKey)
+	if apiKey.KeyHash == "" || subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(hashAPIKey(key))) != 1 {
+		return nil, nil
+	}
+	now := time.Now().Unix()
+	if apiKey.Flags&APIKeyFlagActive == 0 || (apiKey.ExpiresAt != 0 && apiKey.ExpiresAt < now) {
+		return nil, nil
+	}
+
+	// only the column is updated, and only while the key is active, so that a revocation made in the meantime is
+	// not overwritten
+	if now-apiKey.LastUsedAt > apiKeyLastUsedInterval {
+		_, err = updateFields(orm, apiKey, map[string]interface{}{"LastUsedAt": now}, map[string]interface{}{"ID": apiKey.ID, "Flags:&": APIKeyFlagActive})
+		if err != nil && !errors.Is(err, errUpdateFieldsNotSupported) {
+			p.logger.Error("error saving api key last used time", slog.Int64("api_key_id", apiKey.ID), slog.Any("error", err))
+		}
+	}
+
+	// key cannot do more than its owner, or the admin that created a service account key, is allowed to
+	ownerID := apiKey.UserID
+	if ownerID == 0 {
+		ownerID = apiKey.CreatedBy
+	}
+	var owner *loggedUser
+	if ownerID != 0 {
+		owner, err = p.getLoggedUser(ctx, ownerID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting api key owner: %w", err)
+		}
+		if owner == nil {
+			return nil, nil
+		}
+		if apiKey.UserID == 0 && !owner.isAdmin() {
+			return nil, nil
+		}
+	}
+
+	lu := &loggedUser{
+		id:           apiKey.UserID,
+		name:         apiKey.Name,
+		allowedTypes: map[int]map[string]bool{},
+	}
+	if owner != nil && apiKey.UserID != 0 {
+		lu.name = owner.name
+	}
+
+	keyTypes := map[string]bool{}
+	for _, t := range strings.Split(apiKey.Types, ",") {
+		t = strings.TrimSpace(t)
+		if t != "" {
+			keyTypes[t] = true
+		}
+	}
+
+	for _, o := range []int{umbrella.OpsList, umbrella.OpsRead, umbrella.OpsCreate, umbrella.OpsUpdate, umbrella.OpsDelete} {
+		lu.allowedTypes[o] = map[string]bool{}
+		if apiKey.Ops&int64(o) == 0 {
+			continue
+		}
+		for s := range p.structNames {
+			if !isTypeAllowed(keyTypes, s) {
+				continue
+			}
+			if owner != nil && !isTypeAllowed(owner.allowedTypes[o], s) {
+				continue
+			}
+			lu.allowedTypes[o][s] = true
+		}
+	}
+
+	return lu, nil
+}
+
+func isTypeAllowed(allowedTypes map[string]bool, structName string) bool {
+	return allowedTypes["all"] || allowedTypes[structName]
+}
+
+var apiKeyTemplate = template.Must(template.New("apikey").Parse(`<!DOCTYPE html>
+<html>
+<head><title>API keys</title></head>
+<body>
+<h1>New API key</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+{{if .Key}}
+<p>Copy the key now, it will not be shown again:</p>
+<pre>{{.Key}}</pre>
+<p><a href="{{.URI}}">Create another key</a></p>
+{{else}}
+<form method="post" action="{{.URI}}">
+<p><label>Name <input type="text" name="name" required maxlength="100"></label></p>
+{{if .Admin}}<p><label><input type="checkbox" name="service_account" value="1"> Service account (not tied to the logged user)</label></p>{{end}}
+<p>Operations:
+{{range .Ops}}<label><input type="checkbox" name="ops" value="{{.Value}}"> {{.Name}}</label> {{end}}
+</p>
+<p>Types:
+<label><input type="checkbox" name="types" value="all"> all</label>
+{{range .Types}}<label><input type="checkbox" name="types" value="{{.}}"> {{.}}</label> {{end}}
+</p>
+<p><label>Expires in days (0 for never) <input type="number" name="expires_days" value="0" min="0"></label></p>
+<p><input type="submit" value="Create"></p>
+</form>
+{{end}}
+<p><a href="/ui/">Back</a></p>
+</body>
+</html>
+`))
+
+type templateOp struct {
+	Name  string
+	Value int
+}
+
+func getTemplateOps() []templateOp {
+	return []templateOp{
+		{Name: "List", Value: umbrella.OpsList},
+		{Name: "Read", Value: umbrella.OpsRead},
+		{Name: "Create", Value: umbrella.OpsCreate},
+		{Name: "Update", Value: umbrella.OpsUpdate},
+		{Name: "Delete", Value: umbrella.OpsDelete},
+	}
+}
+
+// getAPIKeyHTTPHandler returns handler of a page where logged user can generate a new API key
+func (p *Prototype) getAPIKeyHTTPHandler(uri string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lu := getLoggedUserFromContext(r.Context())
+		if lu == nil || !isTypeAllowed(lu.allowedTypes[umbrella.OpsCreate], "APIKey") {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("NoAccess"))
+			return
+		}
+
+		data := map[string]interface{}{
+			"URI":   uri,
+			"Ops":   getTemplateOps(),
+			"Types": p.getStructNamesSorted(),
+			"Admin": lu.isAdmin(),
+		}
+
+		if r.Method == http.MethodPost {
+			key, err := p.createAPIKeyFromForm(r, lu)
+			if err != nil {
+				data["Error"] = err.Error()
+			} else {
+				data["Key"] = key
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		apiKeyTemplate.Execute(w, data)
+	})
+}
+
+func (p *Prototype) createAPIKeyFromForm(r *http.Request, lu *loggedUser) (string, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return "", errors.New("invalid form")
+	}
+
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	if name == "" {
+		return "", errors.New("name is required")
+	}
+
+	var ops int64
+	for _, v := range r.PostForm["ops"] {
+		o, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return "", errors.New("invalid operation")
+		}
+		ops |= o
+	}
+
+	var expiresAt int64
+	days, _ := strconv.Atoi(r.PostForm.Get("expires_days"))
+	if days > 0 {
+		expiresAt = time.Now().Add(time.Duration(days) * 24 * time.Hour).Unix()
+	}
+
+	userID := lu.id
+	if r.PostForm.Get("service_account") == "1" {
+		if !lu.isAdmin() {
+			return "", errors.New("only admin can create service account keys")
+		}
+		userID = 0
+	}
+
+	types := r.PostForm["types"]
+	for _, o := range []int{umbrella.OpsList, umbrella.OpsRead, umbrella.OpsCreate, umbrella.OpsUpdate, umbrella.OpsDelete} {
+		if ops&int64(o) == 0 {
+			continue
+		}
+		for _, t := range types {
+			if !isTypeAllowed(lu.allowedTypes[o], t) {
+				return "", fmt.Errorf("operation or type %s is not allowed", t)
+			}
+		}
+	}
+	if ops&^int64(umbrella.OpsList|umbrella.OpsRead|umbrella.OpsCreate|umbrella.OpsUpdate|umbrella.OpsDelete) != 0 {
+		return "", errors.New("invalid operation")
+	}
+
+	key, err := p.createAPIKey(r.Context(), &APIKey{
+		Name:      name,
+		UserID:    userID,
+		Ops:       ops,
+		Types:     strings.Join(types, ","),
+		ExpiresAt: expiresAt,
+		CreatedBy: lu.id,
+	})
+	if err != nil {
+		p.logger.Error("error creating api key", slog.Any("error", err))
+		return "", errors.New("error creating api key")
+	}
+	return key, nil
+}
@@ -0,0 +1,80 @@
+package prototyping
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-phings/umbrella"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		t.Fatalf("error generating key: %s", err)
+	}
+	if len(prefix) != 2*apiKeyPrefixLength || getAPIKeyPrefix(key) != prefix {
+		t.Fatalf("unexpected prefix %s of key %s", prefix, key)
+	}
+	if len(key) != len(apiKeyPrefix)+2+2*(apiKeyPrefixLength+apiKeySecretLength) {
+		t.Fatalf("unexpected key length %d", len(key))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/Item/", nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	if getAPIKeyFromRequest(r) != key {
+		t.Fatal("expected key from authorization header")
+	}
+	r.Header.Set("Authorization", "Bearer some.jwt.token")
+	if getAPIKeyFromRequest(r) != "" {
+		t.Fatal("expected token that is not a key to be ignored")
+	}
+}
+
+func TestCreateAPIKeyFromForm(t *testing.T) {
+	o := &testORM{}
+	p := &Prototype{orm: o, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	user := &loggedUser{id: 2, allowedTypes: map[int]map[string]bool{
+		umbrella.OpsList: {"Item": true},
+		umbrella.OpsRead: {"Item": true},
+	}}
+	admin := &loggedUser{id: 1, allowedTypes: map[int]map[string]bool{}}
+	for _, op := range []int{umbrella.OpsList, umbrella.OpsRead, umbrella.OpsCreate, umbrella.OpsUpdate, umbrella.OpsDelete} {
+		admin.allowedTypes[op] = map[string]bool{"all": true}
+	}
+
+	tests := []struct {
+		name string
+		lu   *loggedUser
+		form url.Values
+		err  string
+	}{
+		{name: "service account by user", lu: user, form: url.Values{"name": {"k"}, "service_account": {"1"}}, err: "only admin can create service account keys"},
+		{name: "type not allowed", lu: user, form: url.Values{"name": {"k"}, "ops": {"16"}, "types": {"Other"}}, err: "operation or type Other is not allowed"},
+		{name: "operation not allowed", lu: user, form: url.Values{"name": {"k"}, "ops": {"64"}, "types": {"Item"}}, err: "operation or type Item is not allowed"},
+		{name: "invalid operation", lu: admin, form: url.Values{"name": {"k"}, "ops": {"1"}}, err: "invalid operation"},
+		{name: "user", lu: user, form: url.Values{"name": {"k"}, "ops": {"16", "128"}, "types": {"Item"}}},
+		{name: "service account by admin", lu: admin, form: url.Values{"name": {"k"}, "service_account": {"1"}, "ops": {"64"}, "types": {"all"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/ui/r/apikey/", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			key, err := p.createAPIKeyFromForm(r, tt.lu)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil || getAPIKeyPrefix(key) == "" {
+				t.Fatalf("expected key, got %q and %v", key, err)
+			}
+		})
+	}
+}
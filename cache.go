@@ -0,0 +1,205 @@
+package prototyping
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+	"github.com/go-phings/umbrella"
+)
+
+// loggedUser contains details of the user that are put into the request context
+type loggedUser struct {
+	id           int64
+	name         string
+	allowedTypes map[int]map[string]bool
+	expiresAt    time.Time
+	// twoFactorEnabled and twoFactorRequired come from user's UserTOTP
+	twoFactorEnabled  bool
+	twoFactorRequired bool
+}
+
+const loggedUserContextKey = contextKey("loggedUser")
+
+func getLoggedUserFromContext(ctx context.Context) *loggedUser {
+	lu, _ := ctx.Value(loggedUserContextKey).(*loggedUser)
+	return lu
+}
+
+// isAdmin checks whether user has all the operations allowed on "all" types, as the admin created with CreateDB
+func (lu *loggedUser) isAdmin() bool {
+	for _, o := range []int{umbrella.OpsList, umbrella.OpsRead, umbrella.OpsCreate, umbrella.OpsUpdate, umbrella.OpsDelete} {
+		if !lu.allowedTypes[o]["all"] {
+			return false
+		}
+	}
+	return true
+}
+
+// userCache keeps logged users with their allowed types so that they are not fetched from the database on every
+// request. Every invalidation bumps the generation, so that a user loaded before it is not cached with stale
+// permissions. Expired users are removed when setting, at most once per TTL.
+type userCache struct {
+	mu         sync.RWMutex
+	ttl        time.Duration
+	users      map[int64]*loggedUser
+	generation uint64
+	prunedAt   time.Time
+}
+
+func newUserCache(ttl time.Duration) *userCache {
+	return &userCache{
+		ttl:      ttl,
+		users:    map[int64]*loggedUser{},
+		prunedAt: time.Now(),
+	}
+}
+
+func (c *userCache) get(userID int64) *loggedUser {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	u, ok := c.users[userID]
+	if !ok || time.Now().After(u.expiresAt) {
+		return nil
+	}
+	return u
+}
+
+// getGeneration returns generation that has to be passed to set when the user is loaded
+func (c *userCache) getGeneration() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.generation
+}
+
+// set caches user loaded in the generation, unless cache was invalidated since then
+func (c *userCache) set(u *loggedUser, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.prunedAt) >= c.ttl {
+		for id, cu := range c.users {
+			if now.After(cu.expiresAt) {
+				delete(c.users, id)
+			}
+		}
+		c.prunedAt = now
+	}
+	if generation != c.generation {
+		return
+	}
+	u.expiresAt = now.Add(c.ttl)
+	c.users[u.id] = u
+}
+
+func (c *userCache) invalidateUser(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	delete(c.users, userID)
+}
+
+func (c *userCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.users = map[int64]*loggedUser{}
+}
+
+// InvalidateUserCache removes all the cached users and permissions. It should be called when permissions or users
+// are modified directly in the database.
+func (p *Prototype) InvalidateUserCache() {
+	if p.userCache != nil {
+		p.userCache.invalidateAll()
+	}
+}
+
+// cacheInvalidatingORM is an ORM that invalidates user cache whenever a user or a permission is saved or deleted
+type cacheInvalidatingORM struct {
+	orm            ORM
+	cache          *userCache
+	userStructName string
+}
+
+// invalidate removes cached users affected by a change of obj. When userID is 0, all users are removed.
+func (o *cacheInvalidatingORM) invalidate(obj interface{}, userID int64) {
+	switch sqldb.GetStructName(obj) {
+	case "Permission", "UserTOTP":
+		o.cache.invalidateAll()
+	case o.userStructName:
+		if userID == 0 {
+			o.cache.invalidateAll()
+			return
+		}
+		o.cache.invalidateUser(userID)
+	}
+}
+
+func (o *cacheInvalidatingORM) SetDatabase(dbConn *sql.DB, tblPrefix string) {
+	o.orm.SetDatabase(dbConn, tblPrefix)
+}
+
+func (o *cacheInvalidatingORM) RegisterStruct(obj interface{}, inheritFromObj interface{}, overwriteExisting bool, forceNameForDB string, useOnlyRootFromInheritedObj bool) error {
+	return o.orm.RegisterStruct(obj, inheritFromObj, overwriteExisting, forceNameForDB, useOnlyRootFromInheritedObj)
+}
+
+func (o *cacheInvalidatingORM) CreateTables(objs ...interface{}) error {
+	return o.orm.CreateTables(objs...)
+}
+
+func (o *cacheInvalidatingORM) Load(obj interface{}, id string) error {
+	return o.orm.Load(obj, id)
+}
+
+func (o *cacheInvalidatingORM) Save(obj interface{}) error {
+	err := o.orm.Save(obj)
+	o.invalidate(obj, o.orm.GetObjIDValue(obj))
+	return err
+}
+
+func (o *cacheInvalidatingORM) Delete(obj interface{}) error {
+	id := o.orm.GetObjIDValue(obj)
+	err := o.orm.Delete(obj)
+	o.invalidate(obj, id)
+	return err
+}
+
+func (o *cacheInvalidatingORM) DeleteMultiple(obj interface{}, filters map[string]interface{}) error {
+	err := o.orm.DeleteMultiple(obj, filters)
+	o.invalidate(obj, 0)
+	return err
+}
+
+func (o *cacheInvalidatingORM) Get(newObjFunc func() interface{}, order []string, limit int, offset int, filters map[string]interface{}, rowObjTransformFunc func(interface{}) interface{}) ([]interface{}, error) {
+	return o.orm.Get(newObjFunc, order, limit, offset, filters, rowObjTransformFunc)
+}
+
+func (o *cacheInvalidatingORM) GetCount(newObjFunc func() interface{}, filters map[string]interface{}) (int64, error) {
+	return o.orm.GetCount(newObjFunc, filters)
+}
+
+func (o *cacheInvalidatingORM) updateFields(obj interface{}, values map[string]interface{}, filters map[string]interface{}) (int64, error) {
+	cnt, err := updateFields(o.orm, obj, values, filters)
+	o.invalidate(obj, 0)
+	return cnt, err
+}
+
+func (o *cacheInvalidatingORM) insertWithColumns(obj interface{}, columns map[string]interface{}) error {
+	err := insertWithColumns(o.orm, obj, columns)
+	o.invalidate(obj, o.orm.GetObjIDValue(obj))
+	return err
+}
+
+func (o *cacheInvalidatingORM) GetFieldNameFromDBCol(obj interface{}, field string) (string, error) {
+	return o.orm.GetFieldNameFromDBCol(obj, field)
+}
+
+func (o *cacheInvalidatingORM) GetObjIDValue(obj interface{}) int64 {
+	return o.orm.GetObjIDValue(obj)
+}
+
+func (o *cacheInvalidatingORM) ResetFields(obj interface{}) {
+	o.orm.ResetFields(obj)
+}
@@ -0,0 +1,65 @@
+package prototyping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserCache(t *testing.T) {
+	c := newUserCache(time.Minute)
+	c.set(&loggedUser{id: 1, name: "a"}, c.getGeneration())
+	if u := c.get(1); u == nil || u.name != "a" {
+		t.Fatalf("expected cached user, got %v", u)
+	}
+
+	c.invalidateUser(1)
+	if c.get(1) != nil {
+		t.Fatal("expected user to be removed")
+	}
+
+	c.set(&loggedUser{id: 1}, c.getGeneration())
+	c.set(&loggedUser{id: 2}, c.getGeneration())
+	c.invalidateAll()
+	if c.get(1) != nil || c.get(2) != nil {
+		t.Fatal("expected all users to be removed")
+	}
+}
+
+func TestUserCacheSkipsStaleLoad(t *testing.T) {
+	c := newUserCache(time.Minute)
+
+	// permissions change while user is being loaded
+	generation := c.getGeneration()
+	c.invalidateAll()
+	c.set(&loggedUser{id: 1}, generation)
+	if c.get(1) != nil {
+		t.Fatal("expected user loaded before invalidation not to be cached")
+	}
+
+	generation = c.getGeneration()
+	c.invalidateUser(2)
+	c.set(&loggedUser{id: 1}, generation)
+	if c.get(1) != nil {
+		t.Fatal("expected user loaded before invalidation not to be cached")
+	}
+
+	c.set(&loggedUser{id: 1}, c.getGeneration())
+	if c.get(1) == nil {
+		t.Fatal("expected user to be cached")
+	}
+}
+
+func TestUserCachePrunesExpired(t *testing.T) {
+	c := newUserCache(time.Minute)
+	c.set(&loggedUser{id: 1}, c.getGeneration())
+	c.users[1].expiresAt = time.Now().Add(-time.Second)
+	c.prunedAt = time.Now().Add(-2 * time.Minute)
+
+	c.set(&loggedUser{id: 2}, c.getGeneration())
+	if _, ok := c.users[1]; ok {
+		t.Fatal("expected expired user to be pruned")
+	}
+	if len(c.users) != 1 {
+		t.Fatalf("expected 1 user, got %d", len(c.users))
+	}
+}
@@ -0,0 +1,487 @@
+package prototyping
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"reflect"
+	"text/template"
+)
+
+// goClientReservedNames are types declared by the generated client that cannot be used as struct names
+var goClientReservedNames = map[string]bool{
+	"Client":   true,
+	"Option":   true,
+	"APIError": true,
+	"response": true,
+}
+
+type goClientField struct {
+	Name     string
+	Type     string
+	Tag      string
+	Password bool
+}
+
+type goClientStruct struct {
+	Name   string
+	Fields []goClientField
+}
+
+// GenerateGoClient writes source of a Go package that calls the REST API of the prototype. It contains a type for
+// each struct, a service with CRUD methods, a query builder with filters, and an iterator over all the pages.
+func (p *Prototype) GenerateGoClient(w io.Writer, pkg string) error {
+	if !token.IsIdentifier(pkg) {
+		return fmt.Errorf("invalid package name %s", pkg)
+	}
+
+	structs := []goClientStruct{}
+	for _, f := range p.getAPIConstructors() {
+		s := getModelStruct(f)
+		if goClientReservedNames[s.name] || !token.IsIdentifier(s.name) {
+			return fmt.Errorf("struct name %s cannot be used in the client", s.name)
+		}
+		cs := goClientStruct{Name: s.name}
+		t := reflect.TypeOf(f()).Elem()
+		for _, field := range s.fields {
+			// fields that are not marshaled are not sent by the API
+			sf, _ := t.FieldByName(field.name)
+			if sf.Tag.Get("json") == "-" {
+				continue
+			}
+			cs.Fields = append(cs.Fields, goClientField{
+				Name:     field.name,
+				Type:     field.typ.Kind().String(),
+				Tag:      fmt.Sprintf("`json:\"%s\"`", field.jsonName),
+				Password: field.password,
+			})
+		}
+		structs = append(structs, cs)
+	}
+
+	b := &bytes.Buffer{}
+	err := goClientTemplate.Execute(b, map[string]interface{}{
+		"Package":     pkg,
+		"APIURI":      p.uriAPI,
+		"UmbrellaURI": p.uriUmbrella,
+		"Structs":     structs,
+	})
+	if err != nil {
+		return fmt.Errorf("error generating client: %w", err)
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("error formatting client: %w", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+var goClientTemplate = template.Must(template.New("client").Parse(`// Code generated by prototyping. DO NOT EDIT.
+
+// Package {{.Package}} is a client of the prototype's REST API.
+package {{.Package}}
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors that APIError matches with errors.Is
+var (
+	ErrInvalidInput = errors.New("invalid input")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServer       = errors.New("server error")
+)
+
+// APIError is returned when the API responds with an error
+type APIError struct {
+	StatusCode int
+	// Code is err_text of a JSON response or the plain text body, eg. FieldNotAllowed
+	Code string
+	// RetryAfter is set when requests are rate limited
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("api error: status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("api error: status %d: %s", e.StatusCode, e.Code)
+}
+
+// Is maps status code of the response to one of the Err* errors
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrInvalidInput:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+type response struct {
+	OK      int             ` + "`json:\"ok\"`" + `
+	ErrText string          ` + "`json:\"err_text\"`" + `
+	Data    json.RawMessage ` + "`json:\"data\"`" + `
+}
+
+// Client calls the prototype's REST API
+type Client struct {
+	baseURL     string
+	apiURI      string
+	umbrellaURI string
+	httpClient  *http.Client
+	apiKey      string
+	token       string
+{{range .Structs}}
+	{{.Name}} *{{.Name}}Service{{end}}
+}
+
+// Option configures Client
+type Option func(*Client)
+
+// WithHTTPClient sets HTTP client used for requests, defaults to http.DefaultClient
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithAPIKey sets API key that is sent in X-API-Key header
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithToken sets token that is sent in Authorization header
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// NewClient returns client of the prototype running at baseURL, eg. http://localhost:9001
+func NewClient(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		apiURI:      "{{.APIURI}}",
+		umbrellaURI: "{{.UmbrellaURI}}",
+		httpClient:  http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+{{range .Structs}}	c.{{.Name}} = &{{.Name}}Service{c: c}
+{{end}}	return c
+}
+
+// SetToken sets token that is sent in Authorization header
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// Login gets a token for email and password and sends it with the following requests. totpCode is required only
+// when the user has two-factor authentication enabled.
+func (c *Client) Login(ctx context.Context, email string, password string, totpCode string) error {
+	form := url.Values{}
+	form.Set("email", email)
+	form.Set("password", password)
+	if totpCode != "" {
+		form.Set("totp_code", totpCode)
+	}
+	data := struct {
+		Token string ` + "`json:\"token\"`" + `
+	}{}
+	err := c.do(ctx, http.MethodPost, c.umbrellaURI+"login", nil, form, &data)
+	if err != nil {
+		return err
+	}
+	c.token = data.Token
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var r io.Reader
+	contentType := ""
+	switch v := body.(type) {
+	case nil:
+	case url.Values:
+		r = strings.NewReader(v.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("error marshaling request: %w", err)
+		}
+		r = bytes.NewReader(b)
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	} else if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	res := response{}
+	isJSON := json.Unmarshal(b, &res) == nil
+	if resp.StatusCode >= http.StatusMultipleChoices || (isJSON && res.OK == 0 && res.ErrText != "") {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Code: strings.TrimSpace(string(b))}
+		if isJSON {
+			apiErr.Code = res.ErrText
+		}
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(s) * time.Second
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if !isJSON || len(res.Data) == 0 {
+		return fmt.Errorf("invalid response: %s", string(b))
+	}
+	err = json.Unmarshal(res.Data, out)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling response: %w", err)
+	}
+	return nil
+}
+{{range .Structs}}{{$s := .Name}}
+// {{$s}} is an object of the {{$s}} struct
+type {{$s}} struct {
+{{range .Fields}}	{{.Name}} {{.Type}} {{.Tag}}
+{{end}}}
+
+// Fields of {{$s}} that can be used in {{$s}}Query.OrderBy
+const (
+{{range .Fields}}	{{$s}}Field{{.Name}} = "{{.Name}}"
+{{end}})
+
+// {{$s}}Query filters, orders and paginates {{$s}} objects
+type {{$s}}Query struct {
+	limit          int
+	offset         int
+	order          string
+	orderDirection string
+	filters        url.Values
+}
+
+// New{{$s}}Query returns an empty query
+func New{{$s}}Query() *{{$s}}Query {
+	return &{{$s}}Query{filters: url.Values{}}
+}
+
+// Limit sets the number of objects in a page
+func (q *{{$s}}Query) Limit(limit int) *{{$s}}Query {
+	q.limit = limit
+	return q
+}
+
+// Offset sets the number of objects to skip
+func (q *{{$s}}Query) Offset(offset int) *{{$s}}Query {
+	q.offset = offset
+	return q
+}
+
+// OrderBy sorts objects by a field, eg. {{$s}}FieldID
+func (q *{{$s}}Query) OrderBy(field string, desc bool) *{{$s}}Query {
+	q.order = field
+	q.orderDirection = "asc"
+	if desc {
+		q.orderDirection = "desc"
+	}
+	return q
+}
+{{range .Fields}}{{if not .Password}}
+// Where{{.Name}} returns objects with {{.Name}} equal to v
+func (q *{{$s}}Query) Where{{.Name}}(v {{.Type}}) *{{$s}}Query {
+	q.filters.Set("filter_{{.Name}}", fmt.Sprint(v))
+	return q
+}
+{{end}}{{end}}
+func (q *{{$s}}Query) values() url.Values {
+	v := url.Values{}
+	for k, f := range q.filters {
+		v[k] = f
+	}
+	if q.limit > 0 {
+		v.Set("limit", strconv.Itoa(q.limit))
+	}
+	if q.offset > 0 {
+		v.Set("offset", strconv.Itoa(q.offset))
+	}
+	if q.order != "" {
+		v.Set("order", q.order)
+		v.Set("order_direction", q.orderDirection)
+	}
+	return v
+}
+
+// {{$s}}Service calls the {{$s}} endpoints
+type {{$s}}Service struct {
+	c *Client
+}
+
+func (s *{{$s}}Service) path(id int64) string {
+	if id == 0 {
+		return s.c.apiURI + "{{$s}}/"
+	}
+	return fmt.Sprintf("%s{{$s}}/%d", s.c.apiURI, id)
+}
+
+// Get returns {{$s}} with the id
+func (s *{{$s}}Service) Get(ctx context.Context, id int64) (*{{$s}}, error) {
+	data := struct {
+		Item *{{$s}} ` + "`json:\"item\"`" + `
+	}{}
+	err := s.c.do(ctx, http.MethodGet, s.path(id), nil, nil, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data.Item, nil
+}
+
+// List returns a page of objects matching q and the total number of matching objects. q can be nil.
+func (s *{{$s}}Service) List(ctx context.Context, q *{{$s}}Query) ([]*{{$s}}, int64, error) {
+	if q == nil {
+		q = New{{$s}}Query()
+	}
+	data := struct {
+		Items []*{{$s}} ` + "`json:\"items\"`" + `
+		Total int64 ` + "`json:\"total\"`" + `
+	}{}
+	err := s.c.do(ctx, http.MethodGet, s.path(0), q.values(), nil, &data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data.Items, data.Total, nil
+}
+
+// Create creates a new {{$s}} and returns its ID
+func (s *{{$s}}Service) Create(ctx context.Context, o *{{$s}}) (int64, error) {
+	data := struct {
+		ID int64 ` + "`json:\"id\"`" + `
+	}{}
+	err := s.c.do(ctx, http.MethodPut, s.path(0), nil, o, &data)
+	if err != nil {
+		return 0, err
+	}
+	return data.ID, nil
+}
+
+// Update saves o as {{$s}} with the id
+func (s *{{$s}}Service) Update(ctx context.Context, id int64, o *{{$s}}) error {
+	return s.c.do(ctx, http.MethodPut, s.path(id), nil, o, nil)
+}
+
+// Delete removes {{$s}} with the id
+func (s *{{$s}}Service) Delete(ctx context.Context, id int64) error {
+	return s.c.do(ctx, http.MethodDelete, s.path(id), nil, nil, nil)
+}
+
+// Iterate returns iterator over all the objects matching q. Pages have q's limit, 100 by default. q can be nil.
+func (s *{{$s}}Service) Iterate(q *{{$s}}Query) *{{$s}}Iterator {
+	if q == nil {
+		q = New{{$s}}Query()
+	}
+	it := &{{$s}}Iterator{s: s, q: *q}
+	if it.q.limit <= 0 {
+		it.q.limit = 100
+	}
+	return it
+}
+
+// {{$s}}Iterator fetches pages of {{$s}} objects as they are needed
+type {{$s}}Iterator struct {
+	s    *{{$s}}Service
+	q    {{$s}}Query
+	page []*{{$s}}
+	cur  *{{$s}}
+	last bool
+	err  error
+}
+
+// Next moves to the next object and returns false when there are no more objects or an error occurred
+func (it *{{$s}}Iterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	if len(it.page) == 0 {
+		if it.last {
+			return false
+		}
+		items, _, err := it.s.List(ctx, &it.q)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.last = len(items) < it.q.limit
+		it.q.offset += len(items)
+		it.page = items
+		if len(it.page) == 0 {
+			return false
+		}
+	}
+	it.cur, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Value returns the current object
+func (it *{{$s}}Iterator) Value() *{{$s}} {
+	return it.cur
+}
+
+// Err returns error that stopped the iteration
+func (it *{{$s}}Iterator) Err() error {
+	return it.err
+}
+{{end}}`))
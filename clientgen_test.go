@@ -0,0 +1,77 @@
+package prototyping
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+type ClientItem struct {
+	ID       int64
+	Flags    int64
+	Name     string  `json:"name" ui:"req lenmin:1 lenmax:50"`
+	Price    float64 `json:"price"`
+	Archived bool
+	Password string `json:"password" ui:"password"`
+	Internal string `json:"-"`
+}
+
+func TestGenerateGoClient(t *testing.T) {
+	p := &Prototype{
+		uriAPI:       "/api/",
+		uriUmbrella:  "/umbrella/",
+		constructors: []func() interface{}{func() interface{} { return &ClientItem{} }},
+	}
+	b := &bytes.Buffer{}
+	err := p.GenerateGoClient(b, "client")
+	if err != nil {
+		t.Fatalf("error generating client: %s", err)
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		t.Fatalf("error formatting client: %s", err)
+	}
+	if !bytes.Equal(src, b.Bytes()) {
+		t.Error("expected client to be formatted")
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "client.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("error parsing client: %s", err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	pkg, err := conf.Check("client", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatalf("error type checking client: %s", err)
+	}
+
+	for _, name := range []string{"Client", "NewClient", "APIError", "ClientItem", "ClientItemService", "ClientItemQuery", "ClientItemIterator", "NewClientItemQuery"} {
+		if pkg.Scope().Lookup(name) == nil {
+			t.Errorf("expected %s in the client", name)
+		}
+	}
+	item, ok := pkg.Scope().Lookup("ClientItem").Type().Underlying().(*types.Struct)
+	if !ok {
+		t.Fatal("expected ClientItem to be a struct")
+	}
+	fields := []string{}
+	for i := 0; i < item.NumFields(); i++ {
+		fields = append(fields, item.Field(i).Name()+" "+item.Field(i).Type().String()+" "+item.Tag(i))
+	}
+	for _, s := range []string{`Name string json:"name"`, `Price float64 json:"price"`, `Archived bool json:"Archived"`} {
+		if !strings.Contains(strings.Join(fields, "\n"), s) {
+			t.Errorf("expected field %s in %v", s, fields)
+		}
+	}
+	if strings.Contains(strings.Join(fields, "\n"), "Internal") {
+		t.Errorf("expected field not marshaled to json to be skipped, got %v", fields)
+	}
+}
@@ -0,0 +1,280 @@
+package prototyping
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// configEnvPrefix is prepended to names of environment variables, eg. PROTO_DATABASE_DSN
+const configEnvPrefix = "PROTO_"
+
+// configSecretPrefix marks a value that is read from a file, eg. "file:/run/secrets/db_dsn"
+const configSecretPrefix = "file:"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadConfig returns Config read from a YAML, JSON or TOML file, when path is not empty, and from environment
+// variables, which take precedence. Keys are snake case names of the fields, eg. database_dsn or oidc.client_id,
+// and environment variables are the same keys in upper case with PROTO_ prefix, eg. PROTO_DATABASE_DSN or
+// PROTO_OIDC_CLIENT_ID. Lists in environment variables are comma separated and durations are strings such as "1h".
+// Secrets can be kept in files: values starting with "file:" are replaced with contents of the file, and so are
+// environment variables with _FILE suffix, eg. PROTO_SESSION_KEY_FILE.
+// Fields that are not plain values, eg. Logger or Accounts.Mailer, have to be set in code. Config is validated by
+// NewPrototype.
+func LoadConfig(path string) (Config, error) {
+	cfg := Config{}
+	errs := []error{}
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		errs = append(errs, setConfigValues(reflect.ValueOf(&cfg).Elem(), values, "")...)
+	}
+	errs = append(errs, setConfigEnv(reflect.ValueOf(&cfg).Elem(), configEnvPrefix)...)
+	if len(errs) > 0 {
+		return cfg, fmt.Errorf("error loading config: %w", errors.Join(errs...))
+	}
+	return cfg, nil
+}
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		err = yaml.Unmarshal(b, &values)
+	case ".toml":
+		err = toml.Unmarshal(b, &values)
+	default:
+		return nil, fmt.Errorf("unsupported config file extension of %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config from %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// getConfigKey returns snake case key of a field, eg. grpc_package for GRPCPackage
+func getConfigKey(name string) string {
+	name = strings.ReplaceAll(name, "GraphQL", "Graphql")
+	r := []rune(name)
+	o := ""
+	for i, ch := range r {
+		if i > 0 && unicode.IsUpper(ch) && (unicode.IsLower(r[i-1]) || (i+1 < len(r) && unicode.IsLower(r[i+1]))) {
+			o += "_"
+		}
+		o += string(unicode.ToLower(ch))
+	}
+	return o
+}
+
+// isConfigStruct checks if a field is a pointer to a config struct of this package, eg. *OIDCConfig
+func isConfigStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct && t.Elem().PkgPath() == reflect.TypeOf(Config{}).PkgPath()
+}
+
+// getConfigFields returns fields that can be loaded, keyed by their config keys
+func getConfigFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch {
+		case isConfigStruct(f.Type):
+		case f.Type == durationType:
+		case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.String:
+		case f.Type.Kind() == reflect.String, f.Type.Kind() == reflect.Bool:
+		case f.Type.Kind() >= reflect.Int && f.Type.Kind() <= reflect.Int64:
+		case f.Type.Kind() == reflect.Float32, f.Type.Kind() == reflect.Float64:
+		default:
+			continue
+		}
+		fields[getConfigKey(f.Name)] = f
+	}
+	return fields
+}
+
+// readConfigSecret returns contents of the file when value references one
+func readConfigSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, configSecretPrefix) {
+		return value, nil
+	}
+	b, err := os.ReadFile(strings.TrimPrefix(value, configSecretPrefix))
+	if err != nil {
+		return "", fmt.Errorf("error reading secret: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// setConfigString parses value to the field's type
+func setConfigString(v reflect.Value, value string) error {
+	value, err := readConfigSecret(value)
+	if err != nil {
+		return err
+	}
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items).Convert(v.Type()))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Float32, v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("%d is out of range", i)
+		}
+		v.SetInt(i)
+	}
+	return nil
+}
+
+// setConfigValues sets fields of v to values from a config file and returns all the problems found
+func setConfigValues(v reflect.Value, values map[string]interface{}, path string) []error {
+	fields := getConfigFields(v.Type())
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	errs := []error{}
+	for _, k := range keys {
+		f, ok := fields[k]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown key %s%s", path, k))
+			continue
+		}
+		fv := v.FieldByIndex(f.Index)
+		val := values[k]
+
+		if isConfigStruct(f.Type) {
+			m, ok := val.(map[string]interface{})
+			if !ok {
+				errs = append(errs, fmt.Errorf("invalid value of %s%s: expected a table", path, k))
+				continue
+			}
+			if fv.IsNil() {
+				fv.Set(reflect.New(f.Type.Elem()))
+			}
+			errs = append(errs, setConfigValues(fv.Elem(), m, path+k+".")...)
+			continue
+		}
+
+		var err error
+		switch x := val.(type) {
+		case []interface{}:
+			if fv.Kind() != reflect.Slice {
+				err = errors.New("unexpected list")
+				break
+			}
+			items := []string{}
+			for _, item := range x {
+				s, serr := readConfigSecret(fmt.Sprint(item))
+				if serr != nil {
+					err = serr
+					break
+				}
+				items = append(items, s)
+			}
+			fv.Set(reflect.ValueOf(items).Convert(fv.Type()))
+		case map[string]interface{}:
+			err = errors.New("unexpected table")
+		case nil:
+		default:
+			err = setConfigString(fv, fmt.Sprint(x))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid value of %s%s: %w", path, k, err))
+		}
+	}
+	return errs
+}
+
+// setConfigEnv sets fields of v to values of environment variables and returns all the problems found
+func setConfigEnv(v reflect.Value, prefix string) []error {
+	fields := getConfigFields(v.Type())
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	errs := []error{}
+	for _, k := range keys {
+		f := fields[k]
+		fv := v.FieldByIndex(f.Index)
+		name := prefix + strings.ToUpper(k)
+
+		if isConfigStruct(f.Type) {
+			found := false
+			for _, e := range os.Environ() {
+				if strings.HasPrefix(e, name+"_") {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+			if fv.IsNil() {
+				fv.Set(reflect.New(f.Type.Elem()))
+			}
+			errs = append(errs, setConfigEnv(fv.Elem(), name+"_")...)
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			file, ok := os.LookupEnv(name + "_FILE")
+			if !ok {
+				continue
+			}
+			value = configSecretPrefix + file
+		}
+		err := setConfigString(fv, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid value of %s: %w", name, err))
+		}
+	}
+	return errs
+}
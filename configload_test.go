@@ -0,0 +1,182 @@
+package prototyping
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+	return path
+}
+
+func TestGetConfigKey(t *testing.T) {
+	for name, key := range map[string]string{
+		"DatabaseDSN":      "database_dsn",
+		"GRPCPackage":      "grpc_package",
+		"GraphQLURI":       "graphql_uri",
+		"OIDC":             "oidc",
+		"UserCacheTTL":     "user_cache_ttl",
+		"TrustedProxyHops": "trusted_proxy_hops",
+	} {
+		if k := getConfigKey(name); k != key {
+			t.Errorf("expected %s for %s, got %s", key, name, k)
+		}
+	}
+}
+
+func TestLoadConfigFromFile(t *testing.T) {
+	secret := writeTestFile(t, "secret", "client-secret\n")
+	yamlPath := writeTestFile(t, "config.yaml", `
+database_dsn: postgres://localhost/app
+metrics: true
+user_cache_ttl: 5m
+oidc:
+  client_id: app
+  client_secret: file:`+secret+`
+  scopes: [openid, email]
+rate_limit:
+  ip_rate: 2.5
+  trusted_proxy_hops: 1
+`)
+	tomlPath := writeTestFile(t, "config.toml", `
+database_dsn = "postgres://localhost/app"
+metrics = true
+user_cache_ttl = "5m"
+
+[oidc]
+client_id = "app"
+client_secret = "file:`+secret+`"
+scopes = ["openid", "email"]
+
+[rate_limit]
+ip_rate = 2.5
+trusted_proxy_hops = 1
+`)
+	for _, path := range []string{yamlPath, tomlPath} {
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("error loading %s: %s", path, err)
+		}
+		if cfg.DatabaseDSN != "postgres://localhost/app" || !cfg.Metrics || cfg.UserCacheTTL != 5*time.Minute {
+			t.Errorf("invalid config from %s: %+v", path, cfg)
+		}
+		if cfg.OIDC == nil || cfg.OIDC.ClientID != "app" || cfg.OIDC.ClientSecret != "client-secret" || strings.Join(cfg.OIDC.Scopes, ",") != "openid,email" {
+			t.Errorf("invalid oidc config from %s: %+v", path, cfg.OIDC)
+		}
+		if cfg.RateLimit == nil || cfg.RateLimit.IPRate != 2.5 || cfg.RateLimit.TrustedProxyHops != 1 {
+			t.Errorf("invalid rate limit config from %s: %+v", path, cfg.RateLimit)
+		}
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	secret := writeTestFile(t, "secret", "session-key")
+	path := writeTestFile(t, "config.yaml", "database_dsn: postgres://localhost/file\n")
+	t.Setenv("PROTO_DATABASE_DSN", "postgres://localhost/env")
+	t.Setenv("PROTO_SESSION_KEY_FILE", secret)
+	t.Setenv("PROTO_CORS_ALLOWED_ORIGINS", "https://a.example.com, https://b.example.com")
+	t.Setenv("PROTO_SESSION_EXPIRATION", "2h")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("error loading config: %s", err)
+	}
+	if cfg.DatabaseDSN != "postgres://localhost/env" || cfg.SessionKey != "session-key" || cfg.SessionExpiration != 2*time.Hour {
+		t.Errorf("expected values from env, got %+v", cfg)
+	}
+	if cfg.CORS == nil || strings.Join(cfg.CORS.AllowedOrigins, ",") != "https://a.example.com,https://b.example.com" {
+		t.Errorf("invalid cors config: %+v", cfg.CORS)
+	}
+	if cfg.OIDC != nil {
+		t.Error("expected oidc config to be nil without its variables")
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	path := writeTestFile(t, "config.yaml", `
+unknown: 1
+metrics: maybe
+oidc: yes
+rate_limit:
+  ip_burst: many
+`)
+	t.Setenv("PROTO_USER_CACHE_TTL", "5 minutes")
+	_, err := LoadConfig(path)
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, s := range []string{"unknown key unknown", "invalid value of metrics", "invalid value of oidc", "invalid value of rate_limit.ip_burst", "invalid value of PROTO_USER_CACHE_TTL"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in %s", s, err)
+		}
+	}
+
+	if _, err := LoadConfig(writeTestFile(t, "config.ini", "")); err == nil {
+		t.Error("expected error with unsupported extension")
+	}
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error with missing file")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	valid := func() *Config {
+		return &Config{DatabaseDSN: "postgres://localhost/app", DatabaseTablePrefix: "proto_"}
+	}
+	if err := validateConfig(valid()); err != nil {
+		t.Fatalf("expected valid config, got %s", err)
+	}
+	if err := validateConfig(&Config{ORM: &testORM{}}); err != nil {
+		t.Fatalf("expected dsn not to be required with orm, got %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		err    string
+	}{
+		{name: "dsn", modify: func(c *Config) { c.DatabaseDSN = "" }, err: "database dsn is missing"},
+		{name: "prefix", modify: func(c *Config) { c.DatabaseTablePrefix = "proto-" }, err: "database table prefix"},
+		{name: "uri", modify: func(c *Config) { c.MetricsURI = "metrics" }, err: "uri metrics must start with /"},
+		{name: "tracing", modify: func(c *Config) { c.TracingEndpoint = "localhost" }, err: "tracing endpoint"},
+		{name: "session key", modify: func(c *Config) { c.SessionKey = "short" }, err: "at least 32 characters"},
+		{name: "samesite", modify: func(c *Config) { c.CookieSameSite = "lax" }, err: "cookie samesite"},
+		{name: "grpc package", modify: func(c *Config) { c.GRPCPackage = "app-v1" }, err: "grpc package"},
+		{name: "oidc", modify: func(c *Config) { c.OIDC = &OIDCConfig{IssuerURL: "ftp://issuer"} }, err: "oidc issuer url must be"},
+		{name: "accounts", modify: func(c *Config) { c.Accounts = &AccountsConfig{Registration: true} }, err: "mailer is required"},
+		{name: "rate limit", modify: func(c *Config) { c.RateLimit = &RateLimitConfig{TrustedProxyHops: -1} }, err: "trusted proxy hops"},
+		{name: "lockout", modify: func(c *Config) {
+			c.RateLimit = &RateLimitConfig{LoginLockout: time.Hour, LoginMaxLockout: time.Minute}
+		}, err: "login max lockout"},
+		{name: "cors", modify: func(c *Config) { c.CORS = &CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true} }, err: "cors credentials"},
+		{name: "cors origin", modify: func(c *Config) { c.CORS = &CORSConfig{AllowedOrigins: []string{"https://a.example.com/app"}} }, err: "invalid cors origin"},
+		{name: "tenancy", modify: func(c *Config) { c.Tenancy = &TenancyConfig{Domain: ".example.com"} }, err: "tenancy domain"},
+		{name: "tenancy without dsn", modify: func(c *Config) {
+			c.DatabaseDSN, c.ORM, c.Tenancy = "", &testORM{}, &TenancyConfig{}
+		}, err: "database dsn is required with tenancy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(cfg)
+			err := validateConfig(cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected %q error, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// all the problems are returned at once
+	err := validateConfig(&Config{DatabaseTablePrefix: "-", CookieSameSite: "x"})
+	if err == nil || len(strings.Split(err.Error(), "\n")) != 3 {
+		t.Fatalf("expected 3 errors, got %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package prototyping
 
 import (
+	"log/slog"
+	"time"
+
 	ui "github.com/go-phings/crud-ui"
 )
 
@@ -9,5 +12,57 @@ type Config struct {
 	UserConstructor   func() interface{}
 	IntFieldValues    map[string]ui.IntFieldValues
 	StringFieldValues map[string]ui.StringFieldValues
-	ORM               ORM
+	// ORM replaces the default one. DatabaseDSN can be empty when the ORM is already connected to its database,
+	// except with Tenancy, which adds columns to the tables directly.
+	ORM ORM
+	// DatabaseTablePrefix is prepended to names of the tables, defaults to proto_. It can contain a schema, eg.
+	// "public." for tables without a prefix.
+	DatabaseTablePrefix string
+	// Metrics enables Prometheus metrics endpoint
+	Metrics bool
+	// MetricsURI is the path of the metrics endpoint, defaults to /metrics
+	MetricsURI string
+	// Logger is used for access logs and internal errors, defaults to slog.Default()
+	Logger *slog.Logger
+	// TracingEndpoint is an OTLP/HTTP collector address (host:port) that spans are exported to, tracing is disabled when empty
+	TracingEndpoint string
+	// TracingInsecure disables TLS when exporting spans
+	TracingInsecure bool
+	// HealthURI is the path of the liveness endpoint, defaults to /healthz
+	HealthURI string
+	// ReadyURI is the path of the readiness endpoint, defaults to /readyz
+	ReadyURI string
+	// UserCacheTTL enables caching of logged users and their permissions for the specified duration. Cache is
+	// invalidated when a user or a permission is saved or deleted.
+	UserCacheTTL time.Duration
+	// OIDC enables login to the administration panel with an OpenID Connect identity provider
+	OIDC *OIDCConfig
+	// SessionKey is used to sign session cookies, a random one is generated when empty
+	SessionKey string
+	// SessionExpiration is the lifetime of session cookies, defaults to 1 hour
+	SessionExpiration time.Duration
+	// TwoFactorRequired forces all users to enable two-factor authentication
+	TwoFactorRequired bool
+	// Accounts enables self-service registration and password reset
+	Accounts *AccountsConfig
+	// UserHooks are called after users are created, confirm their email or reset the password
+	UserHooks *UserHooks
+	// RateLimit enables rate limits on API and login, and lockout of accounts after failed logins
+	RateLimit *RateLimitConfig
+	// DisableCSRF turns off CSRF token check on state-changing requests to the administration panel
+	DisableCSRF bool
+	// CookieSameSite is the SameSite attribute of cookies ("Lax", "Strict" or "None"), defaults to "Lax"
+	CookieSameSite string
+	// CORS enables Cross-Origin Resource Sharing headers on the REST API
+	CORS *CORSConfig
+	// Tenancy enables separating data of the structs passed to NewPrototype between tenants
+	Tenancy *TenancyConfig
+	// GraphQL enables GraphQL endpoint for the registered structs
+	GraphQL bool
+	// GraphQLURI is the path of the GraphQL endpoint, defaults to /graphql
+	GraphQLURI string
+	// GRPC enables gRPC and Connect services for the registered structs, served on the same port over HTTP/2 cleartext
+	GRPC bool
+	// GRPCPackage is the protobuf package of the services, defaults to prototyping.v1
+	GRPCPackage string
 }
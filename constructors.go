@@ -0,0 +1,198 @@
+package prototyping
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+	"github.com/go-phings/umbrella"
+)
+
+// dbTypeRegexp matches db_type values that struct-sql-postgres does not ignore
+var dbTypeRegexp = regexp.MustCompile(`^(TEXT|BPCHAR|(VARCHAR|CHARACTER VARYING|BPCHAR|CHAR|CHARACTER)\([0-9]+\))$`)
+
+// validateConstructors checks the structs passed to NewPrototype and returns all the problems at once, so that they
+// are not found later by a panic
+func validateConstructors(cfg *Config, constructors []func() interface{}) error {
+	errs := []error{}
+
+	reserved := map[string]bool{"User": true}
+	for _, o := range []interface{}{&umbrella.Session{}, &umbrella.Permission{}, &APIKey{}, &UserTOTP{}, &UserIdentity{}, &Tenant{}, &TenantUser{}} {
+		reserved[sqldb.GetStructName(o)] = true
+	}
+
+	if cfg.UserConstructor != nil {
+		name, err := validateConstructor(cfg.UserConstructor)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("user constructor: %w", err))
+		case name != "User" && reserved[name]:
+			errs = append(errs, fmt.Errorf("user constructor: struct %s clashes with a builtin struct", name))
+		default:
+			if _, ok := cfg.UserConstructor().(userInterface); !ok {
+				errs = append(errs, fmt.Errorf("user constructor: struct %s does not implement methods of user (GetID, GetEmail, SetEmail etc.)", name))
+			}
+			reserved[name] = true
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, f := range constructors {
+		name, err := validateConstructor(f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("constructor %d: %w", i, err))
+			continue
+		}
+		if reserved[name] {
+			errs = append(errs, fmt.Errorf("constructor %d: struct %s clashes with a builtin struct", i, name))
+		}
+		if seen[name] {
+			errs = append(errs, fmt.Errorf("constructor %d: struct %s is passed more than once", i, name))
+		}
+		seen[name] = true
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateConstructor checks a struct created by the constructor and returns its name
+func validateConstructor(f func() interface{}) (string, error) {
+	if f == nil {
+		return "", errors.New("constructor is nil")
+	}
+	o := f()
+	t := reflect.TypeOf(o)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct || reflect.ValueOf(o).IsNil() {
+		return "", fmt.Errorf("constructor must return a pointer to a struct, got %v", t)
+	}
+	t = t.Elem()
+	if t.Name() == "" {
+		return "", errors.New("struct must be named")
+	}
+
+	errs := []error{}
+	if id, ok := t.FieldByName("ID"); !ok || id.Type.Kind() != reflect.Int64 {
+		errs = append(errs, errors.New("field ID int64 is missing"))
+	}
+	jsonNames := map[string]string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		// fields of other types, eg. slices or joined structs, are skipped by the ORM and the generated APIs
+		if !isKindSupported(field.Type.Kind()) {
+			if opt := getColumnTagOption(field); opt != "" {
+				errs = append(errs, fmt.Errorf("field %s has type %s that cannot be a column, but has ui option %s", field.Name, field.Type, opt))
+			}
+			continue
+		}
+		err := validateFieldTag(field)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("field %s: %w", field.Name, err))
+			continue
+		}
+		jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+		if jsonName == "" || jsonName == "-" {
+			jsonName = field.Name
+		}
+		if other, ok := jsonNames[jsonName]; ok {
+			errs = append(errs, fmt.Errorf("fields %s and %s have the same json name %s", other, field.Name, jsonName))
+		}
+		jsonNames[jsonName] = field.Name
+	}
+	if len(errs) > 0 {
+		return t.Name(), fmt.Errorf("struct %s: %w", t.Name(), errors.Join(errs...))
+	}
+	return t.Name(), nil
+}
+
+// getColumnTagOption returns the first ui tag option that only makes sense for a database column
+func getColumnTagOption(field reflect.StructField) string {
+	for _, s := range strings.Fields(field.Tag.Get("ui")) {
+		if s == "uniq" || strings.HasPrefix(s, "db_type:") {
+			return s
+		}
+	}
+	return ""
+}
+
+// validateFieldTag checks the tag syntax the same way go vet does, and values of json, ui and perm tags
+func validateFieldTag(field reflect.StructField) error {
+	tag := string(field.Tag)
+	for tag != "" {
+		tag = strings.TrimLeft(tag, " ")
+		if tag == "" {
+			break
+		}
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return errors.New("tag is not in key:\"value\" format")
+		}
+		tag = tag[i+1:]
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return errors.New("tag value is not terminated")
+		}
+		_, err := strconv.Unquote(tag[:i+1])
+		if err != nil {
+			return fmt.Errorf("tag value %s is not quoted properly", tag[:i+1])
+		}
+		tag = tag[i+1:]
+	}
+
+	jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+	if jsonName != "" && jsonName != "-" && !fieldNameRegexp.MatchString(jsonName) {
+		return fmt.Errorf("json name %s must contain letters, digits and underscores only", jsonName)
+	}
+
+	for _, s := range strings.Fields(field.Tag.Get("perm")) {
+		if s != "read" && s != "write" && s != "readonly" {
+			return fmt.Errorf("invalid perm option %s", s)
+		}
+	}
+
+	lenMin, lenMax := -1, -1
+	for _, s := range strings.Fields(field.Tag.Get("ui")) {
+		name, value, _ := strings.Cut(s, ":")
+		switch name {
+		case "lenmin", "lenmax", "valmin", "valmax":
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("ui option %s must be an integer", name)
+			}
+			if name == "lenmin" {
+				lenMin = i
+			}
+			if name == "lenmax" {
+				lenMax = i
+			}
+		case "regexp":
+			_, err := regexp.Compile(value)
+			if err != nil {
+				return fmt.Errorf("invalid ui regexp: %w", err)
+			}
+		case "db_type":
+			if !dbTypeRegexp.MatchString(strings.ToUpper(value)) {
+				return fmt.Errorf("invalid ui db_type %s", value)
+			}
+		}
+	}
+	if lenMin > 0 && lenMax > 0 && lenMin > lenMax {
+		return errors.New("ui lenmin cannot be greater than lenmax")
+	}
+	return nil
+}
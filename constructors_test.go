@@ -0,0 +1,103 @@
+package prototyping
+
+import (
+	"strings"
+	"testing"
+)
+
+type testConstructorGroup struct {
+	ID   int64
+	Name string
+}
+
+type testConstructorItem struct {
+	ID      int64
+	Name    string                `json:"name" ui:"req lenmin:1 lenmax:50 uniq"`
+	Tags    []string              `json:"tags"`
+	Group   *testConstructorGroup `2db:"join"`
+	Meta    map[string]string
+	private []int
+}
+
+type testConstructorInvalid struct {
+	ID     int64
+	Name   string `json:"name" ui:"lenmin:10 lenmax:5"`
+	Label  string
+	Title  string   `json:"Label"`
+	Price  int      `perm:"hidden"`
+	Text   string   `ui:"db_type:BLOB"`
+	Tags   []string `ui:"uniq"`
+	Labels []string `ui:"db_type:TEXT"`
+}
+
+type testConstructorNoID struct {
+	Name string
+}
+
+type testConstructorUser struct {
+	ID int64
+}
+
+func TestValidateConstructor(t *testing.T) {
+	name, err := validateConstructor(func() interface{} { return &testConstructorItem{} })
+	if err != nil || name != "testConstructorItem" {
+		t.Fatalf("expected fields that are not columns to be skipped, got %s %v", name, err)
+	}
+
+	_, err = validateConstructor(func() interface{} { return &testConstructorInvalid{} })
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, s := range []string{
+		"field Name: ui lenmin cannot be greater than lenmax",
+		"fields Label and Title have the same json name Label",
+		"field Price: invalid perm option hidden",
+		"field Text: invalid ui db_type BLOB",
+		"field Tags has type []string that cannot be a column, but has ui option uniq",
+		"field Labels has type []string that cannot be a column, but has ui option db_type:TEXT",
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in %s", s, err)
+		}
+	}
+
+	for _, f := range []func() interface{}{
+		nil,
+		func() interface{} { return nil },
+		func() interface{} { return testConstructorItem{} },
+		func() interface{} { return (*testConstructorItem)(nil) },
+		func() interface{} { return &struct{ ID int64 }{} },
+		func() interface{} { return &testConstructorNoID{} },
+	} {
+		if _, err := validateConstructor(f); err == nil {
+			t.Errorf("expected error with %T", f)
+		}
+	}
+}
+
+func TestValidateConstructors(t *testing.T) {
+	item := func() interface{} { return &testConstructorItem{} }
+	if err := validateConstructors(&Config{}, []func() interface{}{item}); err != nil {
+		t.Fatalf("expected valid constructors, got %s", err)
+	}
+
+	err := validateConstructors(&Config{UserConstructor: func() interface{} { return &testConstructorUser{} }}, []func() interface{}{
+		item,
+		item,
+		func() interface{} { return &APIKey{} },
+		func() interface{} { return &testConstructorNoID{} },
+	})
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, s := range []string{
+		"user constructor: struct testConstructorUser does not implement methods of user",
+		"constructor 1: struct testConstructorItem is passed more than once",
+		"constructor 2: struct APIKey clashes with a builtin struct",
+		"constructor 3: struct testConstructorNoID: field ID int64 is missing",
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in %s", s, err)
+		}
+	}
+}
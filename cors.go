@@ -0,0 +1,101 @@
+package prototyping
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig contains Cross-Origin Resource Sharing settings for the REST API
+type CORSConfig struct {
+	// AllowedOrigins is a list of origins allowed to call the API, "*" allows any origin
+	AllowedOrigins []string
+	// AllowedMethods defaults to GET, POST, PUT, PATCH, DELETE
+	AllowedMethods []string
+	// AllowedHeaders defaults to Authorization, Content-Type, X-API-Key and X-Request-ID
+	AllowedHeaders []string
+	// AllowCredentials lets browsers send cookies with cross-origin requests
+	AllowCredentials bool
+	// MaxAge is how long the preflight response can be cached, not sent when zero
+	MaxAge time.Duration
+}
+
+type cors struct {
+	origins          map[string]bool
+	anyOrigin        bool
+	methods          string
+	headers          string
+	allowCredentials bool
+	maxAge           string
+}
+
+func newCORS(cfg *CORSConfig) *cors {
+	c := &cors{
+		origins:          map[string]bool{},
+		methods:          "GET, POST, PUT, PATCH, DELETE",
+		headers:          "Authorization, Content-Type, X-API-Key, X-Request-ID",
+		allowCredentials: cfg.AllowCredentials,
+	}
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" {
+			c.anyOrigin = true
+			continue
+		}
+		c.origins[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+	}
+	if len(cfg.AllowedMethods) > 0 {
+		c.methods = strings.ToUpper(strings.Join(cfg.AllowedMethods, ", "))
+	}
+	if len(cfg.AllowedHeaders) > 0 {
+		c.headers = strings.Join(cfg.AllowedHeaders, ", ")
+	}
+	if cfg.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+	return c
+}
+
+func (c *cors) isOriginAllowed(origin string) bool {
+	return c.anyOrigin || c.origins[strings.ToLower(origin)]
+}
+
+// wrapHandlerWithCORS adds CORS headers to responses and answers preflight requests without passing them further
+// so that they do not reach authentication
+func (p *Prototype) wrapHandlerWithCORS(h http.Handler) http.Handler {
+	if p.cors == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !p.cors.isOriginAllowed(origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if p.cors.anyOrigin && !p.cors.allowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if p.cors.allowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", p.cors.methods)
+			w.Header().Set("Access-Control-Allow-Headers", p.cors.headers)
+			if p.cors.maxAge != "" {
+				w.Header().Set("Access-Control-Max-Age", p.cors.maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After, X-Request-ID")
+		h.ServeHTTP(w, r)
+	})
+}
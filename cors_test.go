@@ -0,0 +1,82 @@
+package prototyping
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestCORSHandler(cfg *CORSConfig) (http.Handler, *bool) {
+	p := &Prototype{cors: newCORS(cfg)}
+	called := false
+	return p.wrapHandlerWithCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})), &called
+}
+
+func TestCORSPreflight(t *testing.T) {
+	h, called := newTestCORSHandler(&CORSConfig{
+		AllowedOrigins:   []string{"https://App.example.com/"},
+		AllowedMethods:   []string{"get", "post"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+
+	r := httptest.NewRequest(http.MethodOptions, "/api/items/", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusNoContent || *called {
+		t.Fatalf("expected preflight to be answered, got %d %v", rec.Code, *called)
+	}
+	for k, v := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, POST",
+		"Access-Control-Allow-Headers":     "Authorization, Content-Type, X-API-Key, X-Request-ID",
+		"Access-Control-Max-Age":           "600",
+	} {
+		if rec.Header().Get(k) != v {
+			t.Errorf("expected %s header to be %s, got %s", k, v, rec.Header().Get(k))
+		}
+	}
+
+	// preflight from another origin is passed further without the headers
+	r = httptest.NewRequest(http.MethodOptions, "/api/items/", nil)
+	r.Header.Set("Origin", "https://evil.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if !*called || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("expected request from another origin to get no cors headers")
+	}
+}
+
+func TestCORSRequest(t *testing.T) {
+	h, called := newTestCORSHandler(&CORSConfig{AllowedOrigins: []string{"*"}})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/items/", nil)
+	r.Header.Set("Origin", "https://any.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if !*called {
+		t.Fatal("expected request to be passed further")
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" || rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("unexpected headers: %v", rec.Header())
+	}
+	if rec.Header().Get("Access-Control-Expose-Headers") == "" || rec.Header().Get("Vary") != "Origin" {
+		t.Fatalf("unexpected headers: %v", rec.Header())
+	}
+
+	// OPTIONS without the requested method is not a preflight
+	*called = false
+	r = httptest.NewRequest(http.MethodOptions, "/api/items/", nil)
+	r.Header.Set("Origin", "https://any.example.com")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if !*called {
+		t.Fatal("expected request to be passed further")
+	}
+}
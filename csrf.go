@@ -0,0 +1,191 @@
+package prototyping
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	csrfCookieName = "ProtoCSRF"
+	csrfFieldName  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+var csrfFormRegexp = regexp.MustCompile(`(?i)<form\b[^>]*>`)
+
+// csrfScript adds the token header to same-origin requests made by the UI scripts
+const csrfScript = `<script>(function(){var t=document.querySelector('meta[name="csrf-token"]').content;` +
+	`var f=window.fetch;if(f){window.fetch=function(i,o){o=o||{};var u=(typeof i==="string")?i:i.url;` +
+	`if(new URL(u,location.href).origin===location.origin){o.headers=new Headers(o.headers||{});o.headers.set("X-CSRF-Token",t);}` +
+	`return f.call(this,i,o);};}` +
+	`var op=XMLHttpRequest.prototype.open,se=XMLHttpRequest.prototype.send;` +
+	`XMLHttpRequest.prototype.open=function(m,u){this._csrf=new URL(u,location.href).origin===location.origin;return op.apply(this,arguments);};` +
+	`XMLHttpRequest.prototype.send=function(){if(this._csrf){this.setRequestHeader("X-CSRF-Token",t);}return se.apply(this,arguments);};` +
+	`})();</script>`
+
+// getCSRFToken returns token from the cookie, setting a new one when there is none
+func (p *Prototype) getCSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	c, err := r.Cookie(csrfCookieName)
+	if err == nil && len(c.Value) == 48 {
+		return c.Value, nil
+	}
+	token, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     p.uriUI,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// isCSRFTokenValid checks whether state-changing request contains the same token as the cookie, in either
+// X-CSRF-Token header or csrf_token form field
+func isCSRFTokenValid(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	c, err := r.Cookie(csrfCookieName)
+	if err != nil || c.Value == "" {
+		return false
+	}
+
+	token := r.Header.Get(csrfHeaderName)
+	if token == "" {
+		ct := r.Header.Get("Content-Type")
+		if strings.HasPrefix(ct, "application/x-www-form-urlencoded") || strings.HasPrefix(ct, "multipart/form-data") {
+			token = r.FormValue(csrfFieldName)
+		}
+	}
+
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(token)) == 1
+}
+
+// wrapLoginHandlerWithCSRFCheck rejects posts of the forms shown before user is logged in, such as the login form,
+// that do not contain the token from the cookie. Body is read the same way as the login handler does, and it is
+// restored.
+func (p *Prototype) wrapLoginHandlerWithCSRFCheck(h http.Handler) http.Handler {
+	if !p.csrf {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		form, err := getLoginForm(w, r)
+		if err != nil {
+			writeLoginBodyError(w)
+			return
+		}
+		token := r.Header.Get(csrfHeaderName)
+		if token == "" {
+			token = form.CSRFToken
+		}
+		c, err := r.Cookie(csrfCookieName)
+		if err != nil || c.Value == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(token)) != 1 {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("InvalidCSRFToken"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// wrapHandlerWithCSRFToken injects the token into HTML forms and adds a script that sends it with scripted
+// requests
+func (p *Prototype) wrapHandlerWithCSRFToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := p.getCSRFToken(w, r)
+		if err != nil {
+			p.logger.Error("error generating csrf token", slog.Any("error", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		buf := &bufferedResponse{header: http.Header{}}
+		h.ServeHTTP(buf, r)
+
+		if !strings.HasPrefix(buf.header.Get("Content-Type"), "text/html") && !(buf.header.Get("Content-Type") == "" && bytes.Contains(buf.body.Bytes(), []byte("<form"))) {
+			buf.flush(w)
+			return
+		}
+
+		body := injectCSRFToken(buf.body.Bytes(), token)
+		buf.body.Reset()
+		buf.body.Write(body)
+		buf.header.Del("Content-Length")
+		buf.flush(w)
+	})
+}
+
+func injectCSRFToken(body []byte, token string) []byte {
+	input := []byte(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`, csrfFieldName, html.EscapeString(token)))
+	body = csrfFormRegexp.ReplaceAllFunc(body, func(form []byte) []byte {
+		return append(append([]byte{}, form...), input...)
+	})
+
+	head := []byte(fmt.Sprintf(`<meta name="csrf-token" content="%s">%s`, html.EscapeString(token), csrfScript))
+	if i := bytes.Index(bytes.ToLower(body), []byte("</head>")); i >= 0 {
+		return append(body[:i], append(head, body[i:]...)...)
+	}
+	return append(head, body...)
+}
+
+// sameSiteResponseWriter adds SameSite attribute to cookies set without one (eg. by umbrella)
+type sameSiteResponseWriter struct {
+	http.ResponseWriter
+	sameSite    string
+	wroteHeader bool
+}
+
+func (s *sameSiteResponseWriter) rewriteCookies() {
+	if s.wroteHeader {
+		return
+	}
+	s.wroteHeader = true
+	cookies := s.Header().Values("Set-Cookie")
+	s.Header().Del("Set-Cookie")
+	for _, c := range cookies {
+		if !strings.Contains(strings.ToLower(c), "samesite=") {
+			c = fmt.Sprintf("%s; SameSite=%s", c, s.sameSite)
+		}
+		s.Header().Add("Set-Cookie", c)
+	}
+}
+
+func (s *sameSiteResponseWriter) WriteHeader(code int) {
+	s.rewriteCookies()
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *sameSiteResponseWriter) Write(b []byte) (int, error) {
+	s.rewriteCookies()
+	return s.ResponseWriter.Write(b)
+}
+
+// wrapHandlerWithSameSiteCookies sets default SameSite attribute on cookies written by handler
+func (p *Prototype) wrapHandlerWithSameSiteCookies(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &sameSiteResponseWriter{ResponseWriter: w, sameSite: p.cookieSameSite}
+		h.ServeHTTP(sw, r)
+		sw.rewriteCookies()
+	})
+}
@@ -0,0 +1,91 @@
+package prototyping
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestCSRFPrototype() *Prototype {
+	return &Prototype{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		uriUI:  "/ui/",
+		csrf:   true,
+	}
+}
+
+func TestLoginPageGetsCSRFToken(t *testing.T) {
+	p := newTestCSRFPrototype()
+	h := p.wrapHandlerWithCSRFToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head></head><body><form method="post" action="/ui/r/login/"></form></body></html>`))
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ui/login/", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName {
+		t.Fatalf("expected csrf cookie, got %v", cookies)
+	}
+	input := `<input type="hidden" name="` + csrfFieldName + `" value="` + cookies[0].Value + `">`
+	if !strings.Contains(rec.Body.String(), input) {
+		t.Fatalf("expected token in the form, got %s", rec.Body.String())
+	}
+}
+
+func TestLoginCSRFCheck(t *testing.T) {
+	p := newTestCSRFPrototype()
+	h := p.wrapLoginHandlerWithCSRFCheck(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.PostFormValue("email")))
+	}))
+
+	token := strings.Repeat("a", 48)
+	tests := []struct {
+		name   string
+		cookie string
+		field  string
+		header string
+		status int
+	}{
+		{name: "valid field", cookie: token, field: token, status: http.StatusOK},
+		{name: "valid header", cookie: token, header: token, status: http.StatusOK},
+		{name: "missing cookie", field: token, status: http.StatusForbidden},
+		{name: "missing token", cookie: token, status: http.StatusForbidden},
+		{name: "other token", cookie: token, field: strings.Repeat("b", 48), status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"email": {"user@example.com"}, "password": {"secret"}}
+			if tt.field != "" {
+				form.Set(csrfFieldName, tt.field)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/ui/r/login/", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				r.Header.Set(csrfHeaderName, tt.header)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, rec.Code)
+			}
+			if tt.status == http.StatusOK && rec.Body.String() != "user@example.com" {
+				t.Fatalf("expected login handler to read the form, got %q", rec.Body.String())
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ui/r/login/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected get request to pass, got %d", rec.Code)
+	}
+}
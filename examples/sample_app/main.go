@@ -1,14 +1,11 @@
 package main
 
 import (
-	"database/sql"
-	"fmt"
 	"log"
-	_ "os"
+	"os"
 	_ "time"
 
 	ui "github.com/go-phings/crud-ui"
-	stdb "github.com/go-phings/struct-db-postgres"
 	"github.com/go-phings/umbrella"
 	"github.com/mikolajgs/prototyping"
 
@@ -18,46 +15,41 @@ import (
 const dbDSN = "host=localhost user=protouser password=protopass port=54320 dbname=protodb sslmode=disable"
 
 func main() {
-	p, err := prototyping.NewPrototype(
-		prototyping.Config{
-			DatabaseDSN:     dbDSN,
-			UserConstructor: func() interface{} { return &User{} },
-			IntFieldValues: map[string]ui.IntFieldValues{
-				"Session_Flags": {
-					Type:   ui.ValuesSingleChoice,
-					Values: umbrella.GetSessionFlagsSingleChoice(),
-				},
-				"Permission_Flags": {
-					Type:   ui.ValuesMultipleBitChoice,
-					Values: umbrella.GetPermissionFlagsMultipleBitChoice(),
-				},
-				"Permission_ForType": {
-					Type:   ui.ValuesSingleChoice,
-					Values: umbrella.GetPermissionForTypeSingleChoice(),
-				},
-				"Permission_Ops": {
-					Type:   ui.ValuesMultipleBitChoice,
-					Values: umbrella.GetPermissionOpsMultipleBitChoice(),
-				},
-				"User_Flags": {
-					Type:   ui.ValuesMultipleBitChoice,
-					Values: GetUserFlagsMultipleBitChoice(),
-				},
-			},
-			StringFieldValues: map[string]ui.StringFieldValues{
-				"Permission_ToType": {
-					Type: ui.ValuesSingleChoice,
-					Values: map[string]string{
-						"all":        "all",
-						"User":       "User",
-						"Session":    "Session",
-						"Permission": "Permission",
-						"Item":       "Item",
-						"ItemGroup":  "ItemGroup",
-					},
-				},
-			},
+	// config is read from PROTO_* environment variables and the file in PROTO_CONFIG_FILE, eg. PROTO_DATABASE_DSN
+	cfg, err := prototyping.LoadConfig(os.Getenv("PROTO_CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("error loading config: %s", err.Error())
+	}
+	if cfg.DatabaseDSN == "" {
+		cfg.DatabaseDSN = dbDSN
+	}
+	cfg.UserConstructor = func() interface{} { return &User{} }
+	cfg.GRPC = true
+	cfg.IntFieldValues = map[string]ui.IntFieldValues{
+		"Session_Flags": {
+			Type:   ui.ValuesSingleChoice,
+			Values: umbrella.GetSessionFlagsSingleChoice(),
+		},
+		"User_Flags": {
+			Type:   ui.ValuesMultipleBitChoice,
+			Values: GetUserFlagsMultipleBitChoice(),
 		},
+		"APIKey_Flags": {
+			Type:   ui.ValuesMultipleBitChoice,
+			Values: prototyping.GetAPIKeyFlagsMultipleBitChoice(),
+		},
+		"UserTOTP_Flags": {
+			Type:   ui.ValuesMultipleBitChoice,
+			Values: prototyping.GetUserTOTPFlagsMultipleBitChoice(),
+		},
+		"APIKey_Ops": {
+			Type:   ui.ValuesMultipleBitChoice,
+			Values: umbrella.GetPermissionOpsMultipleBitChoice(),
+		},
+	}
+
+	p, err := prototyping.NewPrototype(
+		cfg,
 		func() interface{} { return &Item{} },
 		func() interface{} { return &ItemGroup{} },
 	)
@@ -65,36 +57,29 @@ func main() {
 		log.Fatalf("error creating new prototype: %s", err.Error())
 	}
 
+	// "export-proto", "generate-ts <dir>" and "generate-go-client <package>" generate code instead of running
+	ok, err := p.RunCommand(os.Args[1:])
+	if err != nil {
+		log.Fatalf("error running command: %s", err.Error())
+	}
+	if ok {
+		return
+	}
+
 	err = p.CreateDB()
 	if err != nil {
 		log.Fatalf("error creating database: %s", err.Error())
 	}
 
 	// creating dummy objects in the database
-	db, err := sql.Open("postgres", dbDSN)
+	err = p.Seed(
+		prototyping.SeedFile("fixtures.yaml"),
+		p.FakeFixtures(func() interface{} { return &Item{} }, 301),
+		p.FakeFixtures(func() interface{} { return &ItemGroup{} }, 73),
+	)
 	if err != nil {
-		log.Fatal("Error connecting to db")
-	}
-	// proto_ is the default db table prefix (will be configurable later)
-	s2db := stdb.NewController(db, "proto_", nil)
-	item := &Item{}
-	itemGroup := &ItemGroup{}
-	for i := 0; i < 301; i++ {
-		item.ID = 0
-		item.Flags = int64(i)
-		item.Title = fmt.Sprintf("Item %d", i)
-		item.Text = fmt.Sprintf("Description %d", i)
-		s2db.Save(item, stdb.SaveOptions{})
-	}
-	for i := 0; i < 73; i++ {
-		itemGroup.ID = 0
-		itemGroup.Flags = int64(i)
-		itemGroup.Name = fmt.Sprintf("Name %d", i)
-		itemGroup.Description = fmt.Sprintf("Description %d", i)
-		s2db.Save(itemGroup, stdb.SaveOptions{})
+		log.Fatalf("error seeding database: %s", err.Error())
 	}
-	db.Close()
-	// end of creating dummy objects
 
 	err = p.Run()
 	if err != nil {
@@ -2,9 +2,9 @@ package main
 
 type User struct {
 	ID                 int64  `json:"user_id"`
-	Flags              int64  `json:"flags"`
+	Flags              int64  `json:"flags" perm:"write"`
 	Name               string `json:"name" ui:"lenmin:0 lenmax:50"`
-	Email              string `json:"email" ui:"req"`
+	Email              string `json:"email" ui:"req" perm:"read"`
 	Password           string `json:"password" ui:"hidden password uipassword dblentry"`
 	EmailActivationKey string `json:"email_activation_key" ui:"hidden"`
 	CreatedAt          int64  `json:"created_at"`
@@ -0,0 +1,250 @@
+package prototyping
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+)
+
+var (
+	fakeFirstNames = []string{"Alice", "Bob", "Carol", "David", "Emma", "Frank", "Grace", "Henry", "Irene", "Jack", "Kate", "Liam", "Maria", "Noah", "Olivia", "Peter"}
+	fakeLastNames  = []string{"Smith", "Johnson", "Brown", "Taylor", "Miller", "Wilson", "Moore", "Clark", "Lewis", "Walker", "Hall", "Young", "King", "Wright"}
+	fakeWords      = []string{"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit", "sed", "do", "eiusmod", "tempor", "incididunt", "ut", "labore", "et", "dolore", "magna", "aliqua", "enim", "minim", "veniam", "quis", "nostrud"}
+	fakeCities     = []string{"London", "Paris", "Berlin", "Madrid", "Rome", "Warsaw", "Vienna", "Prague", "Lisbon", "Dublin", "Oslo", "Helsinki"}
+	fakeCountries  = []string{"United Kingdom", "France", "Germany", "Spain", "Italy", "Poland", "Austria", "Czechia", "Portugal", "Ireland", "Norway", "Finland"}
+	fakeStreets    = []string{"High Street", "Station Road", "Main Street", "Park Road", "Church Lane", "Victoria Road", "Green Lane", "Mill Lane"}
+	fakeCompanies  = []string{"Acme", "Globex", "Initech", "Umbrella", "Stark", "Wayne", "Wonka", "Hooli", "Vandelay", "Soylent"}
+)
+
+type fakeConstraints struct {
+	min, max             int
+	valMin, valMax       float64
+	hasValMin, hasValMax bool
+	email, uniq, req     bool
+	hidden, password     bool
+}
+
+func getFakeConstraints(tag string) fakeConstraints {
+	c := fakeConstraints{}
+	for _, s := range strings.Fields(tag) {
+		name, value, _ := strings.Cut(s, ":")
+		switch name {
+		case "req":
+			c.req = true
+		case "uniq":
+			c.uniq = true
+		case "email":
+			c.email = true
+		case "hidden":
+			c.hidden = true
+		case "password":
+			c.password = true
+		case "lenmin":
+			c.min, _ = strconv.Atoi(value)
+		case "lenmax":
+			c.max, _ = strconv.Atoi(value)
+		case "valmin":
+			v, err := strconv.ParseFloat(value, 64)
+			c.valMin, c.hasValMin = v, err == nil
+		case "valmax":
+			v, err := strconv.ParseFloat(value, 64)
+			c.valMax, c.hasValMax = v, err == nil
+		}
+	}
+	return c
+}
+
+func pickFake(rnd *rand.Rand, values []string) string {
+	return values[rnd.Intn(len(values))]
+}
+
+func getFakeWords(rnd *rand.Rand, n int) string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = pickFake(rnd, fakeWords)
+	}
+	return strings.Join(words, " ")
+}
+
+// getFakeString returns a string based on the field name. i is added to values that should be unique.
+func getFakeString(rnd *rand.Rand, name string, c fakeConstraints, isPerson bool, i int) string {
+	n := strings.ToLower(name)
+	first, last := pickFake(rnd, fakeFirstNames), pickFake(rnd, fakeLastNames)
+	switch {
+	case c.email || strings.Contains(n, "email"):
+		return fmt.Sprintf("%s.%s%d@example.com", strings.ToLower(first), strings.ToLower(last), i)
+	case c.password:
+		return "password"
+	case strings.Contains(n, "firstname"):
+		return first
+	case strings.Contains(n, "lastname"), strings.Contains(n, "surname"):
+		return last
+	case n == "name" && isPerson, strings.Contains(n, "fullname"), strings.Contains(n, "author"):
+		return first + " " + last
+	case strings.Contains(n, "company"):
+		return pickFake(rnd, fakeCompanies) + " Ltd"
+	case strings.Contains(n, "city"):
+		return pickFake(rnd, fakeCities)
+	case strings.Contains(n, "country"):
+		return pickFake(rnd, fakeCountries)
+	case strings.Contains(n, "street"), strings.Contains(n, "address"):
+		return fmt.Sprintf("%d %s", rnd.Intn(200)+1, pickFake(rnd, fakeStreets))
+	case strings.Contains(n, "zip"), strings.Contains(n, "postcode"), strings.Contains(n, "postal"):
+		return fmt.Sprintf("%05d", rnd.Intn(100000))
+	case strings.Contains(n, "phone"):
+		return fmt.Sprintf("+1 555 %04d", rnd.Intn(10000))
+	case strings.Contains(n, "slug"):
+		return fmt.Sprintf("%s-%d", strings.ReplaceAll(getFakeWords(rnd, 2), " ", "-"), i)
+	case strings.Contains(n, "url"), strings.Contains(n, "website"), strings.Contains(n, "link"):
+		return fmt.Sprintf("https://example.com/%s-%d", pickFake(rnd, fakeWords), i)
+	case strings.Contains(n, "color"), strings.Contains(n, "colour"):
+		return fmt.Sprintf("#%06x", rnd.Intn(0x1000000))
+	case strings.Contains(n, "title"), n == "name", strings.HasSuffix(n, "name"), strings.Contains(n, "subject"):
+		s := getFakeWords(rnd, 2+rnd.Intn(3))
+		return fmt.Sprintf("%s%s %d", strings.ToUpper(s[:1]), s[1:], i)
+	case strings.Contains(n, "description"), strings.Contains(n, "text"), strings.Contains(n, "body"),
+		strings.Contains(n, "content"), strings.Contains(n, "summary"), strings.Contains(n, "note"), strings.Contains(n, "comment"):
+		s := getFakeWords(rnd, 8+rnd.Intn(12))
+		return strings.ToUpper(s[:1]) + s[1:] + "."
+	}
+	return fmt.Sprintf("%s %d", getFakeWords(rnd, 2), i)
+}
+
+// getFakeNumber returns a number based on the field name
+func getFakeNumber(rnd *rand.Rand, name string, c fakeConstraints, isFloat bool) float64 {
+	n := strings.ToLower(name)
+	min, max := 0.0, 1000.0
+	switch {
+	case n == "age":
+		min, max = 18, 80
+	case strings.Contains(n, "year"):
+		min, max = 1990, 2025
+	case strings.Contains(n, "rating"), strings.Contains(n, "stars"):
+		min, max = 1, 5
+	case strings.Contains(n, "percent"):
+		min, max = 0, 100
+	case strings.Contains(n, "quantity"), strings.Contains(n, "count"), strings.Contains(n, "stock"):
+		min, max = 0, 100
+	case strings.Contains(n, "price"), strings.Contains(n, "amount"), strings.Contains(n, "cost"), strings.Contains(n, "total"):
+		min, max = 1, 500
+	}
+	if c.hasValMin {
+		min = c.valMin
+	}
+	if c.hasValMax {
+		max = c.valMax
+	}
+	if max < min {
+		max = min
+	}
+	v := min + rnd.Float64()*(max-min)
+	if isFloat {
+		return float64(int64(v*100)) / 100
+	}
+	return float64(int64(v))
+}
+
+// fitFakeString makes value meet the length constraints. Unique values keep the suffix with the index.
+func fitFakeString(rnd *rand.Rand, value string, c fakeConstraints, i int) string {
+	for c.min > 0 && len(value) < c.min {
+		value += " " + pickFake(rnd, fakeWords)
+	}
+	if c.max > 0 && len(value) > c.max {
+		suffix := ""
+		if c.uniq {
+			suffix = strconv.Itoa(i)
+		}
+		if len(suffix) > c.max {
+			suffix = ""
+		}
+		value = strings.TrimSpace(value[:c.max-len(suffix)]) + suffix
+	}
+	return value
+}
+
+// FakeFixtures returns n fixtures of a struct with realistic values derived from field names and ui tag constraints.
+// Values are the same each time, so seeding them again updates the same objects. Fields named after a struct and
+// ID, eg. ItemGroupID, are set to ID of a random existing object of that struct.
+func (p *Prototype) FakeFixtures(constructor func() interface{}, n int) SeedFunc {
+	return func() ([]Fixture, error) {
+		s := getModelStruct(constructor)
+		t := reflect.TypeOf(constructor()).Elem()
+
+		structs := map[string]func() interface{}{}
+		for _, f := range p.getExposedConstructors() {
+			structs[sqldb.GetStructName(f())] = f
+		}
+
+		h := fnv.New64a()
+		h.Write([]byte(s.name))
+		rnd := rand.New(rand.NewSource(int64(h.Sum64())))
+
+		isPerson := false
+		for _, field := range s.fields {
+			if strings.Contains(strings.ToLower(field.name), "email") {
+				isPerson = true
+			}
+		}
+
+		ids := map[string][]int64{}
+
+		fixtures := []Fixture{}
+		for i := 1; i <= n; i++ {
+			values := map[string]interface{}{}
+			for _, field := range s.fields {
+				switch field.name {
+				case "ID", "Flags", "CreatedAt", "CreatedBy", "LastModifiedAt", "LastModifiedBy":
+					continue
+				}
+				sf, _ := t.FieldByName(field.name)
+				c := getFakeConstraints(sf.Tag.Get("ui"))
+				c.password = c.password || field.password
+				if c.hidden && !c.req && !c.password {
+					continue
+				}
+
+				if ref, ok := structs[strings.TrimSuffix(field.name, "ID")]; ok && strings.HasSuffix(field.name, "ID") {
+					if _, ok := ids[field.name]; !ok {
+						objs, err := p.orm.Get(ref, []string{"ID", "asc"}, 100, 0, nil, nil)
+						if err != nil {
+							return nil, fmt.Errorf("error getting objects for %s: %w", field.name, err)
+						}
+						for _, o := range objs {
+							ids[field.name] = append(ids[field.name], reflect.ValueOf(o).Elem().FieldByName("ID").Int())
+						}
+					}
+					if len(ids[field.name]) > 0 {
+						values[field.jsonName] = ids[field.name][rnd.Intn(len(ids[field.name]))]
+					}
+					continue
+				}
+
+				switch field.typ.Kind() {
+				case reflect.String:
+					values[field.jsonName] = fitFakeString(rnd, getFakeString(rnd, field.name, c, isPerson, i), c, i)
+				case reflect.Bool:
+					values[field.jsonName] = rnd.Intn(2) == 1
+				case reflect.Float32, reflect.Float64:
+					values[field.jsonName] = getFakeNumber(rnd, field.name, c, true)
+				default:
+					values[field.jsonName] = getFakeNumber(rnd, field.name, c, false)
+				}
+			}
+
+			// string values identify the fixture as the references to random objects change when the data changes
+			key := []string{}
+			for _, field := range s.fields {
+				if _, ok := values[field.jsonName].(string); ok && !field.password {
+					key = append(key, field.name)
+				}
+			}
+			fixtures = append(fixtures, Fixture{Struct: s.name, Values: values, Key: key})
+		}
+		return fixtures, nil
+	}
+}
@@ -0,0 +1,303 @@
+package prototyping
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+	"github.com/go-phings/umbrella"
+)
+
+// fieldRule restricts reading or writing a struct field to users that have a permission to a "Struct.Field" type.
+// Rules are declared with the perm tag, eg. `perm:"read write"`. Field with `perm:"readonly"` cannot be written by
+// anyone through the generic handlers.
+type fieldRule struct {
+	name     string
+	jsonName string
+	read     bool
+	write    bool
+	readOnly bool
+}
+
+// getFieldRules returns restricted fields of each struct
+func getFieldRules(constructors []func() interface{}) map[string][]fieldRule {
+	rules := map[string][]fieldRule{}
+	for _, f := range constructors {
+		o := f()
+		t := reflect.TypeOf(o)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			continue
+		}
+		structName := sqldb.GetStructName(o)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag, ok := field.Tag.Lookup("perm")
+			if !ok {
+				continue
+			}
+			rule := fieldRule{
+				name:     field.Name,
+				jsonName: strings.Split(field.Tag.Get("json"), ",")[0],
+			}
+			if rule.jsonName == "" {
+				rule.jsonName = field.Name
+			}
+			for _, s := range strings.Fields(tag) {
+				switch s {
+				case "read":
+					rule.read = true
+				case "write":
+					rule.write = true
+				case "readonly":
+					rule.readOnly = true
+				}
+			}
+			if rule.read || rule.write || rule.readOnly {
+				rules[structName] = append(rules[structName], rule)
+			}
+		}
+	}
+	return rules
+}
+
+// isFieldAllowed checks whether user has a permission to a field for specific operation. Permission row can be
+// given to a "Struct.Field" type, and "all" type allows all the fields.
+func (lu *loggedUser) isFieldAllowed(op int, structName string, field string) bool {
+	return isTypeAllowed(lu.allowedTypes[op], fmt.Sprintf("%s.%s", structName, field))
+}
+
+// getDeniedFields returns fields that user cannot read and cannot write in a specific struct
+func (p *Prototype) getDeniedFields(lu *loggedUser, structName string, writeOp int) ([]fieldRule, []fieldRule) {
+	var denyRead, denyWrite []fieldRule
+	for _, rule := range p.fieldRules[structName] {
+		if rule.read && !lu.isFieldAllowed(umbrella.OpsRead, structName, rule.name) {
+			denyRead = append(denyRead, rule)
+		}
+		if rule.readOnly || (rule.write && !lu.isFieldAllowed(writeOp, structName, rule.name)) {
+			denyWrite = append(denyWrite, rule)
+		}
+	}
+	return denyRead, denyWrite
+}
+
+// wrapHandlerWithFieldPermissions rejects writes to restricted fields and removes restricted fields from API
+// responses and UI forms. It must be wrapped with wrapHandlerWithUmbrella as it needs the logged user.
+func (p *Prototype) wrapHandlerWithFieldPermissions(uriType int, uri string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		structName := getStructNameFromURI(uri, r.URL.Path, p.structNames)
+		lu := getLoggedUserFromContext(r.Context())
+		if structName == "" || lu == nil || len(p.fieldRules[structName]) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		writeOp := umbrella.OpsUpdate
+		if getOperationFromRequest(uri, r) == "create" {
+			writeOp = umbrella.OpsCreate
+		}
+		denyRead, denyWrite := p.getDeniedFields(lu, structName, writeOp)
+
+		if len(denyWrite) > 0 && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			fields, err := getRequestFields(uriType, r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("InvalidBody"))
+				return
+			}
+			for name := range fields {
+				if isFieldDenied(denyWrite, name) {
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte("FieldNotAllowed"))
+					return
+				}
+			}
+		}
+
+		if len(denyRead) > 0 && r.Method == http.MethodGet {
+			for k, v := range r.URL.Query() {
+				if isFieldDenied(denyRead, strings.TrimPrefix(k, "filter_")) || (k == "order" && len(v) > 0 && isFieldDenied(denyRead, v[0])) {
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte("FieldNotAllowed"))
+					return
+				}
+			}
+		}
+
+		if len(denyRead) == 0 && (uriType == uriAPI || len(denyWrite) == 0) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		buf := &bufferedResponse{header: http.Header{}}
+		h.ServeHTTP(buf, r)
+
+		var body []byte
+		if uriType == uriAPI {
+			body = maskJSONFields(buf.body.Bytes(), denyRead)
+		} else {
+			body = maskFormFields(buf.body.Bytes(), denyRead, denyWrite)
+		}
+		buf.body.Reset()
+		buf.body.Write(body)
+		buf.header.Del("Content-Length")
+		buf.flush(w)
+	})
+}
+
+// isFieldDenied checks whether name matches any of the rules. Names are compared case-insensitively as JSON keys
+// are matched to struct fields that way.
+func isFieldDenied(rules []fieldRule, name string) bool {
+	for _, rule := range rules {
+		if strings.EqualFold(name, rule.name) || strings.EqualFold(name, rule.jsonName) {
+			return true
+		}
+	}
+	return false
+}
+
+// getRequestFields returns names of the fields sent in the JSON body of API request, or in the form submitted in UI
+func getRequestFields(uriType int, r *http.Request) (map[string]bool, error) {
+	fields := map[string]bool{}
+	if uriType == uriUI {
+		err := r.ParseForm()
+		if err != nil {
+			return nil, err
+		}
+		for k := range r.PostForm {
+			fields[k] = true
+		}
+		return fields, nil
+	}
+
+	if r.Body == nil {
+		return fields, nil
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	if len(bytes.TrimSpace(b)) == 0 {
+		return fields, nil
+	}
+
+	m := map[string]json.RawMessage{}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, err
+	}
+	for k := range m {
+		fields[k] = true
+	}
+	return fields, nil
+}
+
+// maskJSONFields removes fields from all the objects in the JSON response. Body is returned unchanged when it is not
+// JSON.
+func maskJSONFields(body []byte, fields []fieldRule) []byte {
+	var v interface{}
+	if json.Unmarshal(body, &v) != nil {
+		return body
+	}
+	names := map[string]bool{}
+	for _, rule := range fields {
+		names[rule.jsonName] = true
+	}
+	b, err := json.Marshal(removeJSONFields(v, names))
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+func removeJSONFields(v interface{}, names map[string]bool) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, c := range t {
+			if names[k] {
+				delete(t, k)
+				continue
+			}
+			t[k] = removeJSONFields(c, names)
+		}
+	case []interface{}:
+		for i, c := range t {
+			t[i] = removeJSONFields(c, names)
+		}
+	}
+	return v
+}
+
+var formControlRegexp = regexp.MustCompile(`(?is)<input\b[^>]*>|<select\b[^>]*>.*?</select>|<textarea\b[^>]*>.*?</textarea>`)
+var formControlNameRegexp = regexp.MustCompile(`(?i)^<(\w+)\b[^>]*\bname\s*=\s*["']?([^"'\s>]+)`)
+
+var tableRegexp = regexp.MustCompile(`(?is)<table\b.*?</table>`)
+var tableRowRegexp = regexp.MustCompile(`(?is)<tr\b.*?</tr>`)
+var tableCellRegexp = regexp.MustCompile(`(?is)(<t[hd]\b[^>]*>)(.*?)(</t[hd]>)`)
+var htmlTagRegexp = regexp.MustCompile(`(?s)<[^>]*>`)
+
+// maskFormFields replaces controls of fields that cannot be read with empty disabled inputs, and disables controls
+// of fields that cannot be written so that browser does not submit them. Cells of the list table columns that cannot
+// be read are emptied.
+func maskFormFields(body []byte, denyRead []fieldRule, denyWrite []fieldRule) []byte {
+	if len(denyRead) > 0 {
+		body = tableRegexp.ReplaceAllFunc(body, func(table []byte) []byte {
+			return maskTableColumns(table, denyRead)
+		})
+	}
+
+	return formControlRegexp.ReplaceAllFunc(body, func(control []byte) []byte {
+		m := formControlNameRegexp.FindSubmatch(control)
+		if m == nil {
+			return control
+		}
+		name := string(m[2])
+		if isFieldDenied(denyRead, name) {
+			return []byte(`<input type="text" value="" disabled>`)
+		}
+		if isFieldDenied(denyWrite, name) {
+			i := len(m[1]) + 1
+			return append(append(append([]byte{}, control[:i]...), []byte(" disabled")...), control[i:]...)
+		}
+		return control
+	})
+}
+
+// maskTableColumns empties cells of the columns which header is a denied field
+func maskTableColumns(table []byte, denyRead []fieldRule) []byte {
+	masked := map[int]bool{}
+	header := true
+	return tableRowRegexp.ReplaceAllFunc(table, func(row []byte) []byte {
+		if header {
+			for i, cell := range tableCellRegexp.FindAllSubmatch(row, -1) {
+				name := strings.TrimSpace(string(htmlTagRegexp.ReplaceAll(cell[2], nil)))
+				if isFieldDenied(denyRead, name) {
+					masked[i] = true
+				}
+			}
+			header = false
+			return row
+		}
+		if len(masked) == 0 {
+			return row
+		}
+		i := -1
+		return tableCellRegexp.ReplaceAllFunc(row, func(cell []byte) []byte {
+			i++
+			if !masked[i] {
+				return cell
+			}
+			m := tableCellRegexp.FindSubmatch(cell)
+			return append(append([]byte{}, m[1]...), m[3]...)
+		})
+	})
+}
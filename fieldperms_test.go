@@ -0,0 +1,170 @@
+package prototyping
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-phings/umbrella"
+)
+
+type testFieldItem struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Price   int    `json:"price" perm:"read write"`
+	Created int64  `json:"created" perm:"readonly"`
+}
+
+func newTestFieldPermissionsHandler(uriType int, uri string, lu *loggedUser, body string) (http.Handler, *bool) {
+	constructors := []func() interface{}{func() interface{} { return &testFieldItem{} }}
+	p := &Prototype{
+		structNames: map[string]bool{"testFieldItem": true},
+		fieldRules:  getFieldRules(constructors),
+	}
+	called := false
+	h := p.wrapHandlerWithFieldPermissions(uriType, uri, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Write([]byte(body))
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), loggedUserContextKey, lu)))
+	}), &called
+}
+
+func newTestLoggedUser(types ...string) *loggedUser {
+	lu := &loggedUser{id: 1, allowedTypes: map[int]map[string]bool{}}
+	for _, o := range []int{umbrella.OpsList, umbrella.OpsRead, umbrella.OpsCreate, umbrella.OpsUpdate, umbrella.OpsDelete} {
+		lu.allowedTypes[o] = map[string]bool{}
+		for _, t := range types {
+			lu.allowedTypes[o][t] = true
+		}
+	}
+	return lu
+}
+
+func TestGetFieldRules(t *testing.T) {
+	rules := getFieldRules([]func() interface{}{func() interface{} { return &testFieldItem{} }})["testFieldItem"]
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, got %v", rules)
+	}
+	if rules[0] != (fieldRule{name: "Price", jsonName: "price", read: true, write: true}) {
+		t.Errorf("invalid rule: %+v", rules[0])
+	}
+	if rules[1] != (fieldRule{name: "Created", jsonName: "created", readOnly: true}) {
+		t.Errorf("invalid rule: %+v", rules[1])
+	}
+}
+
+func TestFieldPermissionsWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		uriType int
+		types   []string
+		body    string
+		allowed bool
+	}{
+		{name: "allowed field", uriType: uriAPI, types: []string{"testFieldItem"}, body: `{"name":"a"}`, allowed: true},
+		{name: "denied field", uriType: uriAPI, types: []string{"testFieldItem"}, body: `{"name":"a","price":1}`},
+		{name: "denied field in another case", uriType: uriAPI, types: []string{"testFieldItem"}, body: `{"PRICE":1}`},
+		{name: "denied struct field name", uriType: uriAPI, types: []string{"testFieldItem"}, body: `{"Price":1}`},
+		{name: "field permission", uriType: uriAPI, types: []string{"testFieldItem", "testFieldItem.Price"}, body: `{"price":1}`, allowed: true},
+		{name: "read only field", uriType: uriAPI, types: []string{"all"}, body: `{"Created":1}`},
+		{name: "denied form field", uriType: uriUI, types: []string{"testFieldItem"}, body: "name=a&Price=1"},
+		{name: "allowed form field", uriType: uriUI, types: []string{"testFieldItem"}, body: "name=a", allowed: true},
+		{name: "invalid body", uriType: uriAPI, types: []string{"testFieldItem"}, body: `[1]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, called := newTestFieldPermissionsHandler(tt.uriType, "/api/", newTestLoggedUser(tt.types...), "")
+			r := httptest.NewRequest(http.MethodPut, "/api/testFieldItem/1", strings.NewReader(tt.body))
+			if tt.uriType == uriUI {
+				r = httptest.NewRequest(http.MethodPost, "/api/testFieldItem/1", strings.NewReader(tt.body))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if *called != tt.allowed {
+				t.Fatalf("expected request to be allowed %v, got %d %s", tt.allowed, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestFieldPermissionsQuery(t *testing.T) {
+	for _, q := range []url.Values{
+		{"filter_price": {"1"}},
+		{"filter_Price": {"1"}},
+		{"order": {"price"}},
+		{"order": {"PRICE"}, "order_direction": {"desc"}},
+	} {
+		h, called := newTestFieldPermissionsHandler(uriAPI, "/api/", newTestLoggedUser("testFieldItem"), "[]")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/testFieldItem/?"+q.Encode(), nil))
+		if *called || rec.Code != http.StatusForbidden {
+			t.Errorf("expected %s to be rejected, got %d", q.Encode(), rec.Code)
+		}
+
+		h, called = newTestFieldPermissionsHandler(uriAPI, "/api/", newTestLoggedUser("testFieldItem", "testFieldItem.Price"), "[]")
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/testFieldItem/?"+q.Encode(), nil))
+		if !*called {
+			t.Errorf("expected %s to be allowed with field permission", q.Encode())
+		}
+	}
+
+	h, called := newTestFieldPermissionsHandler(uriAPI, "/api/", newTestLoggedUser("testFieldItem"), "[]")
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/testFieldItem/?filter_name=a&order=name", nil))
+	if !*called {
+		t.Error("expected query on allowed fields to pass")
+	}
+}
+
+func TestFieldPermissionsMaskResponse(t *testing.T) {
+	h, _ := newTestFieldPermissionsHandler(uriAPI, "/api/", newTestLoggedUser("testFieldItem"), `{"items":[{"id":1,"name":"a","price":5}]}`)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/testFieldItem/", nil))
+	if rec.Body.String() != `{"items":[{"id":1,"name":"a"}]}` {
+		t.Fatalf("expected price to be removed, got %s", rec.Body.String())
+	}
+
+	h, _ = newTestFieldPermissionsHandler(uriAPI, "/api/", newTestLoggedUser("testFieldItem", "testFieldItem.Price"), `{"id":1,"price":5}`)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/testFieldItem/1", nil))
+	if rec.Body.String() != `{"id":1,"price":5}` {
+		t.Fatalf("expected price to be returned, got %s", rec.Body.String())
+	}
+}
+
+func TestMaskFormFields(t *testing.T) {
+	rules := getFieldRules([]func() interface{}{func() interface{} { return &testFieldItem{} }})["testFieldItem"]
+	body := `<form><input name="Name" value="a"><input type="text" name="Price" value="5"><textarea name="Created">1</textarea></form>`
+	masked := string(maskFormFields([]byte(body), rules[:1], rules[1:]))
+	expected := `<form><input name="Name" value="a"><input type="text" value="" disabled><textarea disabled name="Created">1</textarea></form>`
+	if masked != expected {
+		t.Fatalf("expected %s, got %s", expected, masked)
+	}
+}
+
+func TestMaskTableColumns(t *testing.T) {
+	rules := getFieldRules([]func() interface{}{func() interface{} { return &testFieldItem{} }})["testFieldItem"][:1]
+	table := `<table>
+<tr><th>ID</th><th><a href="?order=price">PRICE</a></th><th>Name</th></tr>
+<tr><td>1</td><td class="num">5</td><td>a</td></tr>
+<tr><td>2</td><td>7</td><td>b</td></tr>
+</table>`
+	expected := `<table>
+<tr><th>ID</th><th><a href="?order=price">PRICE</a></th><th>Name</th></tr>
+<tr><td>1</td><td class="num"></td><td>a</td></tr>
+<tr><td>2</td><td></td><td>b</td></tr>
+</table>`
+	if masked := string(maskTableColumns([]byte(table), rules)); masked != expected {
+		t.Fatalf("expected %s, got %s", expected, masked)
+	}
+
+	other := `<table><tr><th>ID</th></tr><tr><td>5</td></tr></table>`
+	if masked := string(maskFormFields([]byte(other), rules, nil)); masked != other {
+		t.Fatalf("expected table without denied columns to be unchanged, got %s", masked)
+	}
+}
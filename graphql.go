@@ -0,0 +1,496 @@
+package prototyping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-phings/umbrella"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// graphQLInt64 is used for integer fields as GraphQL Int has only 32 bits
+var graphQLInt64 = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Int64",
+	Description: "64-bit integer",
+	Serialize: func(value interface{}) interface{} {
+		return toInt64(value)
+	},
+	ParseValue: func(value interface{}) interface{} {
+		return toInt64(value)
+	},
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		switch v := valueAST.(type) {
+		case *ast.IntValue:
+			i, err := strconv.ParseInt(v.Value, 10, 64)
+			if err != nil {
+				return nil
+			}
+			return i
+		case *ast.StringValue:
+			i, err := strconv.ParseInt(v.Value, 10, 64)
+			if err != nil {
+				return nil
+			}
+			return i
+		}
+		return nil
+	},
+})
+
+const (
+	// graphQLMaxDepth is the maximum nesting of selections in a query
+	graphQLMaxDepth = 8
+	// graphQLMaxCost is the maximum number of objects that a query can return, where lists count as their limit
+	graphQLMaxCost = 10000
+	// graphQLMaxListLimit is the maximum limit of a list query, the same as of getObjects
+	graphQLMaxListLimit = 1000
+	// graphQLMaxNestedLimit is the maximum limit of a list nested in another object
+	graphQLMaxNestedLimit = 100
+	graphQLDefaultLimit   = 10
+)
+
+type graphQLStruct struct {
+	*modelStruct
+	object *graphql.Object
+	input  *graphql.InputObject
+	filter *graphql.InputObject
+}
+
+// lowerFirst lowercases the leading uppercase letters so that eg. "APIKey" becomes "apiKey"
+func lowerFirst(s string) string {
+	r := []rune(s)
+	for i := 0; i < len(r); i++ {
+		if !unicode.IsUpper(r[i]) {
+			break
+		}
+		if i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			break
+		}
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
+
+func getGraphQLType(t reflect.Type) graphql.Output {
+	switch t.Kind() {
+	case reflect.String:
+		return graphql.String
+	case reflect.Bool:
+		return graphql.Boolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return graphQLInt64
+	case reflect.Float32, reflect.Float64:
+		return graphql.Float
+	}
+	return nil
+}
+
+// newGraphQLSchema generates schema with queries and mutations for each registered struct. Fields named like
+// <Struct>ID, where Struct is registered as well, become relationships in both directions.
+func (p *Prototype) newGraphQLSchema() (graphql.Schema, error) {
+	structs := map[string]*graphQLStruct{}
+	names := []string{}
+	for _, f := range p.getAPIConstructors() {
+		s := &graphQLStruct{modelStruct: getModelStruct(f)}
+		if _, ok := structs[s.name]; ok {
+			continue
+		}
+		structs[s.name] = s
+		names = append(names, s.name)
+	}
+
+	for _, name := range names {
+		s := structs[name]
+		s.object = graphql.NewObject(graphql.ObjectConfig{
+			Name:   s.name,
+			Fields: graphql.FieldsThunk(func() graphql.Fields { return p.getGraphQLObjectFields(s, structs, names) }),
+		})
+
+		inputFields := graphql.InputObjectConfigFieldMap{}
+		filterFields := graphql.InputObjectConfigFieldMap{}
+		for _, field := range s.fields {
+			typ := getGraphQLType(field.typ).(graphql.Input)
+			if field.name != "ID" {
+				inputFields[field.jsonName] = &graphql.InputObjectFieldConfig{Type: typ}
+			}
+			if !field.password {
+				filterFields[field.jsonName] = &graphql.InputObjectFieldConfig{Type: typ}
+			}
+		}
+		s.input = graphql.NewInputObject(graphql.InputObjectConfig{Name: s.name + "Input", Fields: inputFields})
+		s.filter = graphql.NewInputObject(graphql.InputObjectConfig{Name: s.name + "Filter", Fields: filterFields})
+	}
+
+	queries := graphql.Fields{}
+	mutations := graphql.Fields{}
+	for _, name := range names {
+		s := structs[name]
+		listArgs := graphql.FieldConfigArgument{
+			"limit":          &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: graphQLDefaultLimit},
+			"offset":         &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+			"order":          &graphql.ArgumentConfig{Type: graphql.String},
+			"orderDirection": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "asc"},
+			"filter":         &graphql.ArgumentConfig{Type: s.filter},
+		}
+
+		queries[lowerFirst(s.name)] = &graphql.Field{
+			Type: s.object,
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphQLInt64)},
+			},
+			Resolve: func(rp graphql.ResolveParams) (interface{}, error) {
+				if !isTypeAllowed(getAllowedTypesFromContext(rp.Context, umbrella.OpsRead), s.name) {
+					return nil, errors.New("NoAccess")
+				}
+				return p.loadObject(rp.Context, s.modelStruct, rp.Args["id"])
+			},
+		}
+		queries[lowerFirst(s.name)+"List"] = &graphql.Field{
+			Type: graphql.NewList(s.object),
+			Args: listArgs,
+			Resolve: func(rp graphql.ResolveParams) (interface{}, error) {
+				if !isTypeAllowed(getAllowedTypesFromContext(rp.Context, umbrella.OpsList), s.name) {
+					return nil, errors.New("NoAccess")
+				}
+				return p.getObjects(rp.Context, s.modelStruct, rp.Args, nil)
+			},
+		}
+		queries[lowerFirst(s.name)+"Count"] = &graphql.Field{
+			Type: graphQLInt64,
+			Args: graphql.FieldConfigArgument{
+				"filter": &graphql.ArgumentConfig{Type: s.filter},
+			},
+			Resolve: func(rp graphql.ResolveParams) (interface{}, error) {
+				if !isTypeAllowed(getAllowedTypesFromContext(rp.Context, umbrella.OpsList), s.name) {
+					return nil, errors.New("NoAccess")
+				}
+				filters, err := p.getReadableObjectFilters(rp.Context, s.modelStruct, rp.Args["filter"])
+				if err != nil {
+					return nil, err
+				}
+				return getORMFromContext(rp.Context).GetCount(s.constructor, filters)
+			},
+		}
+
+		mutations["create"+s.name] = &graphql.Field{
+			Type: s.object,
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(s.input)},
+			},
+			Resolve: func(rp graphql.ResolveParams) (interface{}, error) {
+				if !isTypeAllowed(getAllowedTypesFromContext(rp.Context, umbrella.OpsCreate), s.name) {
+					return nil, errors.New("NoAccess")
+				}
+				return p.saveObject(rp.Context, s.modelStruct, nil, rp.Args["input"])
+			},
+		}
+		mutations["update"+s.name] = &graphql.Field{
+			Type: s.object,
+			Args: graphql.FieldConfigArgument{
+				"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphQLInt64)},
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(s.input)},
+			},
+			Resolve: func(rp graphql.ResolveParams) (interface{}, error) {
+				if !isTypeAllowed(getAllowedTypesFromContext(rp.Context, umbrella.OpsUpdate), s.name) {
+					return nil, errors.New("NoAccess")
+				}
+				return p.saveObject(rp.Context, s.modelStruct, rp.Args["id"], rp.Args["input"])
+			},
+		}
+		mutations["delete"+s.name] = &graphql.Field{
+			Type: graphql.Boolean,
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphQLInt64)},
+			},
+			Resolve: func(rp graphql.ResolveParams) (interface{}, error) {
+				if !isTypeAllowed(getAllowedTypesFromContext(rp.Context, umbrella.OpsDelete), s.name) {
+					return nil, errors.New("NoAccess")
+				}
+				obj, err := p.loadObject(rp.Context, s.modelStruct, rp.Args["id"])
+				if err != nil {
+					return nil, err
+				}
+				if obj == nil {
+					return false, nil
+				}
+				err = getORMFromContext(rp.Context).Delete(obj)
+				if err != nil {
+					return nil, err
+				}
+				return true, nil
+			},
+		}
+	}
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: queries}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutations}),
+	})
+}
+
+// getGraphQLObjectFields returns fields of a struct's object type, including relationships to other structs
+func (p *Prototype) getGraphQLObjectFields(s *graphQLStruct, structs map[string]*graphQLStruct, names []string) graphql.Fields {
+	fields := graphql.Fields{}
+	for _, field := range s.fields {
+		if field.password {
+			continue
+		}
+		fields[field.jsonName] = &graphql.Field{
+			Type: getGraphQLType(field.typ),
+			Resolve: func(rp graphql.ResolveParams) (interface{}, error) {
+				if !p.isFieldReadable(rp.Context, s.name, field.name) {
+					return nil, nil
+				}
+				return reflect.ValueOf(rp.Source).Elem().FieldByName(field.name).Interface(), nil
+			},
+		}
+	}
+
+	// <Struct>ID fields point to objects of another struct
+	for _, field := range s.fields {
+		target, ok := structs[strings.TrimSuffix(field.name, "ID")]
+		if field.name == "ID" || !strings.HasSuffix(field.name, "ID") || !ok {
+			continue
+		}
+		name := lowerFirst(strings.TrimSuffix(field.name, "ID"))
+		if _, exists := fields[name]; exists {
+			continue
+		}
+		fields[name] = &graphql.Field{
+			Type: target.object,
+			Resolve: func(rp graphql.ResolveParams) (interface{}, error) {
+				if !isTypeAllowed(getAllowedTypesFromContext(rp.Context, umbrella.OpsRead), target.name) || !p.isFieldReadable(rp.Context, s.name, field.name) {
+					return nil, nil
+				}
+				id := reflect.ValueOf(rp.Source).Elem().FieldByName(field.name).Interface()
+				if toInt64(id) == int64(0) {
+					return nil, nil
+				}
+				return p.loadObject(rp.Context, target.modelStruct, id)
+			},
+		}
+	}
+
+	// and such struct gets a list of the objects that point to it
+	for _, sourceName := range names {
+		source := structs[sourceName]
+		for _, field := range source.fields {
+			if field.name != s.name+"ID" {
+				continue
+			}
+			name := lowerFirst(source.name) + "List"
+			if _, exists := fields[name]; exists {
+				name = fmt.Sprintf("%sBy%s", name, field.name)
+			}
+			fields[name] = &graphql.Field{
+				Type: graphql.NewList(source.object),
+				Args: graphql.FieldConfigArgument{
+					"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: graphQLDefaultLimit},
+					"offset": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+				},
+				Resolve: func(rp graphql.ResolveParams) (interface{}, error) {
+					if !isTypeAllowed(getAllowedTypesFromContext(rp.Context, umbrella.OpsList), source.name) || !p.isFieldReadable(rp.Context, source.name, field.name) {
+						return nil, nil
+					}
+					if limit, _ := rp.Args["limit"].(int); limit <= 0 || limit > graphQLMaxNestedLimit {
+						rp.Args["limit"] = graphQLMaxNestedLimit
+					}
+					id := reflect.ValueOf(rp.Source).Elem().FieldByName("ID").Interface()
+					return p.getObjects(rp.Context, source.modelStruct, rp.Args, map[string]interface{}{field.name: id})
+				},
+			}
+		}
+	}
+	return fields
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// getGraphQLHTTPHandler returns handler that executes GraphQL queries sent with POST. Resolvers use the ORM of the
+// request.
+func (p *Prototype) getGraphQLHTTPHandler(schema graphql.Schema) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		b, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req := &graphQLRequest{}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/graphql") {
+			req.Query = string(b)
+		} else if err := json.Unmarshal(b, req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("InvalidBody"))
+			return
+		}
+
+		err = checkGraphQLQuery(schema, req.Query, req.Variables)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(&graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(err.Error())}})
+			return
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        context.WithValue(r.Context(), ormContextKey, p.getRequestORM(r.Context())),
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+// checkGraphQLQuery rejects queries that are nested too deep or that can return too many objects. Syntax errors are
+// left for graphql.Do to report.
+func checkGraphQLQuery(schema graphql.Schema, query string, variables map[string]interface{}) error {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return nil
+	}
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, d := range doc.Definitions {
+		if f, ok := d.(*ast.FragmentDefinition); ok {
+			fragments[f.Name.Value] = f
+		}
+	}
+	for _, d := range doc.Definitions {
+		op, ok := d.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		parent := schema.QueryType()
+		if op.Operation == ast.OperationTypeMutation {
+			parent = schema.MutationType()
+		}
+		values := map[string]interface{}{}
+		for _, v := range op.VariableDefinitions {
+			if i, ok := v.DefaultValue.(*ast.IntValue); ok {
+				values[v.Variable.Name.Value] = i.Value
+			}
+		}
+		for k, v := range variables {
+			values[k] = v
+		}
+		_, err := getGraphQLSelectionCost(op.SelectionSet, parent, fragments, values, 1, 1, map[string]bool{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getGraphQLSelectionCost returns number of objects that selections can return when their parent is returned
+// multiplier times
+func getGraphQLSelectionCost(set *ast.SelectionSet, parent *graphql.Object, fragments map[string]*ast.FragmentDefinition, variables map[string]interface{}, depth int, multiplier int, visited map[string]bool) (int, error) {
+	if set == nil {
+		return 0, nil
+	}
+	if depth > graphQLMaxDepth {
+		return 0, fmt.Errorf("query is nested deeper than %d levels", graphQLMaxDepth)
+	}
+
+	cost := 0
+	for _, selection := range set.Selections {
+		var c int
+		var err error
+		switch sel := selection.(type) {
+		case *ast.Field:
+			if sel.SelectionSet == nil {
+				continue
+			}
+			var def *graphql.FieldDefinition
+			if parent != nil {
+				def = parent.Fields()[sel.Name.Value]
+			}
+			objects := multiplier
+			var child *graphql.Object
+			if def != nil {
+				typ := def.Type
+				if l, ok := typ.(*graphql.List); ok {
+					objects *= getGraphQLListLimit(sel, variables, depth)
+					typ = l.OfType
+				}
+				child, _ = typ.(*graphql.Object)
+			}
+			if objects > graphQLMaxCost {
+				return 0, fmt.Errorf("query can return more than %d objects", graphQLMaxCost)
+			}
+			c, err = getGraphQLSelectionCost(sel.SelectionSet, child, fragments, variables, depth+1, objects, visited)
+			c += objects
+		case *ast.InlineFragment:
+			c, err = getGraphQLSelectionCost(sel.SelectionSet, parent, fragments, variables, depth, multiplier, visited)
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			f, ok := fragments[name]
+			if !ok || visited[name] {
+				continue
+			}
+			visited[name] = true
+			c, err = getGraphQLSelectionCost(f.SelectionSet, parent, fragments, variables, depth, multiplier, visited)
+			delete(visited, name)
+		}
+		if err != nil {
+			return 0, err
+		}
+		cost += c
+		if cost > graphQLMaxCost {
+			return 0, fmt.Errorf("query can return more than %d objects", graphQLMaxCost)
+		}
+	}
+	return cost, nil
+}
+
+// getGraphQLListLimit returns limit of a list field the same way as its resolver takes it
+func getGraphQLListLimit(field *ast.Field, variables map[string]interface{}, depth int) int {
+	limit := graphQLDefaultLimit
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			limit, _ = strconv.Atoi(v.Value)
+		case *ast.Variable:
+			if value, ok := variables[v.Name.Value]; ok {
+				if i, ok := toInt64(value).(int64); ok {
+					limit = int(i)
+				}
+			}
+		}
+	}
+
+	max := graphQLMaxListLimit
+	if depth > 1 {
+		max = graphQLMaxNestedLimit
+	}
+	if limit <= 0 || limit > max {
+		return max
+	}
+	return limit
+}
@@ -0,0 +1,110 @@
+package prototyping
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	crud "github.com/go-phings/crud"
+)
+
+type GQLAuthor struct {
+	ID   int64
+	Name string `json:"name" ui:"req lenmin:1 lenmax:10"`
+}
+
+type GQLBook struct {
+	ID          int64
+	Title       string `json:"title"`
+	GQLAuthorID int64  `json:"author_id"`
+}
+
+func newTestGraphQLPrototype(t *testing.T) (*Prototype, http.Handler) {
+	p := &Prototype{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		orm:    &testORM{},
+		constructors: []func() interface{}{
+			func() interface{} { return &GQLAuthor{} },
+			func() interface{} { return &GQLBook{} },
+		},
+	}
+	schema, err := p.newGraphQLSchema()
+	if err != nil {
+		t.Fatalf("error creating schema: %s", err)
+	}
+	h := p.getGraphQLHTTPHandler(schema)
+	return p, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		for _, o := range getTemplateOps() {
+			ctx = context.WithValue(ctx, crud.ContextValue(fmt.Sprintf("AllowedTypes_%d", o.Value)), map[string]bool{"all": true})
+		}
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func TestGraphQLQueryLimits(t *testing.T) {
+	_, h := newTestGraphQLPrototype(t)
+
+	tests := []struct {
+		name  string
+		query string
+		ok    bool
+	}{
+		{name: "list", query: `{ gqlAuthorList(limit: 1000) { ID name } }`, ok: true},
+		{name: "nested list", query: `{ gqlAuthorList(limit: 50) { ID gqlBookList(limit: 100) { title } } }`, ok: true},
+		{name: "nested list over cost", query: `{ gqlAuthorList(limit: 1000) { gqlBookList(limit: 50) { title } } }`},
+		{name: "nested limit is lowered", query: `{ gqlAuthorList(limit: 200) { gqlBookList(limit: 1000) { title } } }`},
+		{name: "variable limit", query: `query($l: Int) { gqlAuthorList(limit: 1000) { gqlBookList(limit: $l) { title } } }`},
+		{name: "default variable limit", query: `query($l: Int = 500) { gqlAuthorList(limit: $l) { gqlBookList { title } } }`, ok: true},
+		{name: "fragments", query: `{ a: gqlAuthorList(limit: 1000) { ...books } } fragment books on GQLAuthor { gqlBookList(limit: 20) { title } }`},
+		{name: "object", query: `{ gqlAuthor(id: 1) { gqlBookList { title } } }`, ok: true},
+		{name: "too deep", query: `{ gqlAuthorList(limit: 1) {` + strings.Repeat(` gqlBookList(limit: 1) { title `, 8) + strings.Repeat(`}`, 9) + ` }`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tt.query))
+			r.Header.Set("Content-Type", "application/graphql")
+			if tt.name == "variable limit" {
+				r = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"`+tt.query+`","variables":{"l":20}}`))
+				r.Header.Set("Content-Type", "application/json")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if tt.ok && (rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "errors")) {
+				t.Fatalf("expected query to be executed, got %d %s", rec.Code, rec.Body.String())
+			}
+			if !tt.ok && rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected query to be rejected, got %d %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSaveObjectValidates(t *testing.T) {
+	p, _ := newTestGraphQLPrototype(t)
+	orm := p.orm.(*testORM)
+	ctx := context.WithValue(context.Background(), ormContextKey, ORM(orm))
+	s := getModelStruct(func() interface{} { return &GQLAuthor{} })
+
+	_, err := p.saveObject(ctx, s, nil, map[string]interface{}{"name": "a name that is too long"})
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid value") {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	_, err = p.saveObject(ctx, s, nil, map[string]interface{}{})
+	if err == nil {
+		t.Fatal("expected error with missing required field")
+	}
+	if len(orm.calls) != 0 {
+		t.Fatalf("expected invalid objects not to be saved, got %v", orm.calls)
+	}
+
+	obj, err := p.saveObject(ctx, s, nil, map[string]interface{}{"name": "author"})
+	if err != nil || obj.(*GQLAuthor).Name != "author" {
+		t.Fatalf("expected object to be saved, got %v %v", obj, err)
+	}
+}
@@ -0,0 +1,580 @@
+package prototyping
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-phings/umbrella"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const grpcMaxMessageSize = 4 << 20
+
+type grpcService struct {
+	model   *modelStruct
+	service protoreflect.ServiceDescriptor
+	message protoreflect.MessageDescriptor
+}
+
+// grpcError is an error with a status code that is returned to Connect and gRPC clients
+type grpcError struct {
+	code       string
+	grpcCode   int
+	httpStatus int
+	message    string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+func newGRPCError(code string, message string) *grpcError {
+	e := &grpcError{code: code, message: message}
+	switch code {
+	case "invalid_argument":
+		e.grpcCode, e.httpStatus = 3, http.StatusBadRequest
+	case "not_found":
+		e.grpcCode, e.httpStatus = 5, http.StatusNotFound
+	case "permission_denied":
+		e.grpcCode, e.httpStatus = 7, http.StatusForbidden
+	case "unimplemented":
+		e.grpcCode, e.httpStatus = 12, http.StatusNotImplemented
+	default:
+		e.code, e.grpcCode, e.httpStatus = "internal", 13, http.StatusInternalServerError
+	}
+	return e
+}
+
+func getProtoFieldType(k reflect.Kind) descriptorpb.FieldDescriptorProto_Type {
+	switch k {
+	case reflect.String:
+		return descriptorpb.FieldDescriptorProto_TYPE_STRING
+	case reflect.Bool:
+		return descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return descriptorpb.FieldDescriptorProto_TYPE_INT32
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return descriptorpb.FieldDescriptorProto_TYPE_UINT32
+	case reflect.Uint, reflect.Uint64:
+		return descriptorpb.FieldDescriptorProto_TYPE_UINT64
+	case reflect.Float32:
+		return descriptorpb.FieldDescriptorProto_TYPE_FLOAT
+	case reflect.Float64:
+		return descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	}
+	return descriptorpb.FieldDescriptorProto_TYPE_INT64
+}
+
+func newProtoField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+	label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	}
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    label.Enum(),
+		Type:     typ.Enum(),
+		JsonName: proto.String(name),
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+// newProtoFile generates protobuf definitions with a message and a service for each struct
+func newProtoFile(pkg string, constructors []func() interface{}) (protoreflect.FileDescriptor, []*modelStruct, error) {
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(strings.ReplaceAll(pkg, ".", "/") + "/models.proto"),
+		Package: proto.String(pkg),
+		Syntax:  proto.String("proto3"),
+	}
+	msgType := func(name string) string { return fmt.Sprintf(".%s.%s", pkg, name) }
+	typeString := descriptorpb.FieldDescriptorProto_TYPE_STRING
+	typeInt32 := descriptorpb.FieldDescriptorProto_TYPE_INT32
+	typeInt64 := descriptorpb.FieldDescriptorProto_TYPE_INT64
+	typeMessage := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+
+	models := []*modelStruct{}
+	for _, f := range constructors {
+		s := getModelStruct(f)
+		models = append(models, s)
+
+		// fields have explicit presence so that an update without a mask changes only the fields that are set
+		msg := &descriptorpb.DescriptorProto{Name: proto.String(s.name)}
+		for i, field := range s.fields {
+			f := newProtoField(field.jsonName, int32(i+1), getProtoFieldType(field.typ.Kind()), "", false)
+			f.Proto3Optional = proto.Bool(true)
+			f.OneofIndex = proto.Int32(int32(i))
+			msg.Field = append(msg.Field, f)
+			msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + field.jsonName)})
+		}
+
+		listReq := fmt.Sprintf("List%sRequest", s.name)
+		fdp.MessageType = append(fdp.MessageType,
+			msg,
+			&descriptorpb.DescriptorProto{
+				Name: proto.String(listReq),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					newProtoField("limit", 1, typeInt32, "", false),
+					newProtoField("offset", 2, typeInt32, "", false),
+					newProtoField("order", 3, typeString, "", false),
+					newProtoField("order_direction", 4, typeString, "", false),
+					newProtoField("filters", 5, typeMessage, msgType(listReq+".FiltersEntry"), true),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("FiltersEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						newProtoField("key", 1, typeString, "", false),
+						newProtoField("value", 2, typeString, "", false),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			},
+			&descriptorpb.DescriptorProto{
+				Name: proto.String(fmt.Sprintf("List%sResponse", s.name)),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					newProtoField("items", 1, typeMessage, msgType(s.name), true),
+					newProtoField("total", 2, typeInt64, "", false),
+				},
+			},
+			&descriptorpb.DescriptorProto{
+				Name:  proto.String(fmt.Sprintf("Get%sRequest", s.name)),
+				Field: []*descriptorpb.FieldDescriptorProto{newProtoField("id", 1, typeInt64, "", false)},
+			},
+			&descriptorpb.DescriptorProto{
+				Name:  proto.String(fmt.Sprintf("Create%sRequest", s.name)),
+				Field: []*descriptorpb.FieldDescriptorProto{newProtoField("item", 1, typeMessage, msgType(s.name), false)},
+			},
+			&descriptorpb.DescriptorProto{
+				Name: proto.String(fmt.Sprintf("Update%sRequest", s.name)),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					newProtoField("id", 1, typeInt64, "", false),
+					newProtoField("item", 2, typeMessage, msgType(s.name), false),
+					newProtoField("update_mask", 3, typeString, "", true),
+				},
+			},
+			&descriptorpb.DescriptorProto{
+				Name:  proto.String(fmt.Sprintf("Delete%sRequest", s.name)),
+				Field: []*descriptorpb.FieldDescriptorProto{newProtoField("id", 1, typeInt64, "", false)},
+			},
+			&descriptorpb.DescriptorProto{
+				Name: proto.String(fmt.Sprintf("Delete%sResponse", s.name)),
+			},
+		)
+
+		method := func(name string, in string, out string) *descriptorpb.MethodDescriptorProto {
+			return &descriptorpb.MethodDescriptorProto{
+				Name:       proto.String(name),
+				InputType:  proto.String(msgType(in)),
+				OutputType: proto.String(msgType(out)),
+			}
+		}
+		fdp.Service = append(fdp.Service, &descriptorpb.ServiceDescriptorProto{
+			Name: proto.String(s.name + "Service"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("List", listReq, fmt.Sprintf("List%sResponse", s.name)),
+				method("Get", fmt.Sprintf("Get%sRequest", s.name), s.name),
+				method("Create", fmt.Sprintf("Create%sRequest", s.name), s.name),
+				method("Update", fmt.Sprintf("Update%sRequest", s.name), s.name),
+				method("Delete", fmt.Sprintf("Delete%sRequest", s.name), fmt.Sprintf("Delete%sResponse", s.name)),
+			},
+		})
+	}
+
+	fd, err := protodesc.NewFile(fdp, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fd, models, nil
+}
+
+// ExportProto writes protobuf definitions of the services that are served when gRPC is enabled
+func (p *Prototype) ExportProto(w io.Writer) error {
+	fd, _, err := newProtoFile(p.grpcPackage, p.getAPIConstructors())
+	if err != nil {
+		return fmt.Errorf("error generating proto: %w", err)
+	}
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "syntax = \"proto3\";\n\npackage %s;\n", fd.Package())
+
+	msgs := fd.Messages()
+	for i := 0; i < msgs.Len(); i++ {
+		msg := msgs.Get(i)
+		fmt.Fprintf(b, "\nmessage %s {\n", msg.Name())
+		for j := 0; j < msg.Fields().Len(); j++ {
+			f := msg.Fields().Get(j)
+			typ := f.Kind().String()
+			if f.Kind() == protoreflect.MessageKind {
+				typ = string(f.Message().Name())
+			}
+			switch {
+			case f.IsMap():
+				typ = fmt.Sprintf("map<%s, %s>", f.MapKey().Kind(), f.MapValue().Kind())
+			case f.Cardinality() == protoreflect.Repeated:
+				typ = "repeated " + typ
+			case f.HasOptionalKeyword():
+				typ = "optional " + typ
+			}
+			fmt.Fprintf(b, "  %s %s = %d;\n", typ, f.Name(), f.Number())
+		}
+		b.WriteString("}\n")
+	}
+
+	for i := 0; i < fd.Services().Len(); i++ {
+		svc := fd.Services().Get(i)
+		fmt.Fprintf(b, "\nservice %s {\n", svc.Name())
+		for j := 0; j < svc.Methods().Len(); j++ {
+			m := svc.Methods().Get(j)
+			fmt.Fprintf(b, "  rpc %s(%s) returns (%s);\n", m.Name(), m.Input().Name(), m.Output().Name())
+		}
+		b.WriteString("}\n")
+	}
+
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// getGRPCServices returns services for the registered structs
+func (p *Prototype) getGRPCServices() ([]*grpcService, error) {
+	fd, models, err := newProtoFile(p.grpcPackage, p.getAPIConstructors())
+	if err != nil {
+		return nil, err
+	}
+	services := []*grpcService{}
+	for _, s := range models {
+		services = append(services, &grpcService{
+			model:   s,
+			service: fd.Services().ByName(protoreflect.Name(s.name + "Service")),
+			message: fd.Messages().ByName(protoreflect.Name(s.name)),
+		})
+	}
+	return services, nil
+}
+
+// objectToMessage copies readable fields of an object to a message
+func (p *Prototype) objectToMessage(ctx context.Context, svc *grpcService, obj interface{}) *dynamicpb.Message {
+	msg := dynamicpb.NewMessage(svc.message)
+	v := reflect.ValueOf(obj).Elem()
+	for _, field := range svc.model.fields {
+		if field.password || !p.isFieldReadable(ctx, svc.model.name, field.name) {
+			continue
+		}
+		fd := svc.message.Fields().ByName(protoreflect.Name(field.jsonName))
+		fv := v.FieldByName(field.name)
+		var pv protoreflect.Value
+		switch fd.Kind() {
+		case protoreflect.StringKind:
+			pv = protoreflect.ValueOfString(fv.String())
+		case protoreflect.BoolKind:
+			pv = protoreflect.ValueOfBool(fv.Bool())
+		case protoreflect.Int32Kind:
+			pv = protoreflect.ValueOfInt32(int32(fv.Int()))
+		case protoreflect.Int64Kind:
+			pv = protoreflect.ValueOfInt64(fv.Int())
+		case protoreflect.Uint32Kind:
+			pv = protoreflect.ValueOfUint32(uint32(fv.Uint()))
+		case protoreflect.Uint64Kind:
+			pv = protoreflect.ValueOfUint64(fv.Uint())
+		case protoreflect.FloatKind:
+			pv = protoreflect.ValueOfFloat32(float32(fv.Float()))
+		case protoreflect.DoubleKind:
+			pv = protoreflect.ValueOfFloat64(fv.Float())
+		default:
+			continue
+		}
+		msg.Set(fd, pv)
+	}
+	return msg
+}
+
+// messageToInput returns values of the message fields in a format accepted by saveObject. When mask is not empty,
+// only the fields in it are returned, and otherwise only the fields that are set in the message.
+func messageToInput(svc *grpcService, msg protoreflect.Message, mask []string) map[string]interface{} {
+	inMask := map[string]bool{}
+	for _, m := range mask {
+		inMask[m] = true
+	}
+	input := map[string]interface{}{}
+	for _, field := range svc.model.fields {
+		fd := svc.message.Fields().ByName(protoreflect.Name(field.jsonName))
+		if field.name == "ID" || (len(inMask) > 0 && !inMask[field.jsonName]) || (len(inMask) == 0 && !msg.Has(fd)) {
+			continue
+		}
+		v := msg.Get(fd).Interface()
+		// empty password means that it should not be changed
+		if field.password && v == "" {
+			continue
+		}
+		input[field.jsonName] = v
+	}
+	return input
+}
+
+// parseFieldValue converts filter value sent as a string to the field type
+func parseFieldValue(s string, t reflect.Type) (interface{}, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return s, nil
+	case reflect.Bool:
+		return strconv.ParseBool(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(s, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(s, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(s, 64)
+	}
+	return nil, errors.New("unsupported type")
+}
+
+func getGRPCErrorFromSave(err error) error {
+	switch {
+	case err.Error() == "NotFound":
+		return newGRPCError("not_found", "not found")
+	case err.Error() == "FieldNotAllowed":
+		return newGRPCError("permission_denied", "field not allowed")
+	case strings.HasPrefix(err.Error(), "invalid value"):
+		return newGRPCError("invalid_argument", err.Error())
+	}
+	return err
+}
+
+// callGRPCMethod runs List, Get, Create, Update or Delete method
+func (p *Prototype) callGRPCMethod(ctx context.Context, svc *grpcService, method string, in *dynamicpb.Message) (proto.Message, error) {
+	s := svc.model
+	field := func(name string) protoreflect.FieldDescriptor {
+		return in.Descriptor().Fields().ByName(protoreflect.Name(name))
+	}
+	allowed := func(op int) bool {
+		return isTypeAllowed(getAllowedTypesFromContext(ctx, op), s.name)
+	}
+
+	switch method {
+	case "List":
+		if !allowed(umbrella.OpsList) {
+			return nil, newGRPCError("permission_denied", "no access")
+		}
+		filter := map[string]interface{}{}
+		filtersMap := in.Get(field("filters")).Map()
+		var err error
+		filtersMap.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			f := s.getField(k.String())
+			if f == nil || f.password {
+				err = newGRPCError("invalid_argument", fmt.Sprintf("invalid filter %s", k.String()))
+				return false
+			}
+			filter[f.jsonName], err = parseFieldValue(v.String(), f.typ)
+			if err != nil {
+				err = newGRPCError("invalid_argument", fmt.Sprintf("invalid value of %s", k.String()))
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		limit := int(in.Get(field("limit")).Int())
+		if limit == 0 {
+			limit = 10
+		}
+		objs, err := p.getObjects(ctx, s, map[string]interface{}{
+			"limit":          limit,
+			"offset":         int(in.Get(field("offset")).Int()),
+			"order":          in.Get(field("order")).String(),
+			"orderDirection": in.Get(field("order_direction")).String(),
+			"filter":         filter,
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		filters, err := p.getReadableObjectFilters(ctx, s, filter)
+		if err != nil {
+			return nil, newGRPCError("invalid_argument", err.Error())
+		}
+		total, err := getORMFromContext(ctx).GetCount(s.constructor, filters)
+		if err != nil {
+			return nil, err
+		}
+
+		out := dynamicpb.NewMessage(svc.service.Methods().ByName("List").Output())
+		items := out.Mutable(out.Descriptor().Fields().ByName("items")).List()
+		for _, obj := range objs.([]interface{}) {
+			items.Append(protoreflect.ValueOfMessage(p.objectToMessage(ctx, svc, obj)))
+		}
+		out.Set(out.Descriptor().Fields().ByName("total"), protoreflect.ValueOfInt64(total))
+		return out, nil
+
+	case "Get", "Delete":
+		op := umbrella.OpsRead
+		if method == "Delete" {
+			op = umbrella.OpsDelete
+		}
+		if !allowed(op) {
+			return nil, newGRPCError("permission_denied", "no access")
+		}
+		obj, err := p.loadObject(ctx, s, in.Get(field("id")).Int())
+		if err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			return nil, newGRPCError("not_found", "not found")
+		}
+		if method == "Get" {
+			return p.objectToMessage(ctx, svc, obj), nil
+		}
+		err = getORMFromContext(ctx).Delete(obj)
+		if err != nil {
+			return nil, err
+		}
+		return dynamicpb.NewMessage(svc.service.Methods().ByName("Delete").Output()), nil
+
+	case "Create", "Update":
+		op := umbrella.OpsCreate
+		var id interface{}
+		var mask []string
+		if method == "Update" {
+			op = umbrella.OpsUpdate
+			id = in.Get(field("id")).Int()
+			l := in.Get(field("update_mask")).List()
+			for i := 0; i < l.Len(); i++ {
+				mask = append(mask, l.Get(i).String())
+			}
+		}
+		if !allowed(op) {
+			return nil, newGRPCError("permission_denied", "no access")
+		}
+		obj, err := p.saveObject(ctx, s, id, messageToInput(svc, in.Get(field("item")).Message(), mask))
+		if err != nil {
+			return nil, getGRPCErrorFromSave(err)
+		}
+		return p.objectToMessage(ctx, svc, obj), nil
+	}
+
+	return nil, newGRPCError("unimplemented", fmt.Sprintf("method %s not implemented", method))
+}
+
+// getGRPCHTTPHandler returns handler of a service that speaks both Connect (unary, with JSON or binary protobuf)
+// and gRPC protocols. Resolvers use the ORM of the request.
+func (p *Prototype) getGRPCHTTPHandler(svc *grpcService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ct := r.Header.Get("Content-Type")
+		isGRPC := strings.HasPrefix(ct, "application/grpc")
+		codec := "proto"
+		switch ct {
+		case "application/grpc", "application/grpc+proto", "application/proto":
+		case "application/grpc+json", "application/json":
+			codec = "json"
+		default:
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeError := func(err error) {
+			gerr, ok := err.(*grpcError)
+			if !ok {
+				p.logger.Error("error in grpc method", "error", err)
+				gerr = newGRPCError("internal", "internal error")
+			}
+			if isGRPC {
+				w.Header().Set("Content-Type", ct)
+				w.WriteHeader(http.StatusOK)
+				w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(gerr.grpcCode))
+				w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(gerr.message))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(gerr.httpStatus)
+			json.NewEncoder(w).Encode(map[string]string{"code": gerr.code, "message": gerr.message})
+		}
+
+		method := strings.TrimPrefix(r.URL.Path, getGRPCServicePath(svc))
+		md := svc.service.Methods().ByName(protoreflect.Name(method))
+		if md == nil {
+			writeError(newGRPCError("unimplemented", fmt.Sprintf("method %s not implemented", method)))
+			return
+		}
+
+		b, err := io.ReadAll(io.LimitReader(r.Body, grpcMaxMessageSize+5))
+		if err != nil {
+			writeError(newGRPCError("invalid_argument", "error reading request"))
+			return
+		}
+		if isGRPC {
+			if len(b) < 5 || int(binary.BigEndian.Uint32(b[1:5])) != len(b)-5 {
+				writeError(newGRPCError("invalid_argument", "invalid message frame"))
+				return
+			}
+			if b[0] != 0 {
+				writeError(newGRPCError("unimplemented", "compression is not supported"))
+				return
+			}
+			b = b[5:]
+		}
+
+		in := dynamicpb.NewMessage(md.Input())
+		if codec == "json" {
+			err = protojson.Unmarshal(b, in)
+		} else {
+			err = proto.Unmarshal(b, in)
+		}
+		if err != nil {
+			writeError(newGRPCError("invalid_argument", "invalid message"))
+			return
+		}
+
+		out, err := p.callGRPCMethod(context.WithValue(r.Context(), ormContextKey, p.getRequestORM(r.Context())), svc, method, in)
+		if err != nil {
+			writeError(err)
+			return
+		}
+
+		if codec == "json" {
+			b, err = protojson.Marshal(out)
+		} else {
+			b, err = proto.Marshal(out)
+		}
+		if err != nil {
+			writeError(err)
+			return
+		}
+
+		w.Header().Set("Content-Type", ct)
+		if !isGRPC {
+			w.Write(b)
+			return
+		}
+		frame := make([]byte, 5, 5+len(b))
+		binary.BigEndian.PutUint32(frame[1:5], uint32(len(b)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(append(frame, b...))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+}
+
+// getGRPCServicePath returns the path prefix of a service, eg. /prototyping.v1.ItemService/
+func getGRPCServicePath(svc *grpcService) string {
+	return fmt.Sprintf("/%s/", svc.service.FullName())
+}
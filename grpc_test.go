@@ -0,0 +1,136 @@
+package prototyping
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type GRPCItem struct {
+	ID       int64
+	Name     string  `json:"name"`
+	Price    float64 `json:"price"`
+	Quantity int32   `json:"quantity"`
+	Active   bool    `json:"active"`
+	Password string  `json:"password" ui:"password"`
+}
+
+func newTestGRPCService(t *testing.T) *grpcService {
+	fd, models, err := newProtoFile("test.v1", []func() interface{}{func() interface{} { return &GRPCItem{} }})
+	if err != nil {
+		t.Fatalf("error generating proto: %s", err)
+	}
+	return &grpcService{
+		model:   models[0],
+		service: fd.Services().ByName("GRPCItemService"),
+		message: fd.Messages().ByName("GRPCItem"),
+	}
+}
+
+func TestNewProtoFile(t *testing.T) {
+	svc := newTestGRPCService(t)
+
+	expected := map[string]protoreflect.Kind{
+		"ID":       protoreflect.Int64Kind,
+		"name":     protoreflect.StringKind,
+		"price":    protoreflect.DoubleKind,
+		"quantity": protoreflect.Int32Kind,
+		"active":   protoreflect.BoolKind,
+		"password": protoreflect.StringKind,
+	}
+	fields := svc.message.Fields()
+	if fields.Len() != len(expected) {
+		t.Fatalf("expected %d fields, got %d", len(expected), fields.Len())
+	}
+	for name, kind := range expected {
+		f := fields.ByName(protoreflect.Name(name))
+		if f == nil || f.Kind() != kind || !f.HasPresence() {
+			t.Errorf("invalid field %s: %v", name, f)
+		}
+	}
+
+	for _, m := range []string{"List", "Get", "Create", "Update", "Delete"} {
+		if svc.service.Methods().ByName(protoreflect.Name(m)) == nil {
+			t.Errorf("missing method %s", m)
+		}
+	}
+	update := svc.service.Methods().ByName("Update").Input()
+	if f := update.Fields().ByName("update_mask"); f == nil || f.Cardinality() != protoreflect.Repeated {
+		t.Errorf("invalid update_mask field")
+	}
+
+	if _, _, err := newProtoFile("invalid package", nil); err == nil {
+		t.Error("expected error with invalid package")
+	}
+}
+
+func TestExportProto(t *testing.T) {
+	p := &Prototype{
+		grpcPackage:  "test.v1",
+		constructors: []func() interface{}{func() interface{} { return &GRPCItem{} }},
+	}
+	b := &strings.Builder{}
+	if err := p.ExportProto(b); err != nil {
+		t.Fatalf("error exporting proto: %s", err)
+	}
+	for _, s := range []string{
+		"package test.v1;",
+		"message GRPCItem {\n  optional int64 ID = 1;\n  optional string name = 2;",
+		"map<string, string> filters = 5;",
+		"repeated string update_mask = 3;",
+		"rpc Update(UpdateGRPCItemRequest) returns (GRPCItem);",
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected %q in proto:\n%s", s, b.String())
+		}
+	}
+}
+
+func TestMessageToInput(t *testing.T) {
+	svc := newTestGRPCService(t)
+	msg := dynamicpb.NewMessage(svc.message)
+	fields := svc.message.Fields()
+	msg.Set(fields.ByName("ID"), protoreflect.ValueOfInt64(5))
+	msg.Set(fields.ByName("name"), protoreflect.ValueOfString("item"))
+	msg.Set(fields.ByName("quantity"), protoreflect.ValueOfInt32(0))
+	msg.Set(fields.ByName("password"), protoreflect.ValueOfString(""))
+
+	// without mask, only fields that are set are changed, and zero value can be set
+	input := messageToInput(svc, msg, nil)
+	expected := map[string]interface{}{"name": "item", "quantity": int32(0)}
+	if !reflect.DeepEqual(input, expected) {
+		t.Fatalf("expected %v, got %v", expected, input)
+	}
+
+	input = messageToInput(svc, msg, []string{"price", "name"})
+	expected = map[string]interface{}{"name": "item", "price": float64(0)}
+	if !reflect.DeepEqual(input, expected) {
+		t.Fatalf("expected %v, got %v", expected, input)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	p := &Prototype{
+		grpcPackage:  "test.v1",
+		constructors: []func() interface{}{func() interface{} { return &GRPCItem{} }},
+	}
+	b := &strings.Builder{}
+	ok, err := p.runCommand(b, []string{"export-proto"})
+	if !ok || err != nil || !strings.Contains(b.String(), "message GRPCItem {") {
+		t.Fatalf("expected proto to be exported, got %v %v", ok, err)
+	}
+
+	for _, args := range [][]string{{"generate-ts"}, {"generate-go-client"}} {
+		if ok, err := p.runCommand(b, args); !ok || err == nil {
+			t.Errorf("expected error with missing argument of %s", args[0])
+		}
+	}
+	for _, args := range [][]string{nil, {"create-db"}, {"unknown"}} {
+		if ok, err := p.runCommand(b, args); ok || err != nil {
+			t.Errorf("expected %v not to be handled, got %v %v", args, ok, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package prototyping
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+	"github.com/go-phings/umbrella"
+)
+
+const (
+	healthStatusOK   = "ok"
+	healthStatusFail = "fail"
+)
+
+type healthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status  string                 `json:"status"`
+	Version string                 `json:"version"`
+	Checks  map[string]healthCheck `json:"checks,omitempty"`
+}
+
+func writeHealthResponse(w http.ResponseWriter, resp *healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status != healthStatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getHealthHandler returns handler that reports the process is alive
+func (p *Prototype) getHealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealthResponse(w, &healthResponse{
+			Status:  healthStatusOK,
+			Version: VERSION,
+		})
+	})
+}
+
+// getReadyHandler returns handler that checks whether database is reachable, all the structs are registered and
+// their tables exist
+func (p *Prototype) getReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := &healthResponse{
+			Status:  healthStatusOK,
+			Version: VERSION,
+			Checks: map[string]healthCheck{
+				"database":     p.checkDatabase(r.Context()),
+				"constructors": p.checkConstructors(),
+				"migrations":   p.checkMigrations(r.Context()),
+			},
+		}
+		for _, c := range resp.Checks {
+			if c.Status != healthStatusOK {
+				resp.Status = healthStatusFail
+			}
+		}
+		writeHealthResponse(w, resp)
+	})
+}
+
+func newHealthCheck(err error) healthCheck {
+	if err != nil {
+		return healthCheck{Status: healthStatusFail, Error: err.Error()}
+	}
+	return healthCheck{Status: healthStatusOK}
+}
+
+// usesORMDatabase returns true when ORM was passed in the config without a dsn, and the database can be reached only
+// through the ORM
+func (p *Prototype) usesORMDatabase() bool {
+	return p.db == nil && p.dbDSN == "" && p.orm != nil
+}
+
+func (p *Prototype) checkDatabase(ctx context.Context) healthCheck {
+	if p.usesORMDatabase() {
+		_, err := p.ormWithContext(ctx).GetCount(func() interface{} { return &umbrella.Permission{} }, nil)
+		return newHealthCheck(err)
+	}
+	if p.db == nil {
+		return newHealthCheck(fmt.Errorf("database is not connected"))
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return newHealthCheck(p.db.PingContext(ctx))
+}
+
+// checkConstructors checks that every struct passed to the prototype has its routes and is registered with the ORM
+func (p *Prototype) checkConstructors() healthCheck {
+	if len(p.constructors) == 0 {
+		return newHealthCheck(fmt.Errorf("no constructors"))
+	}
+	for _, f := range p.constructors {
+		n := sqldb.GetStructName(f())
+		if !p.structNames[n] {
+			return newHealthCheck(fmt.Errorf("struct %s has no routes", n))
+		}
+		if !p.registeredStructs[n] {
+			return newHealthCheck(fmt.Errorf("struct %s is not registered with the orm", n))
+		}
+	}
+	return newHealthCheck(nil)
+}
+
+// checkMigrations checks that tables of all the exposed structs exist. It reads at most one row from each table so
+// that the check is cheap on big tables.
+func (p *Prototype) checkMigrations(ctx context.Context) healthCheck {
+	if p.usesORMDatabase() {
+		for _, f := range p.getExposedConstructors() {
+			_, err := p.ormWithContext(ctx).Get(f, []string{"ID", "asc"}, 1, 0, nil, nil)
+			if err != nil {
+				return newHealthCheck(fmt.Errorf("table for %s is not available: %w", sqldb.GetStructName(f()), err))
+			}
+		}
+		return newHealthCheck(nil)
+	}
+	if p.db == nil {
+		return newHealthCheck(fmt.Errorf("database is not connected"))
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	for _, f := range p.getExposedConstructors() {
+		tbl, _, err := getTableAndIDColumn(f(), p.dbTablePrefix)
+		if err != nil {
+			return newHealthCheck(fmt.Errorf("error getting table of %s: %w", sqldb.GetStructName(f()), err))
+		}
+		var one int
+		err = p.db.QueryRowContext(ctx, fmt.Sprintf("SELECT 1 FROM %s LIMIT 1", tbl)).Scan(&one)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return newHealthCheck(fmt.Errorf("table for %s is not available: %w", sqldb.GetStructName(f()), err))
+		}
+	}
+	return newHealthCheck(nil)
+}
+
+// getTableAndIDColumn returns table name and its ID column as generated by struct-sql-postgres
+func getTableAndIDColumn(obj interface{}, tblPrefix string) (string, string, error) {
+	s := sqldb.NewStructSQL(obj, sqldb.StructSQLOptions{DatabaseTablePrefix: tblPrefix, TagName: "ui"})
+	if s.Err() != nil {
+		return "", "", s.Err()
+	}
+	q := strings.TrimPrefix(s.GetQueryDeleteById(), "DELETE FROM ")
+	parts := strings.SplitN(q, " WHERE ", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid query: %s", q)
+	}
+	return parts[0], strings.TrimSuffix(parts[1], " = $1"), nil
+}
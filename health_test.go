@@ -0,0 +1,112 @@
+package prototyping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testHealthItem struct {
+	ID   int64
+	Name string
+}
+
+func TestCheckConstructors(t *testing.T) {
+	item := func() interface{} { return &testHealthItem{} }
+	tests := []struct {
+		name string
+		p    *Prototype
+		err  string
+	}{
+		{name: "no constructors", p: &Prototype{}, err: "no constructors"},
+		{name: "no routes", p: &Prototype{constructors: []func() interface{}{item}}, err: "struct testHealthItem has no routes"},
+		{name: "not registered", p: &Prototype{
+			constructors: []func() interface{}{item},
+			structNames:  map[string]bool{"testHealthItem": true},
+		}, err: "struct testHealthItem is not registered with the orm"},
+		{name: "ok", p: &Prototype{
+			constructors:      []func() interface{}{item},
+			structNames:       map[string]bool{"testHealthItem": true},
+			registeredStructs: map[string]bool{"testHealthItem": true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.p.checkConstructors()
+			if tt.err == "" && c.Status != healthStatusOK {
+				t.Fatalf("expected ok, got %+v", c)
+			}
+			if tt.err != "" && (c.Status != healthStatusFail || c.Error != tt.err) {
+				t.Fatalf("expected %q, got %+v", tt.err, c)
+			}
+		})
+	}
+}
+
+func TestReadyHandlerWithoutDatabase(t *testing.T) {
+	p := &Prototype{constructors: []func() interface{}{func() interface{} { return &testHealthItem{} }}}
+	rec := httptest.NewRecorder()
+	p.getReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+
+	resp := healthResponse{}
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("error unmarshaling response: %s", err)
+	}
+	for _, c := range []string{"database", "constructors", "migrations"} {
+		if resp.Checks[c].Status != healthStatusFail {
+			t.Errorf("expected %s check to fail, got %+v", c, resp.Checks[c])
+		}
+	}
+	if !strings.Contains(resp.Checks["constructors"].Error, "has no routes") {
+		t.Errorf("unexpected constructors check: %+v", resp.Checks["constructors"])
+	}
+}
+
+// testFailingORM fails every query, as if its database was not available
+type testFailingORM struct {
+	testORM
+}
+
+func (o *testFailingORM) Get(newObjFunc func() interface{}, order []string, limit int, offset int, filters map[string]interface{}, rowObjTransformFunc func(interface{}) interface{}) ([]interface{}, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (o *testFailingORM) GetCount(newObjFunc func() interface{}, filters map[string]interface{}) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+func TestReadyHandlerWithORMWithoutDSN(t *testing.T) {
+	orm := &testORM{}
+	p := &Prototype{orm: orm, constructors: []func() interface{}{func() interface{} { return &testHealthItem{} }}}
+	err := p.openDB()
+	if err != nil || p.db != nil {
+		t.Fatalf("expected no connection to be opened, got %v", err)
+	}
+
+	ctx := context.Background()
+	if c := p.checkDatabase(ctx); c.Status != healthStatusOK {
+		t.Fatalf("expected database to be checked with the orm, got %+v", c)
+	}
+	if c := p.checkMigrations(ctx); c.Status != healthStatusOK {
+		t.Fatalf("expected tables to be checked with the orm, got %+v", c)
+	}
+	if !strings.Contains(strings.Join(orm.calls, ","), "GetCount,Get") {
+		t.Fatalf("unexpected calls: %v", orm.calls)
+	}
+
+	p.orm = &testFailingORM{}
+	if c := p.checkDatabase(ctx); c.Status != healthStatusFail || c.Error != "connection refused" {
+		t.Fatalf("expected database check to fail, got %+v", c)
+	}
+	if c := p.checkMigrations(ctx); c.Status != healthStatusFail || !strings.Contains(c.Error, "table for testHealthItem is not available") {
+		t.Fatalf("expected tables check to fail, got %+v", c)
+	}
+}
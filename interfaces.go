@@ -1,5 +1,9 @@
 package prototyping
 
+import (
+	"time"
+)
+
 type userInterface interface {
 	GetID() int64
 	GetEmail() string
@@ -15,3 +19,24 @@ type userInterface interface {
 	GetEmailFieldName() string
 	GetEmailActivationKeyFieldName() string
 }
+
+// Mailer sends emails such as account confirmation or password reset
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// RateLimitStore keeps state of rate limits and failed logins. Implement it with a shared storage (eg. Redis) when
+// running more than one instance.
+type RateLimitStore interface {
+	// Take removes a token from the bucket identified by key, that is refilled with rate tokens per second up to
+	// burst. When the bucket is empty, false is returned along with the time after which a token is available.
+	Take(key string, rate float64, burst int) (bool, time.Duration, error)
+	// RegisterFailure records a failed login for key and returns the number of consecutive failures
+	RegisterFailure(key string) (int, error)
+	// ResetFailures clears failed logins of key
+	ResetFailures(key string) error
+	// SetLockout blocks key for a specific duration
+	SetLockout(key string, d time.Duration) error
+	// GetLockout returns remaining lockout duration of key
+	GetLockout(key string) (time.Duration, error)
+}
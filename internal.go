@@ -2,12 +2,144 @@ package prototyping
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
 )
 
+var (
+	configTablePrefixRegexp = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*\.)?[a-zA-Z0-9_]*$`)
+	configGRPCPackageRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)*$`)
+)
+
+// validateConfig checks the config and returns all the problems at once
 func validateConfig(cfg *Config) error {
-	// todo: proper validation
-	if cfg.DatabaseDSN == "" {
-		return errors.New("database dsn is missing")
+	errs := []error{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	checkURL := func(value string, name string) {
+		u, err := url.Parse(value)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "%s must be an http or https url", name)
+	}
+
+	// ORM passed without a dsn is already connected to its database
+	check(cfg.DatabaseDSN != "" || cfg.ORM != nil, "database dsn is missing")
+	check(configTablePrefixRegexp.MatchString(cfg.DatabaseTablePrefix), "database table prefix must contain letters, digits and underscores, optionally after a schema and a dot")
+	for _, uri := range []string{cfg.MetricsURI, cfg.HealthURI, cfg.ReadyURI, cfg.GraphQLURI} {
+		check(uri == "" || strings.HasPrefix(uri, "/"), "uri %s must start with /", uri)
 	}
-	return nil
+	if cfg.TracingEndpoint != "" {
+		_, _, err := net.SplitHostPort(cfg.TracingEndpoint)
+		check(err == nil, "tracing endpoint must be host:port")
+	}
+	check(cfg.UserCacheTTL >= 0, "user cache ttl cannot be negative")
+	check(cfg.SessionExpiration >= 0, "session expiration cannot be negative")
+	check(cfg.SessionKey == "" || len(cfg.SessionKey) >= 32, "session key must have at least 32 characters")
+	check(cfg.CookieSameSite == "" || cfg.CookieSameSite == "Lax" || cfg.CookieSameSite == "Strict" || cfg.CookieSameSite == "None", "cookie samesite must be Lax, Strict or None")
+	check(cfg.GRPCPackage == "" || configGRPCPackageRegexp.MatchString(cfg.GRPCPackage), "grpc package must be dot separated identifiers")
+
+	if cfg.OIDC != nil {
+		check(cfg.OIDC.IssuerURL != "" && cfg.OIDC.ClientID != "" && cfg.OIDC.RedirectURL != "", "oidc issuer url, client id and redirect url are required")
+		check(cfg.SessionKey != "", "session key is required with oidc so that login state survives restarts and works across instances")
+		if cfg.OIDC.IssuerURL != "" {
+			checkURL(cfg.OIDC.IssuerURL, "oidc issuer url")
+		}
+		if cfg.OIDC.RedirectURL != "" {
+			checkURL(cfg.OIDC.RedirectURL, "oidc redirect url")
+		}
+		check(!cfg.OIDC.AutoProvision || len(cfg.OIDC.DefaultPermissionTypes) == 0 || cfg.OIDC.DefaultPermissionOps > 0, "oidc default permission ops are missing")
+	}
+
+	if cfg.Accounts != nil {
+		check(!(cfg.Accounts.Registration || cfg.Accounts.PasswordReset) || cfg.Accounts.Mailer != nil, "mailer is required for registration and password reset")
+		if cfg.Accounts.BaseURL != "" {
+			checkURL(cfg.Accounts.BaseURL, "accounts base url")
+		}
+		check(len(cfg.Accounts.DefaultPermissionTypes) == 0 || cfg.Accounts.DefaultPermissionOps > 0, "accounts default permission ops are missing")
+		if t := cfg.Accounts.Templates; t != nil {
+			for _, body := range []string{t.ConfirmationSubject, t.ConfirmationBody, t.PasswordResetSubject, t.PasswordResetBody} {
+				_, err := template.New("").Parse(body)
+				check(err == nil, "invalid mail template: %v", err)
+			}
+		}
+	}
+
+	if cfg.RateLimit != nil {
+		l := cfg.RateLimit
+		check(l.IPRate >= 0 && l.UserRate >= 0 && l.APIKeyRate >= 0, "rate limits cannot be negative")
+		check(l.IPBurst >= 0 && l.UserBurst >= 0 && l.APIKeyBurst >= 0, "rate limit bursts cannot be negative")
+		check(l.LoginMaxFailures >= 0, "login max failures cannot be negative")
+		check(l.LoginLockout >= 0 && l.LoginMaxLockout >= 0, "login lockout cannot be negative")
+		check(l.LoginLockout == 0 || l.LoginMaxLockout == 0 || l.LoginMaxLockout >= l.LoginLockout, "login max lockout cannot be shorter than login lockout")
+		check(l.TrustedProxyHops >= 0, "trusted proxy hops cannot be negative")
+	}
+
+	if cfg.CORS != nil {
+		check(len(cfg.CORS.AllowedOrigins) > 0, "cors allowed origins are missing")
+		for _, o := range cfg.CORS.AllowedOrigins {
+			if o == "*" {
+				check(!cfg.CORS.AllowCredentials, "cors credentials cannot be allowed for any origin")
+				continue
+			}
+			u, err := url.Parse(o)
+			check(err == nil && u.Scheme != "" && u.Host != "" && (u.Path == "" || u.Path == "/"), "invalid cors origin %s", o)
+		}
+		check(cfg.CORS.MaxAge >= 0, "cors max age cannot be negative")
+	}
+
+	if cfg.Tenancy != nil {
+		check(cfg.DatabaseDSN != "", "database dsn is required with tenancy to add tenant columns")
+		check(cfg.Tenancy.Domain == "" || !strings.HasPrefix(cfg.Tenancy.Domain, "."), "tenancy domain cannot start with a dot")
+	}
+
+	return errors.Join(errs...)
+}
+
+// responseRecorder keeps the status code written by the wrapped handler
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) getStatus() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func (p *Prototype) getStructNamesSorted() []string {
+	names := make([]string, 0, len(p.structNames))
+	for s := range p.structNames {
+		names = append(names, s)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isUmbrellaLoginRequest returns true when request is sent to the umbrella's login endpoint
+func isUmbrellaLoginRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/login")
 }
@@ -0,0 +1,61 @@
+package prototyping
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type contextKey string
+
+const requestInfoContextKey = contextKey("requestInfo")
+
+// requestInfo is attached to the request context by the access log middleware so that inner handlers can fill in
+// details such as the logged user ID
+type requestInfo struct {
+	id     string
+	userID int64
+}
+
+func getRequestInfo(ctx context.Context) *requestInfo {
+	ri, _ := ctx.Value(requestInfoContextKey).(*requestInfo)
+	return ri
+}
+
+// GetRequestID returns the ID assigned to the request by the access log middleware
+func GetRequestID(ctx context.Context) string {
+	ri := getRequestInfo(ctx)
+	if ri == nil {
+		return ""
+	}
+	return ri.id
+}
+
+func (p *Prototype) wrapHandlerWithAccessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		ri := &requestInfo{
+			id: r.Header.Get("X-Request-ID"),
+		}
+		if ri.id == "" {
+			ri.id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", ri.id)
+
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoContextKey, ri)))
+
+		p.logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.getStatus()),
+			slog.Duration("duration", time.Since(start)),
+			slog.Int64("user_id", ri.userID),
+			slog.String("request_id", ri.id),
+		)
+	})
+}
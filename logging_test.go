@@ -0,0 +1,48 @@
+package prototyping
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	b := &bytes.Buffer{}
+	p := &Prototype{logger: slog.New(slog.NewJSONHandler(b, nil))}
+
+	var requestID string
+	h := p.wrapHandlerWithAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = GetRequestID(r.Context())
+		getRequestInfo(r.Context()).userID = 3
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/Item/", nil))
+	if requestID == "" || rec.Header().Get("X-Request-ID") != requestID {
+		t.Fatalf("expected request id in the context and the response, got %q and %q", requestID, rec.Header().Get("X-Request-ID"))
+	}
+
+	entry := map[string]interface{}{}
+	err := json.Unmarshal(b.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("error unmarshaling log entry: %s", err)
+	}
+	for k, v := range map[string]interface{}{"msg": "request", "method": "PUT", "path": "/api/Item/", "status": float64(201), "user_id": float64(3), "request_id": requestID} {
+		if entry[k] != v {
+			t.Errorf("expected %s to be %v, got %v", k, v, entry[k])
+		}
+	}
+
+	// id sent by the client is kept
+	r := httptest.NewRequest(http.MethodGet, "/api/Item/", nil)
+	r.Header.Set("X-Request-ID", "abc")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if requestID != "abc" || rec.Header().Get("X-Request-ID") != "abc" {
+		t.Fatalf("expected request id abc, got %q", requestID)
+	}
+}
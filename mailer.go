@@ -0,0 +1,107 @@
+package prototyping
+
+import (
+	"fmt"
+	"log/slog"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTPMailer sends emails using an SMTP server
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+}
+
+func NewSMTPMailer(host string, port int, username string, password string, from string) *SMTPMailer {
+	return &SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+	err := smtp.SendMail(fmt.Sprintf("%s:%d", m.host, m.port), auth, m.from, []string{to}, []byte(formatEmail(m.from, to, subject, body)))
+	if err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+	return nil
+}
+
+// FileMailer writes emails into a directory instead of sending them, which is useful for local testing
+type FileMailer struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileMailer(dir string) *FileMailer {
+	return &FileMailer{
+		dir: dir,
+	}
+}
+
+func (m *FileMailer) Send(to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := os.MkdirAll(m.dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("error creating mail directory: %w", err)
+	}
+	name := fmt.Sprintf("%d_%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(to))
+	err = os.WriteFile(filepath.Join(m.dir, name), []byte(formatEmail("", to, subject, body)), 0o644)
+	if err != nil {
+		return fmt.Errorf("error writing email: %w", err)
+	}
+	return nil
+}
+
+// LogMailer writes emails to a logger instead of sending them, which is useful for local testing
+type LogMailer struct {
+	logger *slog.Logger
+}
+
+func NewLogMailer(logger *slog.Logger) *LogMailer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &LogMailer{
+		logger: logger,
+	}
+}
+
+func (m *LogMailer) Send(to string, subject string, body string) error {
+	m.logger.Info("email", slog.String("to", to), slog.String("subject", subject), slog.String("body", body))
+	return nil
+}
+
+func formatEmail(from string, to string, subject string, body string) string {
+	headerValue := strings.NewReplacer("\r", "", "\n", "")
+	from = headerValue.Replace(from)
+	to = headerValue.Replace(to)
+	subject = headerValue.Replace(subject)
+
+	var b strings.Builder
+	if from != "" {
+		b.WriteString(fmt.Sprintf("From: %s\r\n", from))
+	}
+	b.WriteString(fmt.Sprintf("To: %s\r\n", to))
+	b.WriteString(fmt.Sprintf("Subject: %s\r\n", subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(body)
+	return b.String()
+}
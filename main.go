@@ -5,13 +5,22 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	crud "github.com/go-phings/crud"
 	ui "github.com/go-phings/crud-ui"
 	sqldb "github.com/go-phings/struct-sql-postgres"
 	"github.com/go-phings/umbrella"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	_ "github.com/lib/pq"
 )
@@ -32,27 +41,93 @@ type Prototype struct {
 	intFieldValues          map[string]ui.IntFieldValues
 	stringFieldValues       map[string]ui.StringFieldValues
 	orm                     ORM
+	uriMetrics              string
+	uriHealth               string
+	uriReady                string
+	metrics                 *metrics
+	structNames             map[string]bool
+	registeredStructs       map[string]bool
+	logger                  *slog.Logger
+	tracer                  trace.Tracer
+	tracerProvider          *sdktrace.TracerProvider
+	userCache               *userCache
+	oidc                    *oidcClient
+	sessionKey              []byte
+	sessionExpiration       time.Duration
+	twoFactorRequired       bool
+	totpPending             *totpPendingLogins
+	accounts                *accounts
+	userHooks               UserHooks
+	rateLimiter             *rateLimiter
+	csrf                    bool
+	cookieSameSite          string
+	cors                    *cors
+	fieldRules              map[string][]fieldRule
+	tenancy                 *tenancy
+	uriGraphQL              string
+	grpc                    bool
+	mux                     *http.ServeMux
+	handler                 http.Handler
+	grpcPackage             string
 }
 
 const uriUI = 1
 const uriAPI = 2
 
-func (p *Prototype) CreateDB() error {
-	db, err := sql.Open("postgres", p.dbDSN)
-	if err != nil {
-		log.Fatal("Error connecting to db")
+// getBuiltinConstructors returns constructors of umbrella structs and the ones used by prototyping itself
+func (p *Prototype) getBuiltinConstructors() []func() interface{} {
+	constructors := []func() interface{}{}
+	if p.umbrellaUserConstructor != nil {
+		constructors = append(constructors, p.umbrellaUserConstructor)
+	} else {
+		constructors = append(constructors, func() interface{} { return &umbrella.User{} })
+	}
+	constructors = append(constructors, func() interface{} { return &umbrella.Session{} })
+	constructors = append(constructors, func() interface{} { return &umbrella.Permission{} })
+	constructors = append(constructors, func() interface{} { return &APIKey{} })
+	constructors = append(constructors, func() interface{} { return &UserTOTP{} })
+	if p.oidc != nil {
+		constructors = append(constructors, func() interface{} { return &UserIdentity{} })
 	}
+	if p.tenancy != nil {
+		constructors = append(constructors, func() interface{} { return &Tenant{} })
+		constructors = append(constructors, func() interface{} { return &TenantUser{} })
+	}
+	return constructors
+}
 
-	p.orm.SetDatabase(db, p.dbTablePrefix)
+// getExposedConstructors returns the registered structs together with the builtin ones, without duplicates, as
+// CreateDB may not have been called yet
+func (p *Prototype) getExposedConstructors() []func() interface{} {
+	constructors := []func() interface{}{}
+	seen := map[string]bool{}
+	for _, f := range append(append([]func() interface{}{}, p.constructors...), p.getBuiltinConstructors()...) {
+		name := sqldb.GetStructName(f())
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		constructors = append(constructors, f)
+	}
+	return constructors
+}
 
-	// Append umbrella structs
-	if p.umbrellaUserConstructor != nil {
-		p.constructors = append(p.constructors, p.umbrellaUserConstructor)
-	} else {
-		p.constructors = append(p.constructors, func() interface{} { return &umbrella.User{} })
+func (p *Prototype) CreateDB() error {
+	// ORM passed in the config without a dsn is already connected to its database
+	var db *sql.DB
+	if p.dbDSN != "" {
+		var err error
+		db, err = sql.Open("postgres", p.dbDSN)
+		if err != nil {
+			p.logger.Error("error connecting to db", slog.Any("error", err))
+			return fmt.Errorf("error connecting to db: %w", err)
+		}
+		defer db.Close()
+		p.orm.SetDatabase(db, p.dbTablePrefix)
 	}
-	p.constructors = append(p.constructors, func() interface{} { return &umbrella.Session{} })
-	p.constructors = append(p.constructors, func() interface{} { return &umbrella.Permission{} })
+
+	// Append umbrella structs
+	p.constructors = append(p.constructors, p.getBuiltinConstructors()...)
 
 	for _, f := range p.constructors {
 		o := f()
@@ -62,6 +137,17 @@ func (p *Prototype) CreateDB() error {
 		}
 	}
 
+	if p.tenancy != nil {
+		if db == nil {
+			return errors.New("database dsn is required to add tenant columns")
+		}
+		p.tenancy.setDatabase(db, p.dbTablePrefix)
+		err := p.tenancy.addColumns(p.constructors)
+		if err != nil {
+			return fmt.Errorf("error adding tenant column: %w", err)
+		}
+	}
+
 	noUserConstructor := false
 	if p.umbrellaUserConstructor != nil {
 		noUserConstructor = true
@@ -115,20 +201,73 @@ func (p *Prototype) CreateDB() error {
 		return fmt.Errorf("error with confirming admin email: %w", errPerm)
 	}
 
-	db.Close()
+	return nil
+}
 
+// openDB connects to the database that is used by Run and Seed. There is no connection when ORM is passed in the
+// config without a dsn.
+func (p *Prototype) openDB() error {
+	if p.db != nil || p.dbDSN == "" {
+		return nil
+	}
+	db, err := sql.Open("postgres", p.dbDSN)
+	if err != nil {
+		p.logger.Error("error connecting to db", slog.Any("error", err))
+		return fmt.Errorf("error connecting to db: %w", err)
+	}
+	p.db = db
+	p.orm.SetDatabase(db, p.dbTablePrefix)
+	if p.tenancy != nil {
+		p.tenancy.setDatabase(p.db, p.dbTablePrefix)
+	}
 	return nil
 }
 
 func (p *Prototype) Run() error {
-	if p.db == nil {
-		db, err := sql.Open("postgres", p.dbDSN)
+	if p.tracerProvider != nil {
+		defer p.tracerProvider.Shutdown(context.Background())
+	}
+
+	handler, err := p.Handler()
+	if err != nil {
+		return err
+	}
+
+	err = http.ListenAndServe(fmt.Sprintf(":%s", p.port), handler)
+	if err != nil {
+		p.logger.Error("error with http server", slog.Any("error", err))
+		return fmt.Errorf("error with http server: %w", err)
+	}
+
+	return nil
+}
+
+// Handler sets up the routes and returns the handler that Run serves, so the prototype can be served by another
+// server, eg. httptest.Server. Routes are set up only once.
+func (p *Prototype) Handler() (http.Handler, error) {
+	if p.handler != nil {
+		return p.handler, nil
+	}
+
+	err := p.openDB()
+	if err != nil {
+		return nil, err
+	}
+
+	p.structNames = map[string]bool{}
+	p.registeredStructs = map[string]bool{}
+	for _, f := range p.constructors {
+		o := f()
+		p.structNames[sqldb.GetStructName(o)] = true
+		err := p.orm.RegisterStruct(o, nil, false, "", false)
 		if err != nil {
-			return errors.New("error connecting to db")
+			p.logger.Error("error registering struct", slog.String("struct", sqldb.GetStructName(o)), slog.Any("error", err))
+			continue
 		}
-		p.db = db
-		p.orm.SetDatabase(db, p.dbTablePrefix)
+		p.registeredStructs[sqldb.GetStructName(o)] = true
 	}
+	p.fieldRules = getFieldRules(p.constructors)
+	p.setDefaultPermissionFieldValues()
 
 	noUserConstructor := false
 	if p.umbrellaUserConstructor != nil {
@@ -144,28 +283,8 @@ func (p *Prototype) Run() error {
 		NoUserConstructor: noUserConstructor,
 		ORM:               p.orm,
 	})
-	p.uiCtl = *ui.NewController(p.db, p.dbTablePrefix, &ui.ControllerConfig{
-		PasswordGenerator: func(pass string) string {
-			passForDB, err := p.umbrella.GeneratePassword(pass)
-			if err != nil {
-				return ""
-			}
-			return passForDB
-		},
-		IntFieldValues:    p.intFieldValues,
-		StringFieldValues: p.stringFieldValues,
-		ORM:               p.orm,
-	})
-	p.apiCtl = *crud.NewController(p.db, p.dbTablePrefix, &crud.ControllerConfig{
-		PasswordGenerator: func(pass string) string {
-			passForDB, err := p.umbrella.GeneratePassword(pass)
-			if err != nil {
-				return ""
-			}
-			return passForDB
-		},
-		ORM: p.orm,
-	})
+	p.uiCtl = *p.newUIController(p.orm)
+	p.apiCtl = *p.newAPIController(p.orm)
 
 	if p.umbrellaUserConstructor != nil {
 		p.umbrella.Interfaces = &umbrella.Interfaces{
@@ -179,94 +298,359 @@ func (p *Prototype) Run() error {
 		}
 	}
 
+	// /metrics
+	if p.metrics != nil {
+		if p.db != nil {
+			p.metrics.registerDB(p.db)
+		}
+		p.mux.Handle(p.uriMetrics, p.wrapHandlerWithAccessLog(p.metrics.handler()))
+	}
+
+	// /healthz and /readyz
+	p.mux.Handle(p.uriHealth, p.wrapHandlerWithAccessLog(p.getHealthHandler()))
+	p.mux.Handle(p.uriReady, p.wrapHandlerWithAccessLog(p.getReadyHandler()))
+
 	// /umbrella/
-	http.Handle(p.uriUmbrella, p.umbrella.GetHTTPHandler(p.uriUmbrella))
+	umbrellaHandler := p.wrapUmbrellaLoginWithTOTP(p.umbrella.GetHTTPHandler(p.uriUmbrella))
+	umbrellaHandler = p.wrapLoginHandlerWithLockout(isUmbrellaLoginRequest, func(rec *responseRecorder) loginResult {
+		if rec.getStatus() == http.StatusOK {
+			return loginSucceeded
+		}
+		return loginFailed
+	}, umbrellaHandler)
+	if p.metrics != nil {
+		umbrellaHandler = p.metrics.instrumentUmbrellaLogin(umbrellaHandler)
+	}
+	p.handle(routeTypeUmbrella, p.uriUmbrella, "", umbrellaHandler)
 
 	// /ui/login/
-	http.Handle(fmt.Sprintf("%s%s/", p.uriUI, "login"), p.uiCtl.Handler(
+	var loginPageHandler http.Handler = p.uiCtl.Handler(
 		p.uriUI,
 		p.constructors...,
-	))
+	)
+	if p.oidc != nil && p.oidc.cfg.DisablePasswordLogin {
+		loginPageHandler = http.RedirectHandler(fmt.Sprintf("%s%s/", p.uriUI, "r/oidc/login"), http.StatusSeeOther)
+	}
+	if p.csrf {
+		loginPageHandler = p.wrapHandlerWithCSRFToken(loginPageHandler)
+	}
+	p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "login"), "", loginPageHandler)
 
 	// /ui/r/login/
-	http.Handle(fmt.Sprintf("%s%s/", p.uriUI, "r/login"), p.umbrella.GetLoginHTTPHandler(umbrella.HandlerConfig{
+	loginHandler := p.umbrella.GetLoginHTTPHandler(umbrella.HandlerConfig{
 		UseCookie:          "UmbrellaToken",
 		CookiePath:         p.uriUI,
 		SuccessRedirectURL: "/ui/",
 		FailureRedirectURL: "/ui/login/",
+	})
+	if p.oidc != nil && p.oidc.cfg.DisablePasswordLogin {
+		loginHandler = http.NotFoundHandler()
+	}
+	if p.metrics != nil {
+		loginHandler = p.metrics.instrumentLogin("/ui/", loginHandler)
+	}
+	loginHandler = p.wrapLoginHandlerWithTOTP("/ui/", fmt.Sprintf("%s%s/", p.uriUI, "r/2fa"), loginHandler)
+	loginHandler = p.wrapLoginHandlerWithLockout(func(r *http.Request) bool {
+		return r.Method == http.MethodPost
+	}, func(rec *responseRecorder) loginResult {
+		switch rec.Header().Get("Location") {
+		case "/ui/":
+			return loginSucceeded
+		case fmt.Sprintf("%s%s/", p.uriUI, "r/2fa"):
+			return loginPending
+		}
+		return loginFailed
+	}, loginHandler)
+	loginHandler = p.wrapLoginHandlerWithCSRFCheck(loginHandler)
+	p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "r/login"), "", p.limitByIP(p.wrapHandlerWithSameSiteCookies(loginHandler)))
+
+	// /ui/r/2fa/
+	totpHandler := p.wrapLoginHandlerWithCSRFCheck(p.getTOTPHTTPHandler(
+		fmt.Sprintf("%s%s/", p.uriUI, "r/2fa"),
+		"/ui/",
+		"/ui/login/",
+	))
+	if p.csrf {
+		totpHandler = p.wrapHandlerWithCSRFToken(totpHandler)
+	}
+	p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "r/2fa"), "", p.limitByIP(p.wrapHandlerWithSameSiteCookies(totpHandler)))
+
+	// /ui/r/2fa/setup/ behind umbrella
+	p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "r/2fa/setup"), "UserTOTP", p.umbrella.GetHTTPHandlerWrapper(p.wrapHandlerWithUmbrella(
+		uriUI,
+		p.getTOTPSetupHTTPHandler(fmt.Sprintf("%s%s/", p.uriUI, "r/2fa/setup")),
+		"/ui/login/",
+	), umbrella.HandlerConfig{
+		UseCookie: "UmbrellaToken",
 	}))
 
+	// /ui/r/oidc/login/ and /ui/r/oidc/callback/
+	if p.oidc != nil {
+		p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "r/oidc/login"), "", p.getOIDCLoginHTTPHandler())
+		callbackHandler := p.getOIDCCallbackHTTPHandler("/ui/", "/ui/login/")
+		if p.metrics != nil {
+			callbackHandler = p.metrics.instrumentLogin("/ui/", callbackHandler)
+		}
+		p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "r/oidc/callback"), "", callbackHandler)
+	}
+
+	// /ui/r/register/, /ui/r/confirm/, /ui/r/password-reset/, /ui/r/password/ and /api/r/
+	if p.accounts != nil {
+		if p.accounts.cfg.Registration {
+			p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "r/register"), "", p.limitByIP(p.getRegisterHTTPHandler(fmt.Sprintf("%s%s/", p.uriUI, "r/register"))))
+			p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "r/confirm"), "", p.getConfirmHTTPHandler())
+		}
+		if p.accounts.cfg.PasswordReset {
+			p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "r/password-reset"), "", p.limitByIP(p.getPasswordResetHTTPHandler(fmt.Sprintf("%s%s/", p.uriUI, "r/password-reset"))))
+			p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "r/password"), "", p.limitByIP(p.getPasswordHTTPHandler(fmt.Sprintf("%s%s/", p.uriUI, "r/password"))))
+		}
+		p.handle(routeTypeAPI, fmt.Sprintf("%s%s/", p.uriAPI, "r"), "", p.getAccountsAPIHandler(fmt.Sprintf("%s%s/", p.uriAPI, "r")))
+	}
+
 	// /ui/r/logout/
-	http.Handle(fmt.Sprintf("%s%s/", p.uriUI, "r/logout"), p.umbrella.GetLogoutHTTPHandler(umbrella.HandlerConfig{
+	p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "r/logout"), "", p.wrapHandlerWithSameSiteCookies(p.wrapHandlerWithSessionLogout(p.umbrella.GetLogoutHTTPHandler(umbrella.HandlerConfig{
 		UseCookie:          "UmbrellaToken",
 		CookiePath:         p.uriUI,
 		FailureRedirectURL: "/ui/",
 		SuccessRedirectURL: "/ui/login/",
-	}))
+	}))))
 
 	// /ui/ behind umbrella
-	http.Handle(p.uriUI, p.umbrella.GetHTTPHandlerWrapper(p.wrapHandlerWithUmbrella(
+	p.handle(routeTypeUI, p.uriUI, "", p.umbrella.GetHTTPHandlerWrapper(p.wrapHandlerWithUmbrella(
 		uriUI,
-		p.uiCtl.Handler(
-			p.uriUI,
-			p.constructors...,
-		),
+		p.wrapHandlerWithTenant(p.wrapHandlerWithFieldPermissions(uriUI, p.uriUI, p.newTenantHandler(func(orm ORM) http.Handler {
+			ctl := p.uiCtl
+			if orm != p.orm {
+				ctl = *p.newUIController(orm)
+			}
+			return ctl.Handler(
+				p.uriUI,
+				p.constructors...,
+			)
+		}))),
 		"/ui/login/",
 	), umbrella.HandlerConfig{
 		UseCookie: "UmbrellaToken",
 	}))
 
-	// /api/ behind umbrella
-	for _, f := range p.constructors {
+	// /ui/r/apikey/ behind umbrella
+	p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "r/apikey"), "APIKey", p.umbrella.GetHTTPHandlerWrapper(p.wrapHandlerWithUmbrella(
+		uriUI,
+		p.getAPIKeyHTTPHandler(fmt.Sprintf("%s%s/", p.uriUI, "r/apikey")),
+		"/ui/login/",
+	), umbrella.HandlerConfig{
+		UseCookie: "UmbrellaToken",
+	}))
+
+	// /ui/r/permissions/ behind umbrella
+	p.handle(routeTypeUI, fmt.Sprintf("%s%s/", p.uriUI, "r/permissions"), "Permission", p.umbrella.GetHTTPHandlerWrapper(p.wrapHandlerWithUmbrella(
+		uriUI,
+		p.getPermissionsHTTPHandler(fmt.Sprintf("%s%s/", p.uriUI, "r/permissions")),
+		"/ui/login/",
+	), umbrella.HandlerConfig{
+		UseCookie: "UmbrellaToken",
+	}))
+
+	// /api/ behind umbrella or api key
+	for _, f := range p.getAPIConstructors() {
 		s := sqldb.GetStructName(f())
-		http.Handle(
-			fmt.Sprintf("%s%s/", p.uriAPI, s),
-			p.umbrella.GetHTTPHandlerWrapper(p.wrapHandlerWithUmbrella(
-				uriAPI,
-				p.apiCtl.Handler(
+		apiHandler := p.wrapHandlerWithUmbrella(
+			uriAPI,
+			p.wrapHandlerWithTenant(p.wrapHandlerWithFieldPermissions(uriAPI, p.uriAPI, p.newTenantHandler(func(orm ORM) http.Handler {
+				ctl := p.apiCtl
+				if orm != p.orm {
+					ctl = *p.newAPIController(orm)
+				}
+				return ctl.Handler(
 					fmt.Sprintf("%s%s/", p.uriAPI, s),
 					f,
 					crud.HandlerOptions{},
-				),
-				"",
-			), umbrella.HandlerConfig{}),
+				)
+			}))),
+			"",
+		)
+		p.handle(
+			routeTypeAPI,
+			fmt.Sprintf("%s%s/", p.uriAPI, s),
+			s,
+			wrapHandlerWithAPIKey(p.umbrella.GetHTTPHandlerWrapper(apiHandler, umbrella.HandlerConfig{}), apiHandler),
 		)
 	}
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", p.port), nil))
+	// /graphql behind umbrella or api key
+	if p.uriGraphQL != "" {
+		schema, err := p.newGraphQLSchema()
+		if err != nil {
+			return nil, fmt.Errorf("error with graphql schema: %w", err)
+		}
+		graphQLHandler := p.wrapHandlerWithUmbrella(
+			uriAPI,
+			p.wrapHandlerWithTenant(p.getGraphQLHTTPHandler(schema)),
+			"",
+		)
+		p.handle(routeTypeAPI, p.uriGraphQL, "", wrapHandlerWithAPIKey(p.umbrella.GetHTTPHandlerWrapper(graphQLHandler, umbrella.HandlerConfig{}), graphQLHandler))
+	}
 
-	return nil
+	// /<package>.<Struct>Service/ behind umbrella or api key
+	p.handler = p.mux
+	if p.grpc {
+		services, err := p.getGRPCServices()
+		if err != nil {
+			return nil, fmt.Errorf("error with grpc services: %w", err)
+		}
+		for _, svc := range services {
+			svc := svc
+			grpcHandler := p.wrapHandlerWithUmbrella(
+				uriAPI,
+				p.wrapHandlerWithTenant(p.getGRPCHTTPHandler(svc)),
+				"",
+			)
+			p.handle(routeTypeAPI, getGRPCServicePath(svc), svc.model.name, wrapHandlerWithAPIKey(p.umbrella.GetHTTPHandlerWrapper(grpcHandler, umbrella.HandlerConfig{}), grpcHandler))
+		}
+		// gRPC clients require HTTP/2, which is served without TLS
+		p.handler = h2c.NewHandler(p.mux, &http2.Server{})
+	}
+
+	return p.handler, nil
+}
+
+// RunCommand runs a command given in the command-line arguments, without the program name, and returns false when
+// there is no such command. Commands are:
+//   - "export-proto" prints definitions of the gRPC services
+//   - "generate-ts <dir>" writes TypeScript types and client to the directory
+//   - "generate-go-client <package>" prints source of a Go client of the REST API
+//   - "scaffold [-dsn dsn] [-schema schema] [-prefix prefix] <dir>" generates structs for the tables of an existing
+//     database with Scaffold, the database of the prototype is used when -dsn is not given
+//   - "generate-models <file> <dir>" generates structs and main.go from model definitions with GenerateModels
+func (p *Prototype) RunCommand(args []string) (bool, error) {
+	return p.runCommand(os.Stdout, args)
+}
+
+func (p *Prototype) runCommand(w io.Writer, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	switch args[0] {
+	case "export-proto":
+		return true, p.ExportProto(w)
+	case "generate-ts":
+		if len(args) < 2 {
+			return true, errors.New("generate-ts requires a directory")
+		}
+		return true, p.GenerateTypeScript(args[1])
+	case "generate-go-client":
+		if len(args) < 2 {
+			return true, errors.New("generate-go-client requires a package name")
+		}
+		return true, p.GenerateGoClient(w, args[1])
+	case "scaffold":
+		return true, p.runScaffoldCommand(w, args[1:])
+	case "generate-models":
+		if len(args) < 3 {
+			return true, errors.New("generate-models requires a file and a directory")
+		}
+		return true, GenerateModels(args[1], args[2])
+	}
+	return false, nil
+}
+
+func (p *Prototype) generatePassword(pass string) string {
+	passForDB, err := p.umbrella.GeneratePassword(pass)
+	if err != nil {
+		p.logger.Error("error generating password", slog.Any("error", err))
+		return ""
+	}
+	return passForDB
+}
+
+func (p *Prototype) newUIController(orm ORM) *ui.Controller {
+	return ui.NewController(p.db, p.dbTablePrefix, &ui.ControllerConfig{
+		PasswordGenerator: p.generatePassword,
+		IntFieldValues:    p.intFieldValues,
+		StringFieldValues: p.stringFieldValues,
+		ORM:               orm,
+	})
+}
+
+func (p *Prototype) newAPIController(orm ORM) *crud.Controller {
+	return crud.NewController(p.db, p.dbTablePrefix, &crud.ControllerConfig{
+		PasswordGenerator: p.generatePassword,
+		ORM:               orm,
+	})
+}
+
+// handle registers a handler for the given pattern, instrumenting it when metrics are enabled
+func (p *Prototype) handle(routeType string, uri string, structName string, h http.Handler) {
+	if routeType == routeTypeAPI || routeType == routeTypeUmbrella {
+		h = p.limitByIP(h)
+	}
+	if routeType == routeTypeAPI {
+		h = p.wrapHandlerWithCORS(h)
+	}
+	if p.metrics != nil {
+		h = p.metrics.instrument(routeType, uri, structName, p.structNames, h)
+	}
+	h = p.wrapHandlerWithTracing(routeType, h)
+	p.mux.Handle(uri, p.wrapHandlerWithAccessLog(h))
 }
 
 func (p *Prototype) wrapHandlerWithUmbrella(uriType int, h http.Handler, redirectNotLogged string) http.Handler {
+	if uriType == uriUI && p.csrf {
+		h = p.wrapHandlerWithCSRFToken(h)
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if uriType == uriAPI {
+			if key := getAPIKeyFromRequest(r); key != "" {
+				lu, err := p.getAPIKeyUser(r.Context(), key)
+				if err != nil {
+					p.logger.Error("error authenticating api key", slog.Any("error", err))
+				}
+				if lu != nil {
+					if p.rateLimiter != nil && !p.rateLimiter.allow(w, fmt.Sprintf("apikey:%s", getAPIKeyPrefix(key)), p.rateLimiter.cfg.APIKeyRate, p.rateLimiter.cfg.APIKeyBurst, p.logger) {
+						return
+					}
+					h.ServeHTTP(w, r.WithContext(p.getContextWithLoggedUser(r.Context(), uriType, lu)))
+					return
+				}
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("NoAccess"))
+				return
+			}
+		}
+
 		userId := umbrella.GetUserIDFromRequest(r)
+		// users logged in with identity provider have the second factor checked there
+		checkTwoFactor := true
+		if userId == 0 && uriType == uriUI {
+			userId = p.getSessionUserID(r)
+			checkTwoFactor = false
+		}
 
 		if userId != 0 {
-			user := p.umbrella.Interfaces.User()
-			found, _ := user.GetByID(userId)
-			if found {
-				var ctx context.Context
-				if uriType == uriUI {
-					ctx = context.WithValue(r.Context(), ui.ContextValue("LoggedUserID"), fmt.Sprintf("%d", userId))
-					ctx = context.WithValue(ctx, ui.ContextValue("LoggedUserName"), user.GetExtraField("name"))
-				} else {
-					ctx = context.WithValue(r.Context(), crud.ContextValue("LoggedUserID"), fmt.Sprintf("%d", userId))
-					ctx = context.WithValue(ctx, crud.ContextValue("LoggedUserName"), user.GetExtraField("name"))
-				}
-
-				for _, o := range []int{umbrella.OpsList, umbrella.OpsRead, umbrella.OpsCreate, umbrella.OpsUpdate, umbrella.OpsDelete} {
-					allowedTypes, _ := p.umbrella.GetUserOperationAllowedTypes(userId, o)
+			lu, err := p.getLoggedUser(r.Context(), userId)
+			if err != nil {
+				p.logger.Error("error getting logged user", slog.Int64("user_id", userId), slog.Any("error", err))
+			}
+			if lu != nil {
+				if checkTwoFactor && p.isTwoFactorEnrolmentRequired(lu) && !strings.HasPrefix(r.URL.Path, fmt.Sprintf("%s%s/", p.uriUI, "r/2fa/setup")) {
 					if uriType == uriUI {
-						ctx = context.WithValue(ctx, ui.ContextValue(fmt.Sprintf("AllowedTypes_%d", o)), allowedTypes)
-					} else {
-						ctx = context.WithValue(ctx, crud.ContextValue(fmt.Sprintf("AllowedTypes_%d", o)), allowedTypes)
+						w.Header().Set("Location", fmt.Sprintf("%s%s/", p.uriUI, "r/2fa/setup"))
+						w.WriteHeader(http.StatusSeeOther)
+						return
 					}
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte("TwoFactorRequired"))
+					return
 				}
-
-				req := r.WithContext(ctx)
-
-				h.ServeHTTP(w, req)
+				if uriType == uriUI && p.csrf && !isCSRFTokenValid(r) {
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte("InvalidCSRFToken"))
+					return
+				}
+				if uriType == uriAPI && p.rateLimiter != nil && !p.rateLimiter.allow(w, fmt.Sprintf("user:%d", userId), p.rateLimiter.cfg.UserRate, p.rateLimiter.cfg.UserBurst, p.logger) {
+					return
+				}
+				h.ServeHTTP(w, r.WithContext(p.getContextWithLoggedUser(r.Context(), uriType, lu)))
 				return
 			}
 		}
@@ -281,23 +665,138 @@ func (p *Prototype) wrapHandlerWithUmbrella(uriType int, h http.Handler, redirec
 	})
 }
 
+// getContextWithLoggedUser returns context with logged user details that are used by the crud and ui controllers
+func (p *Prototype) getContextWithLoggedUser(ctx context.Context, uriType int, lu *loggedUser) context.Context {
+	if ri := getRequestInfo(ctx); ri != nil {
+		ri.userID = lu.id
+	}
+
+	ctx = context.WithValue(ctx, loggedUserContextKey, lu)
+	if uriType == uriUI {
+		ctx = context.WithValue(ctx, ui.ContextValue("LoggedUserID"), fmt.Sprintf("%d", lu.id))
+		ctx = context.WithValue(ctx, ui.ContextValue("LoggedUserName"), lu.name)
+	} else {
+		ctx = context.WithValue(ctx, crud.ContextValue("LoggedUserID"), fmt.Sprintf("%d", lu.id))
+		ctx = context.WithValue(ctx, crud.ContextValue("LoggedUserName"), lu.name)
+	}
+
+	for o, allowedTypes := range lu.allowedTypes {
+		if uriType == uriUI {
+			ctx = context.WithValue(ctx, ui.ContextValue(fmt.Sprintf("AllowedTypes_%d", o)), allowedTypes)
+		} else {
+			ctx = context.WithValue(ctx, crud.ContextValue(fmt.Sprintf("AllowedTypes_%d", o)), allowedTypes)
+		}
+	}
+
+	return ctx
+}
+
+// getLoggedUser returns user with its allowed types for each operation, from cache if enabled. When user is not
+// found, nil is returned.
+func (p *Prototype) getLoggedUser(ctx context.Context, userId int64) (*loggedUser, error) {
+	var generation uint64
+	if p.userCache != nil {
+		if lu := p.userCache.get(userId); lu != nil {
+			return lu, nil
+		}
+		generation = p.userCache.getGeneration()
+	}
+
+	spanCtx, span := p.tracer.Start(ctx, "GetUserByID", trace.WithAttributes(attribute.Int64("user_id", userId)))
+	user := p.getUserInterface(spanCtx)
+	found, err := user.GetByID(userId)
+	endSpan(span, err)
+	if err != nil || !found || !isUserActive(user) {
+		return nil, err
+	}
+
+	lu := &loggedUser{
+		id:           userId,
+		name:         user.GetExtraField("name"),
+		allowedTypes: map[int]map[string]bool{},
+	}
+	for _, o := range []int{umbrella.OpsList, umbrella.OpsRead, umbrella.OpsCreate, umbrella.OpsUpdate, umbrella.OpsDelete} {
+		_, span := p.tracer.Start(ctx, "GetUserOperationAllowedTypes", trace.WithAttributes(attribute.Int64("user_id", userId), attribute.Int("op", o)))
+		allowedTypes, err := p.umbrella.GetUserOperationAllowedTypes(userId, o)
+		endSpan(span, err)
+		if err != nil {
+			return nil, fmt.Errorf("error getting user allowed types: %w", err)
+		}
+		lu.allowedTypes[o] = allowedTypes
+	}
+
+	tu, err := p.getUserTOTP(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if tu != nil {
+		lu.twoFactorEnabled = tu.Flags&UserTOTPFlagEnabled > 0
+		lu.twoFactorRequired = tu.Flags&UserTOTPFlagRequired > 0
+	}
+
+	if p.userCache != nil {
+		p.userCache.set(lu, generation)
+	}
+
+	return lu, nil
+}
+
+// isUserActive checks that user is active and has its email confirmed, as deactivating a user in umbrella does not
+// remove its sessions and API keys
+func isUserActive(user umbrella.UserInterface) bool {
+	return user.GetFlags()&umbrella.FlagUserActive > 0 && user.GetFlags()&umbrella.FlagUserEmailConfirmed > 0
+}
+
+// getUserInterface returns umbrella's user interface which ORM calls are traced under ctx
+func (p *Prototype) getUserInterface(ctx context.Context) umbrella.UserInterface {
+	if p.umbrellaUserConstructor == nil {
+		return p.umbrella.Interfaces.User()
+	}
+	return &defaultUser{
+		ctl:         p.ormWithContext(ctx),
+		user:        p.umbrellaUserConstructor().(userInterface),
+		constructor: func() userInterface { return p.umbrellaUserConstructor().(userInterface) },
+	}
+}
+
 func NewPrototype(cfg Config, constructors ...func() interface{}) (*Prototype, error) {
 	err := validateConfig(&cfg)
 	if err != nil {
 		return nil, fmt.Errorf("error with config validation: %w", err)
 	}
+	err = validateConstructors(&cfg, constructors)
+	if err != nil {
+		return nil, fmt.Errorf("error with constructors validation: %w", err)
+	}
 
 	p := &Prototype{}
+	p.mux = http.NewServeMux()
 	p.dbDSN = cfg.DatabaseDSN
 	p.constructors = constructors
 	p.dbTablePrefix = "proto_"
+	if cfg.DatabaseTablePrefix != "" {
+		p.dbTablePrefix = cfg.DatabaseTablePrefix
+	}
 	p.uriAPI = "/api/"
 	p.uriUI = "/ui/"
 	p.uriUmbrella = "/umbrella/"
+	p.uriHealth = "/healthz"
+	if cfg.HealthURI != "" {
+		p.uriHealth = cfg.HealthURI
+	}
+	p.uriReady = "/readyz"
+	if cfg.ReadyURI != "" {
+		p.uriReady = cfg.ReadyURI
+	}
 	p.port = "9001"
 	p.intFieldValues = cfg.IntFieldValues
 	p.stringFieldValues = cfg.StringFieldValues
 
+	p.logger = cfg.Logger
+	if p.logger == nil {
+		p.logger = slog.Default()
+	}
+
 	if cfg.UserConstructor != nil {
 		p.umbrellaUserConstructor = cfg.UserConstructor
 	}
@@ -308,5 +807,92 @@ func NewPrototype(cfg Config, constructors ...func() interface{}) (*Prototype, e
 		p.orm = newWrappedStruct2db("ui")
 	}
 
+	if cfg.Metrics {
+		p.metrics = newMetrics()
+		p.orm = &metricsORM{orm: p.orm, m: p.metrics}
+		p.uriMetrics = "/metrics"
+		if cfg.MetricsURI != "" {
+			p.uriMetrics = cfg.MetricsURI
+		}
+	}
+
+	if cfg.UserCacheTTL > 0 {
+		p.userCache = newUserCache(cfg.UserCacheTTL)
+		userStructName := "User"
+		if p.umbrellaUserConstructor != nil {
+			userStructName = sqldb.GetStructName(p.umbrellaUserConstructor())
+		}
+		p.orm = &cacheInvalidatingORM{orm: p.orm, cache: p.userCache, userStructName: userStructName}
+	}
+
+	p.sessionKey = []byte(cfg.SessionKey)
+	if cfg.SessionKey == "" {
+		p.sessionKey, err = newSessionKey()
+		if err != nil {
+			return nil, fmt.Errorf("error with session key: %w", err)
+		}
+	}
+	p.sessionExpiration = time.Hour
+	if cfg.SessionExpiration > 0 {
+		p.sessionExpiration = cfg.SessionExpiration
+	}
+
+	if cfg.OIDC != nil {
+		p.oidc = newOIDCClient(cfg.OIDC)
+	}
+
+	p.twoFactorRequired = cfg.TwoFactorRequired
+
+	if cfg.UserHooks != nil {
+		p.userHooks = *cfg.UserHooks
+	}
+
+	if cfg.Accounts != nil {
+		p.accounts = newAccounts(cfg.Accounts)
+	}
+
+	if cfg.RateLimit != nil {
+		p.rateLimiter = newRateLimiter(cfg.RateLimit)
+	}
+
+	p.csrf = !cfg.DisableCSRF
+	p.cookieSameSite = "Lax"
+	if cfg.CookieSameSite != "" {
+		p.cookieSameSite = cfg.CookieSameSite
+	}
+	p.totpPending = newTOTPPendingLogins()
+
+	if cfg.GraphQL {
+		p.uriGraphQL = "/graphql"
+		if cfg.GraphQLURI != "" {
+			p.uriGraphQL = cfg.GraphQLURI
+		}
+	}
+
+	p.grpc = cfg.GRPC
+	p.grpcPackage = "prototyping.v1"
+	if cfg.GRPCPackage != "" {
+		p.grpcPackage = cfg.GRPCPackage
+	}
+
+	if cfg.Tenancy != nil {
+		p.tenancy = newTenancy(cfg.Tenancy, constructors)
+	}
+
+	if cfg.CORS != nil {
+		p.cors = newCORS(cfg.CORS)
+	}
+
+	p.tracer = newNoopTracer()
+	if cfg.TracingEndpoint != "" {
+		tp, err := newTracerProvider(cfg.TracingEndpoint, cfg.TracingInsecure)
+		if err != nil {
+			return nil, fmt.Errorf("error with tracing: %w", err)
+		}
+		p.tracerProvider = tp
+		p.tracer = tp.Tracer(tracerName)
+		p.orm = &tracingORM{orm: p.orm, tracer: p.tracer}
+	}
+
 	return p, nil
 }
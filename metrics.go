@@ -0,0 +1,243 @@
+package prototyping
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	routeTypeAPI      = "api"
+	routeTypeUI       = "ui"
+	routeTypeUmbrella = "umbrella"
+)
+
+type metrics struct {
+	registry        *prometheus.Registry
+	requestsTotal   *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	ormDuration     *prometheus.HistogramVec
+	loginsTotal     *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "prototyping",
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route type, struct name, operation and status code.",
+		}, []string{"route", "struct", "op", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "prototyping",
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route type, struct name and operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "struct", "op"}),
+		ormDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "prototyping",
+			Name:      "orm_query_duration_seconds",
+			Help:      "Duration of ORM calls by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		loginsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "prototyping",
+			Name:      "logins_total",
+			Help:      "Number of login attempts by result.",
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requestsTotal,
+		m.requestDuration,
+		m.ormDuration,
+		m.loginsTotal,
+	)
+
+	return m
+}
+
+// registerDB adds sql.DB pool stats to the registry
+func (m *metrics) registerDB(db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, "prototyping"))
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument wraps a handler with request count and latency metrics. When structName is empty, it is taken from
+// the request path by matching it against the registered struct names.
+func (m *metrics) instrument(routeType string, uri string, structName string, structNames map[string]bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+
+		s := structName
+		if s == "" {
+			s = getStructNameFromURI(uri, r.URL.Path, structNames)
+		}
+		op := getOperationFromRequest(uri, r)
+
+		m.requestsTotal.WithLabelValues(routeType, s, op, strconv.Itoa(rec.getStatus())).Inc()
+		m.requestDuration.WithLabelValues(routeType, s, op).Observe(time.Since(start).Seconds())
+	})
+}
+
+// instrumentLogin counts login attempts by checking where the login handler redirects to
+func (m *metrics) instrumentLogin(successRedirectURL string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if r.Method != http.MethodPost {
+			return
+		}
+		if rec.Header().Get("Location") == successRedirectURL {
+			m.loginsTotal.WithLabelValues("success").Inc()
+			return
+		}
+		m.loginsTotal.WithLabelValues("failure").Inc()
+	})
+}
+
+// instrumentUmbrellaLogin counts login attempts made to umbrella's API login endpoint
+func (m *metrics) instrumentUmbrellaLogin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isUmbrellaLoginRequest(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.getStatus() == http.StatusOK {
+			m.loginsTotal.WithLabelValues("success").Inc()
+			return
+		}
+		m.loginsTotal.WithLabelValues("failure").Inc()
+	})
+}
+
+func getStructNameFromURI(uri string, path string, structNames map[string]bool) string {
+	for _, part := range strings.Split(strings.TrimPrefix(path, uri), "/") {
+		if structNames[part] {
+			return part
+		}
+	}
+	return ""
+}
+
+func getOperationFromRequest(uri string, r *http.Request) string {
+	hasID := false
+	for _, part := range strings.Split(strings.TrimPrefix(r.URL.Path, uri), "/") {
+		if _, err := strconv.ParseInt(part, 10, 64); err == nil {
+			hasID = true
+			break
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if hasID {
+			return "read"
+		}
+		return "list"
+	case http.MethodPost:
+		if hasID {
+			return "update"
+		}
+		return "create"
+	case http.MethodPut, http.MethodPatch:
+		if hasID {
+			return "update"
+		}
+		return "create"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return "other"
+}
+
+// metricsORM is an ORM that measures duration of every call made to the wrapped ORM
+type metricsORM struct {
+	orm ORM
+	m   *metrics
+}
+
+func (o *metricsORM) observe(method string, start time.Time) {
+	o.m.ormDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func (o *metricsORM) SetDatabase(dbConn *sql.DB, tblPrefix string) {
+	o.orm.SetDatabase(dbConn, tblPrefix)
+}
+
+func (o *metricsORM) RegisterStruct(obj interface{}, inheritFromObj interface{}, overwriteExisting bool, forceNameForDB string, useOnlyRootFromInheritedObj bool) error {
+	defer o.observe("RegisterStruct", time.Now())
+	return o.orm.RegisterStruct(obj, inheritFromObj, overwriteExisting, forceNameForDB, useOnlyRootFromInheritedObj)
+}
+
+func (o *metricsORM) CreateTables(objs ...interface{}) error {
+	defer o.observe("CreateTables", time.Now())
+	return o.orm.CreateTables(objs...)
+}
+
+func (o *metricsORM) Load(obj interface{}, id string) error {
+	defer o.observe("Load", time.Now())
+	return o.orm.Load(obj, id)
+}
+
+func (o *metricsORM) Save(obj interface{}) error {
+	defer o.observe("Save", time.Now())
+	return o.orm.Save(obj)
+}
+
+func (o *metricsORM) Delete(obj interface{}) error {
+	defer o.observe("Delete", time.Now())
+	return o.orm.Delete(obj)
+}
+
+func (o *metricsORM) DeleteMultiple(obj interface{}, filters map[string]interface{}) error {
+	defer o.observe("DeleteMultiple", time.Now())
+	return o.orm.DeleteMultiple(obj, filters)
+}
+
+func (o *metricsORM) Get(newObjFunc func() interface{}, order []string, limit int, offset int, filters map[string]interface{}, rowObjTransformFunc func(interface{}) interface{}) ([]interface{}, error) {
+	defer o.observe("Get", time.Now())
+	return o.orm.Get(newObjFunc, order, limit, offset, filters, rowObjTransformFunc)
+}
+
+func (o *metricsORM) GetCount(newObjFunc func() interface{}, filters map[string]interface{}) (int64, error) {
+	defer o.observe("GetCount", time.Now())
+	return o.orm.GetCount(newObjFunc, filters)
+}
+
+func (o *metricsORM) updateFields(obj interface{}, values map[string]interface{}, filters map[string]interface{}) (int64, error) {
+	defer o.observe("UpdateFields", time.Now())
+	return updateFields(o.orm, obj, values, filters)
+}
+
+func (o *metricsORM) insertWithColumns(obj interface{}, columns map[string]interface{}) error {
+	defer o.observe("InsertWithColumns", time.Now())
+	return insertWithColumns(o.orm, obj, columns)
+}
+
+func (o *metricsORM) GetFieldNameFromDBCol(obj interface{}, field string) (string, error) {
+	return o.orm.GetFieldNameFromDBCol(obj, field)
+}
+
+func (o *metricsORM) GetObjIDValue(obj interface{}) int64 {
+	return o.orm.GetObjIDValue(obj)
+}
+
+func (o *metricsORM) ResetFields(obj interface{}) {
+	o.orm.ResetFields(obj)
+}
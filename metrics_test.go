@@ -0,0 +1,78 @@
+package prototyping
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetOperationFromRequest(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		op     string
+	}{
+		{method: http.MethodGet, path: "/api/Item/", op: "list"},
+		{method: http.MethodGet, path: "/api/Item/1", op: "read"},
+		{method: http.MethodHead, path: "/api/Item/1", op: "read"},
+		{method: http.MethodPut, path: "/api/Item/", op: "create"},
+		{method: http.MethodPut, path: "/api/Item/1", op: "update"},
+		{method: http.MethodPost, path: "/ui/x/Item/", op: "create"},
+		{method: http.MethodPost, path: "/ui/x/Item/1", op: "update"},
+		{method: http.MethodDelete, path: "/api/Item/1", op: "delete"},
+		{method: http.MethodOptions, path: "/api/Item/", op: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			uri := "/api/"
+			if strings.HasPrefix(tt.path, "/ui/") {
+				uri = "/ui/"
+			}
+			if op := getOperationFromRequest(uri, httptest.NewRequest(tt.method, tt.path, nil)); op != tt.op {
+				t.Fatalf("expected %s, got %s", tt.op, op)
+			}
+		})
+	}
+}
+
+func TestGetStructNameFromURI(t *testing.T) {
+	structNames := map[string]bool{"Item": true}
+	if s := getStructNameFromURI("/ui/", "/ui/x/Item/1", structNames); s != "Item" {
+		t.Fatalf("expected Item, got %s", s)
+	}
+	if s := getStructNameFromURI("/ui/", "/ui/x/Other/", structNames); s != "" {
+		t.Fatalf("expected no struct, got %s", s)
+	}
+}
+
+func TestMetricsInstrument(t *testing.T) {
+	m := newMetrics()
+	h := m.instrument(routeTypeAPI, "/api/", "", map[string]bool{"Item": true}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/Item/1", nil))
+
+	login := m.instrumentLogin("/ui/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", r.FormValue("next"))
+		w.WriteHeader(http.StatusSeeOther)
+	}))
+	for _, next := range []string{"/ui/", "/ui/login/"} {
+		r := httptest.NewRequest(http.MethodPost, "/ui/login/", strings.NewReader("next="+next))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		login.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	rec := httptest.NewRecorder()
+	m.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, s := range []string{
+		`prototyping_http_requests_total{code="404",op="read",route="api",struct="Item"} 1`,
+		`prototyping_http_request_duration_seconds_count{op="read",route="api",struct="Item"} 1`,
+		`prototyping_logins_total{result="success"} 1`,
+		`prototyping_logins_total{result="failure"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), s) {
+			t.Errorf("expected %s in metrics", s)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package prototyping
+
+const (
+	APIKeyFlagActive = 1
+)
+
+// APIKey allows machine clients to access the API. Key is tied to a user, and then its scope is limited to the user's
+// permissions, or to a service account when UserID is 0. Ops contains umbrella.Ops* flags and Types is a
+// comma-separated list of struct names (or "all") that the key can access.
+type APIKey struct {
+	ID             int64  `json:"api_key_id"`
+	Flags          int64  `json:"flags"`
+	Name           string `json:"name" ui:"req lenmin:1 lenmax:100"`
+	UserID         int64  `json:"user_id" perm:"readonly"`
+	KeyPrefix      string `json:"key_prefix" ui:"uniq lenmax:32" perm:"readonly"`
+	KeyHash        string `json:"key_hash" ui:"hidden lenmax:64" perm:"readonly"`
+	Ops            int64  `json:"ops" perm:"readonly"`
+	Types          string `json:"types" ui:"lenmax:1000 db_type:VARCHAR(1000)" perm:"readonly"`
+	ExpiresAt      int64  `json:"expires_at"`
+	LastUsedAt     int64  `json:"last_used_at" perm:"readonly"`
+	CreatedAt      int64  `json:"created_at" perm:"readonly"`
+	CreatedBy      int64  `json:"created_by" perm:"readonly"`
+	LastModifiedAt int64  `json:"last_modified_at"`
+	LastModifiedBy int64  `json:"last_modified_by"`
+}
+
+func GetAPIKeyFlagsMultipleBitChoice() map[int]string {
+	return map[int]string{
+		APIKeyFlagActive: "Active",
+	}
+}
@@ -0,0 +1,36 @@
+package prototyping
+
+const (
+	TenantFlagActive = 1
+)
+
+// Tenant is a customer whose data is separated from the other tenants. Slug is used to resolve the tenant from
+// a subdomain or a header.
+type Tenant struct {
+	ID             int64  `json:"tenant_id"`
+	Flags          int64  `json:"flags"`
+	Name           string `json:"name" ui:"req lenmin:1 lenmax:100"`
+	Slug           string `json:"slug" ui:"req lenmin:1 lenmax:63 uniq"`
+	CreatedAt      int64  `json:"created_at"`
+	CreatedBy      int64  `json:"created_by"`
+	LastModifiedAt int64  `json:"last_modified_at"`
+	LastModifiedBy int64  `json:"last_modified_by"`
+}
+
+// TenantUser makes a user a member of a tenant
+type TenantUser struct {
+	ID             int64 `json:"tenant_user_id"`
+	Flags          int64 `json:"flags"`
+	TenantID       int64 `json:"tenant_id" ui:"req"`
+	UserID         int64 `json:"user_id" ui:"req"`
+	CreatedAt      int64 `json:"created_at"`
+	CreatedBy      int64 `json:"created_by"`
+	LastModifiedAt int64 `json:"last_modified_at"`
+	LastModifiedBy int64 `json:"last_modified_by"`
+}
+
+func GetTenantFlagsMultipleBitChoice() map[int]string {
+	return map[int]string{
+		TenantFlagActive: "Active",
+	}
+}
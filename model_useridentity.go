@@ -0,0 +1,15 @@
+package prototyping
+
+// UserIdentity links a user to an account at an OpenID Connect identity provider. Users logging in with the provider
+// are found by issuer and subject of the ID token, as email can be changed at the provider.
+type UserIdentity struct {
+	ID             int64  `json:"user_identity_id"`
+	Flags          int64  `json:"flags"`
+	UserID         int64  `json:"user_id" ui:"req"`
+	Issuer         string `json:"issuer" ui:"req lenmin:1 lenmax:255"`
+	Subject        string `json:"subject" ui:"req lenmin:1 lenmax:255"`
+	CreatedAt      int64  `json:"created_at"`
+	CreatedBy      int64  `json:"created_by"`
+	LastModifiedAt int64  `json:"last_modified_at"`
+	LastModifiedBy int64  `json:"last_modified_by"`
+}
@@ -0,0 +1,29 @@
+package prototyping
+
+const (
+	UserTOTPFlagEnabled  = 1
+	UserTOTPFlagRequired = 2
+)
+
+// UserTOTP keeps two-factor authentication settings of a user. Admin can create a row with UserTOTPFlagRequired
+// flag to require the user to enrol. Secret and bcrypt hashed recovery codes are set during enrolment, and they are
+// never sent in responses nor written through the generic handlers.
+type UserTOTP struct {
+	ID             int64  `json:"user_totp_id"`
+	Flags          int64  `json:"flags"`
+	UserID         int64  `json:"user_id" ui:"req"`
+	Secret         string `json:"-" ui:"hidden lenmax:64" perm:"readonly"`
+	RecoveryCodes  string `json:"-" ui:"hidden lenmax:1000 db_type:VARCHAR(1000)" perm:"readonly"`
+	LastUsedStep   int64  `json:"-" ui:"hidden" perm:"readonly"`
+	CreatedAt      int64  `json:"created_at"`
+	CreatedBy      int64  `json:"created_by"`
+	LastModifiedAt int64  `json:"last_modified_at"`
+	LastModifiedBy int64  `json:"last_modified_by"`
+}
+
+func GetUserTOTPFlagsMultipleBitChoice() map[int]string {
+	return map[int]string{
+		UserTOTPFlagEnabled:  "Enabled",
+		UserTOTPFlagRequired: "Required",
+	}
+}
@@ -0,0 +1,339 @@
+package prototyping
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+	"gopkg.in/yaml.v3"
+)
+
+// ModelDefinition describes a struct in a YAML or JSON file read by GenerateModels
+type ModelDefinition struct {
+	Name   string            `yaml:"name"`
+	Fields []FieldDefinition `yaml:"fields"`
+}
+
+// FieldDefinition describes a field of a struct. Type is one of string, text, int, int64, float64 and bool. ID,
+// Flags, CreatedAt, CreatedBy, LastModifiedAt and LastModifiedBy fields are added to each struct.
+type FieldDefinition struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`
+	Required bool   `yaml:"required"`
+	Unique   bool   `yaml:"unique"`
+	Email    bool   `yaml:"email"`
+	Password bool   `yaml:"password"`
+	LenMin   int    `yaml:"lenmin"`
+	LenMax   int    `yaml:"lenmax"`
+	ValMin   *int   `yaml:"valmin"`
+	ValMax   *int   `yaml:"valmax"`
+	Regexp   string `yaml:"regexp"`
+	// Values are choices of an int or a string field, eg. {1: Draft, 2: Published}
+	Values map[string]string `yaml:"values"`
+	// Multiple allows choosing many values of an int field, which keys must be powers of two
+	Multiple bool `yaml:"multiple"`
+}
+
+type modelDefinitionValues struct {
+	Key    string
+	Int    bool
+	Type   string
+	Values []string
+}
+
+var modelDefinitionTypes = map[string]string{
+	"string":  "string",
+	"text":    "string",
+	"int":     "int",
+	"int64":   "int64",
+	"float64": "float64",
+	"bool":    "bool",
+}
+
+var modelDefinitionReservedFields = map[string]bool{
+	"ID": true, "Flags": true, "CreatedAt": true, "CreatedBy": true, "LastModifiedAt": true, "LastModifiedBy": true,
+}
+
+// getDefinitionField converts a field definition to a struct field and the choices of its values
+func getDefinitionField(structName string, d FieldDefinition) (scaffoldField, *modelDefinitionValues, error) {
+	typ, ok := modelDefinitionTypes[d.Type]
+	switch {
+	case !token.IsIdentifier(d.Name) || !token.IsExported(d.Name):
+		return scaffoldField{}, nil, fmt.Errorf("field name %s must be an exported identifier", d.Name)
+	case modelDefinitionReservedFields[d.Name]:
+		return scaffoldField{}, nil, fmt.Errorf("field %s is added to each struct", d.Name)
+	case !ok:
+		return scaffoldField{}, nil, fmt.Errorf("field %s has unsupported type %s", d.Name, d.Type)
+	case strings.ContainsAny(d.Regexp, " \"`"):
+		return scaffoldField{}, nil, fmt.Errorf("regexp of %s cannot contain spaces and quotes", d.Name)
+	}
+
+	opts := []string{}
+	if d.Required {
+		opts = append(opts, "req")
+	}
+	if d.Unique {
+		opts = append(opts, "uniq")
+	}
+	if d.Email {
+		opts = append(opts, "email")
+	}
+	if d.Password {
+		opts = append(opts, "hidden password uipassword dblentry")
+	}
+	if d.LenMin > 0 {
+		opts = append(opts, fmt.Sprintf("lenmin:%d", d.LenMin))
+	}
+	if d.LenMax > 0 {
+		opts = append(opts, fmt.Sprintf("lenmax:%d", d.LenMax))
+	}
+	if d.ValMin != nil {
+		opts = append(opts, fmt.Sprintf("valmin:%d", *d.ValMin))
+	}
+	if d.ValMax != nil {
+		opts = append(opts, fmt.Sprintf("valmax:%d", *d.ValMax))
+	}
+	if d.Regexp != "" {
+		opts = append(opts, "regexp:"+d.Regexp)
+	}
+	switch {
+	case d.Type == "text":
+		opts = append(opts, "db_type:TEXT")
+	case d.Type == "string" && d.LenMax > 255:
+		opts = append(opts, fmt.Sprintf("db_type:VARCHAR(%d)", d.LenMax))
+	}
+
+	tag := fmt.Sprintf("json:\"%s\"", getUnderscoredName(d.Name))
+	if len(opts) > 0 {
+		tag += fmt.Sprintf(" ui:\"%s\"", strings.Join(opts, " "))
+	}
+	field := scaffoldField{Name: d.Name, Type: typ, Tag: "`" + tag + "`"}
+
+	if len(d.Values) == 0 {
+		if d.Multiple {
+			return scaffoldField{}, nil, fmt.Errorf("field %s has multiple choice but no values", d.Name)
+		}
+		return field, nil, nil
+	}
+	if typ != "int" && typ != "int64" && typ != "string" {
+		return scaffoldField{}, nil, fmt.Errorf("field %s of type %s cannot have values", d.Name, d.Type)
+	}
+	values := &modelDefinitionValues{
+		Key:  fmt.Sprintf("%s_%s", structName, d.Name),
+		Int:  typ != "string",
+		Type: "ui.ValuesSingleChoice",
+	}
+	if d.Multiple {
+		if !values.Int {
+			return scaffoldField{}, nil, fmt.Errorf("field %s must be an int to have multiple choice", d.Name)
+		}
+		values.Type = "ui.ValuesMultipleBitChoice"
+	}
+	keys := make([]string, 0, len(d.Values))
+	for k := range d.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		key := strconv.Quote(k)
+		if values.Int {
+			i, err := strconv.Atoi(k)
+			if err != nil || (d.Multiple && (i <= 0 || i&(i-1) != 0)) {
+				return scaffoldField{}, nil, fmt.Errorf("invalid value key %s of field %s", k, d.Name)
+			}
+			key = strconv.Itoa(i)
+		}
+		values.Values = append(values.Values, fmt.Sprintf("%s: %s", key, strconv.Quote(d.Values[k])))
+	}
+	return field, values, nil
+}
+
+// getDefinitionStruct converts a model definition to a struct with the fields that prototyping adds to its models
+func getDefinitionStruct(d ModelDefinition) (*scaffoldStruct, []*modelDefinitionValues, error) {
+	if !token.IsIdentifier(d.Name) || !token.IsExported(d.Name) {
+		return nil, nil, fmt.Errorf("struct name %s must be an exported identifier", d.Name)
+	}
+	name := getUnderscoredName(d.Name)
+	s := &scaffoldStruct{Name: d.Name, Table: getPluralName(name)}
+	s.Fields = append(s.Fields,
+		scaffoldField{Name: "ID", Type: "int64", Tag: fmt.Sprintf("`json:\"%s_id\"`", name)},
+		scaffoldField{Name: "Flags", Type: "int64", Tag: fmt.Sprintf("`json:\"%s_flags\"`", name)},
+	)
+
+	errs := []error{}
+	values := []*modelDefinitionValues{}
+	seen := map[string]bool{}
+	for _, fd := range d.Fields {
+		field, v, err := getDefinitionField(d.Name, fd)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if seen[field.Name] {
+			errs = append(errs, fmt.Errorf("field %s is defined more than once", field.Name))
+			continue
+		}
+		seen[field.Name] = true
+		s.Fields = append(s.Fields, field)
+		if v != nil {
+			values = append(values, v)
+		}
+	}
+	for _, f := range []string{"CreatedAt", "CreatedBy", "LastModifiedAt", "LastModifiedBy"} {
+		s.Fields = append(s.Fields, scaffoldField{Name: f, Type: "int64", Tag: fmt.Sprintf("`json:\"%s\"`", getUnderscoredName(f))})
+	}
+	if len(errs) > 0 {
+		return nil, nil, fmt.Errorf("model %s: %w", d.Name, errors.Join(errs...))
+	}
+	return s, values, nil
+}
+
+// GenerateModels generates structs from model definitions in a YAML or JSON file, and main.go that runs a prototype
+// with them, so that a prototype can be defined without writing Go. It is also run by the "generate-models" command
+// of RunCommand, eg. of the sample app.
+//
+// Definitions are not loaded at runtime with reflect.StructOf, as the whole stack identifies structs by their type
+// names, eg. for table names and permissions, and types created at runtime have no names. Structs are written to
+// dir as Go source instead, and the generated main.go has to be built again when the definitions change.
+func GenerateModels(path string, dir string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading model definitions: %w", err)
+	}
+	defs := []ModelDefinition{}
+	err = yaml.Unmarshal(b, &defs)
+	if err != nil {
+		return fmt.Errorf("error parsing model definitions from %s: %w", path, err)
+	}
+
+	builtin := map[string]bool{}
+	for _, f := range (&Prototype{}).getBuiltinConstructors() {
+		builtin[sqldb.GetStructName(f())] = true
+	}
+	builtin["Tenant"], builtin["TenantUser"] = true, true
+
+	errs := []error{}
+	structs := []*scaffoldStruct{}
+	values := []*modelDefinitionValues{}
+	seen := map[string]bool{}
+	for _, d := range defs {
+		if builtin[d.Name] || seen[d.Name] {
+			errs = append(errs, fmt.Errorf("model %s is defined more than once or is used by prototyping", d.Name))
+			continue
+		}
+		seen[d.Name] = true
+		s, v, err := getDefinitionStruct(d)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		structs = append(structs, s)
+		values = append(values, v...)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid model definitions: %w", errors.Join(errs...))
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	data := map[string]interface{}{
+		"Source":  filepath.Base(path),
+		"Structs": structs,
+		"Values":  values,
+	}
+	for name, tpl := range map[string]*template.Template{"models.go": modelDefinitionModelsTemplate, "main.go": modelDefinitionMainTemplate} {
+		b := &bytes.Buffer{}
+		err = tpl.Execute(b, data)
+		if err != nil {
+			return fmt.Errorf("error generating %s: %w", name, err)
+		}
+		src, err := format.Source(b.Bytes())
+		if err != nil {
+			return fmt.Errorf("error formatting %s: %w", name, err)
+		}
+		err = os.WriteFile(filepath.Join(dir, name), src, 0644)
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+var modelDefinitionModelsTemplate = template.Must(template.New("models").Parse(`// Code generated by prototyping from {{.Source}}. DO NOT EDIT.
+
+package main
+{{range .Structs}}
+// {{.Name}} is defined in {{$.Source}}
+type {{.Name}} struct {
+{{range .Fields}}	{{.Name}} {{.Type}} {{.Tag}}
+{{end}}}
+{{end}}`))
+
+var modelDefinitionMainTemplate = template.Must(template.New("main").Parse(`// Code generated by prototyping from {{.Source}}. DO NOT EDIT.
+
+package main
+
+import (
+	"log"
+	"os"
+
+{{if .Values}}	ui "github.com/go-phings/crud-ui"
+{{end}}	"github.com/mikolajgs/prototyping"
+)
+
+func main() {
+	// config is read from PROTO_* environment variables and the file in PROTO_CONFIG_FILE, eg. PROTO_DATABASE_DSN
+	cfg, err := prototyping.LoadConfig(os.Getenv("PROTO_CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("error loading config: %s", err.Error())
+	}
+{{if .Values}}	cfg.IntFieldValues = map[string]ui.IntFieldValues{
+{{range .Values}}{{if .Int}}		"{{.Key}}": {Type: {{.Type}}, Values: map[int]string{ {{range .Values}}{{.}}, {{end}} }},
+{{end}}{{end}}	}
+	cfg.StringFieldValues = map[string]ui.StringFieldValues{
+{{range .Values}}{{if not .Int}}		"{{.Key}}": {Type: {{.Type}}, Values: map[string]string{ {{range .Values}}{{.}}, {{end}} }},
+{{end}}{{end}}	}
+{{end}}
+	p, err := prototyping.NewPrototype(
+		cfg,
+{{range .Structs}}		func() interface{} { return &{{.Name}}{} },
+{{end}}	)
+	if err != nil {
+		log.Fatalf("error creating new prototype: %s", err.Error())
+	}
+
+	// "create-db" creates tables of the models, and for users, sessions, permissions etc.
+	if len(os.Args) > 1 && os.Args[1] == "create-db" {
+		err = p.CreateDB()
+		if err != nil {
+			log.Fatalf("error creating database: %s", err.Error())
+		}
+		return
+	}
+
+	// "export-proto", "generate-ts <dir>" and "generate-go-client <package>" generate code instead of running
+	ok, err := p.RunCommand(os.Args[1:])
+	if err != nil {
+		log.Fatalf("error running command: %s", err.Error())
+	}
+	if ok {
+		return
+	}
+
+	err = p.Run()
+	if err != nil {
+		log.Fatalf("error running prototype: %s", err.Error())
+	}
+}
+`))
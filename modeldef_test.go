@@ -0,0 +1,209 @@
+package prototyping
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetDefinitionField(t *testing.T) {
+	zero, hundred := 0, 100
+	tests := []struct {
+		name   string
+		def    FieldDefinition
+		field  scaffoldField
+		values *modelDefinitionValues
+		err    string
+	}{
+		{
+			name:  "string",
+			def:   FieldDefinition{Name: "Title", Type: "string", Required: true, LenMin: 1, LenMax: 300, Regexp: "^[A-Z]"},
+			field: scaffoldField{Name: "Title", Type: "string", Tag: "`json:\"title\" ui:\"req lenmin:1 lenmax:300 regexp:^[A-Z] db_type:VARCHAR(300)\"`"},
+		},
+		{
+			name:  "text",
+			def:   FieldDefinition{Name: "BodyText", Type: "text"},
+			field: scaffoldField{Name: "BodyText", Type: "string", Tag: "`json:\"body_text\" ui:\"db_type:TEXT\"`"},
+		},
+		{
+			name:  "email",
+			def:   FieldDefinition{Name: "AuthorEmail", Type: "string", Unique: true, Email: true},
+			field: scaffoldField{Name: "AuthorEmail", Type: "string", Tag: "`json:\"author_email\" ui:\"uniq email\"`"},
+		},
+		{
+			name:  "password",
+			def:   FieldDefinition{Name: "Password", Type: "string", Password: true},
+			field: scaffoldField{Name: "Password", Type: "string", Tag: "`json:\"password\" ui:\"hidden password uipassword dblentry\"`"},
+		},
+		{
+			name:  "range",
+			def:   FieldDefinition{Name: "Rating", Type: "int", ValMin: &zero, ValMax: &hundred},
+			field: scaffoldField{Name: "Rating", Type: "int", Tag: "`json:\"rating\" ui:\"valmin:0 valmax:100\"`"},
+		},
+		{
+			name:  "no options",
+			def:   FieldDefinition{Name: "Published", Type: "bool"},
+			field: scaffoldField{Name: "Published", Type: "bool", Tag: "`json:\"published\"`"},
+		},
+		{
+			name:   "int values",
+			def:    FieldDefinition{Name: "Status", Type: "int64", Values: map[string]string{"2": "Published", "1": "Draft"}},
+			field:  scaffoldField{Name: "Status", Type: "int64", Tag: "`json:\"status\"`"},
+			values: &modelDefinitionValues{Key: "Post_Status", Int: true, Type: "ui.ValuesSingleChoice", Values: []string{`1: "Draft"`, `2: "Published"`}},
+		},
+		{
+			name:   "multiple values",
+			def:    FieldDefinition{Name: "Tags", Type: "int", Multiple: true, Values: map[string]string{"1": "News", "4": "Sport"}},
+			field:  scaffoldField{Name: "Tags", Type: "int", Tag: "`json:\"tags\"`"},
+			values: &modelDefinitionValues{Key: "Post_Tags", Int: true, Type: "ui.ValuesMultipleBitChoice", Values: []string{`1: "News"`, `4: "Sport"`}},
+		},
+		{
+			name:   "string values",
+			def:    FieldDefinition{Name: "Lang", Type: "string", Values: map[string]string{"pl": "Polish", "en": "English"}},
+			field:  scaffoldField{Name: "Lang", Type: "string", Tag: "`json:\"lang\"`"},
+			values: &modelDefinitionValues{Key: "Post_Lang", Type: "ui.ValuesSingleChoice", Values: []string{`"en": "English"`, `"pl": "Polish"`}},
+		},
+		{name: "unexported name", def: FieldDefinition{Name: "title", Type: "string"}, err: "field name title must be an exported identifier"},
+		{name: "invalid name", def: FieldDefinition{Name: "Title Text", Type: "string"}, err: "field name Title Text must be an exported identifier"},
+		{name: "reserved name", def: FieldDefinition{Name: "CreatedAt", Type: "int64"}, err: "field CreatedAt is added to each struct"},
+		{name: "unsupported type", def: FieldDefinition{Name: "Date", Type: "time"}, err: "field Date has unsupported type time"},
+		{name: "regexp with quote", def: FieldDefinition{Name: "Code", Type: "string", Regexp: `^"`}, err: "regexp of Code cannot contain spaces and quotes"},
+		{name: "bool values", def: FieldDefinition{Name: "Done", Type: "bool", Values: map[string]string{"1": "Yes"}}, err: "field Done of type bool cannot have values"},
+		{name: "multiple without values", def: FieldDefinition{Name: "Tags", Type: "int", Multiple: true}, err: "field Tags has multiple choice but no values"},
+		{name: "multiple string", def: FieldDefinition{Name: "Tags", Type: "string", Multiple: true, Values: map[string]string{"a": "A"}}, err: "field Tags must be an int to have multiple choice"},
+		{name: "multiple not power of two", def: FieldDefinition{Name: "Tags", Type: "int", Multiple: true, Values: map[string]string{"3": "A"}}, err: "invalid value key 3 of field Tags"},
+		{name: "int value not a number", def: FieldDefinition{Name: "Status", Type: "int", Values: map[string]string{"draft": "Draft"}}, err: "invalid value key draft of field Status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, values, err := getDefinitionField("Post", tt.def)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if field != tt.field {
+				t.Fatalf("expected %+v, got %+v", tt.field, field)
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Fatalf("expected values %+v, got %+v", tt.values, values)
+			}
+		})
+	}
+}
+
+func TestGetDefinitionStruct(t *testing.T) {
+	s, values, err := getDefinitionStruct(ModelDefinition{Name: "BlogPost", Fields: []FieldDefinition{
+		{Name: "Title", Type: "string", Required: true},
+		{Name: "Status", Type: "int", Values: map[string]string{"1": "Draft"}},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if s.Name != "BlogPost" || s.Table != "blog_posts" {
+		t.Fatalf("unexpected struct %s with table %s", s.Name, s.Table)
+	}
+	fields := []string{}
+	for _, f := range s.Fields {
+		fields = append(fields, f.Name+" "+f.Type+" "+f.Tag)
+	}
+	expected := []string{
+		"ID int64 `json:\"blog_post_id\"`",
+		"Flags int64 `json:\"blog_post_flags\"`",
+		"Title string `json:\"title\" ui:\"req\"`",
+		"Status int `json:\"status\"`",
+		"CreatedAt int64 `json:\"created_at\"`",
+		"CreatedBy int64 `json:\"created_by\"`",
+		"LastModifiedAt int64 `json:\"last_modified_at\"`",
+		"LastModifiedBy int64 `json:\"last_modified_by\"`",
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected fields %v, got %v", expected, fields)
+	}
+	if len(values) != 1 || values[0].Key != "BlogPost_Status" {
+		t.Fatalf("unexpected values %+v", values)
+	}
+
+	_, _, err = getDefinitionStruct(ModelDefinition{Name: "BlogPost", Fields: []FieldDefinition{
+		{Name: "Title", Type: "string"},
+		{Name: "Title", Type: "text"},
+		{Name: "ID", Type: "int64"},
+	}})
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, s := range []string{"model BlogPost", "field Title is defined more than once", "field ID is added to each struct"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in %s", s, err)
+		}
+	}
+
+	for _, name := range []string{"", "blogPost", "Blog-Post"} {
+		if _, _, err := getDefinitionStruct(ModelDefinition{Name: name}); err == nil {
+			t.Errorf("expected error with struct name %q", name)
+		}
+	}
+}
+
+func TestGenerateModelsCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "models.yaml")
+	err := os.WriteFile(path, []byte(`
+- name: Post
+  fields:
+    - name: Title
+      type: string
+      required: true
+    - name: Status
+      type: int
+      values: {1: Draft, 2: Published}
+    - name: Lang
+      type: string
+      values: {en: English}
+`), 0644)
+	if err != nil {
+		t.Fatalf("error writing definitions: %s", err)
+	}
+
+	p := &Prototype{}
+	b := &strings.Builder{}
+	if ok, err := p.runCommand(b, []string{"generate-models", path}); !ok || err == nil {
+		t.Fatal("expected error with missing directory")
+	}
+	app := filepath.Join(dir, "app")
+	ok, err := p.runCommand(b, []string{"generate-models", path, app})
+	if !ok || err != nil {
+		t.Fatalf("expected models to be generated, got %v %v", ok, err)
+	}
+
+	fset := token.NewFileSet()
+	for _, name := range []string{"models.go", "main.go"} {
+		_, err := parser.ParseFile(fset, filepath.Join(app, name), nil, 0)
+		if err != nil {
+			t.Fatalf("error parsing %s: %s", name, err)
+		}
+	}
+	src, _ := os.ReadFile(filepath.Join(app, "main.go"))
+	for _, s := range []string{`"Post_Status": {Type: ui.ValuesSingleChoice, Values: map[int]string{1: "Draft", 2: "Published"}}`, `"Post_Lang": {Type: ui.ValuesSingleChoice, Values: map[string]string{"en": "English"}}`, "return &Post{}"} {
+		if !strings.Contains(string(src), s) {
+			t.Errorf("expected %s in main.go:\n%s", s, src)
+		}
+	}
+
+	err = os.WriteFile(path, []byte("- name: User\n- name: Post\n- name: Post\n"), 0644)
+	if err != nil {
+		t.Fatalf("error writing definitions: %s", err)
+	}
+	err = GenerateModels(path, app)
+	if err == nil || !strings.Contains(err.Error(), "model User is defined more than once or is used by prototyping") || !strings.Contains(err.Error(), "model Post is defined more than once") {
+		t.Fatalf("expected errors with builtin and duplicate models, got %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package prototyping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	crud "github.com/go-phings/crud"
+	sqldb "github.com/go-phings/struct-sql-postgres"
+	"github.com/go-phings/umbrella"
+)
+
+var fieldNameRegexp = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+func toInt64(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return int64(v.Float())
+	case reflect.String:
+		i, err := strconv.ParseInt(v.String(), 10, 64)
+		if err != nil {
+			return nil
+		}
+		return i
+	}
+	return nil
+}
+
+type modelField struct {
+	name     string
+	jsonName string
+	typ      reflect.Type
+	password bool
+}
+
+type modelStruct struct {
+	name        string
+	constructor func() interface{}
+	fields      []modelField
+}
+
+func isKindSupported(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// getModelStruct returns fields of a struct that can be exposed by the generated APIs. Field names are taken from
+// json tags.
+func getModelStruct(f func() interface{}) *modelStruct {
+	o := f()
+	t := reflect.TypeOf(o).Elem()
+	s := &modelStruct{
+		name:        sqldb.GetStructName(o),
+		constructor: f,
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || !isKindSupported(field.Type.Kind()) {
+			continue
+		}
+		jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !fieldNameRegexp.MatchString(jsonName) {
+			jsonName = field.Name
+		}
+		s.fields = append(s.fields, modelField{
+			name:     field.Name,
+			jsonName: jsonName,
+			typ:      field.Type,
+			password: strings.Contains(" "+field.Tag.Get("ui")+" ", " password "),
+		})
+	}
+	return s
+}
+
+// getAllowedTypesFromContext returns types allowed for the operation that wrapHandlerWithUmbrella put in the context
+func getAllowedTypesFromContext(ctx context.Context, op int) map[string]bool {
+	allowedTypes, _ := ctx.Value(crud.ContextValue(fmt.Sprintf("AllowedTypes_%d", op))).(map[string]bool)
+	return allowedTypes
+}
+
+func getORMFromContext(ctx context.Context) ORM {
+	orm, _ := ctx.Value(ormContextKey).(ORM)
+	return orm
+}
+
+// isFieldReadable checks field permissions of the logged user
+func (p *Prototype) isFieldReadable(ctx context.Context, structName string, field string) bool {
+	for _, rule := range p.fieldRules[structName] {
+		if rule.name == field && rule.read {
+			return isTypeAllowed(getAllowedTypesFromContext(ctx, umbrella.OpsRead), fmt.Sprintf("%s.%s", structName, field))
+		}
+	}
+	return true
+}
+
+func (p *Prototype) loadObject(ctx context.Context, s *modelStruct, id interface{}) (interface{}, error) {
+	obj := s.constructor()
+	orm := getORMFromContext(ctx)
+	err := orm.Load(obj, fmt.Sprintf("%d", toInt64(id)))
+	if err != nil {
+		return nil, err
+	}
+	if orm.GetObjIDValue(obj) == 0 {
+		return nil, nil
+	}
+	return obj, nil
+}
+
+func (p *Prototype) getObjects(ctx context.Context, s *modelStruct, args map[string]interface{}, extraFilters map[string]interface{}) (interface{}, error) {
+	filters, err := p.getReadableObjectFilters(ctx, s, args["filter"])
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range extraFilters {
+		if filters == nil {
+			filters = map[string]interface{}{}
+		}
+		filters[k] = v
+	}
+
+	limit, _ := args["limit"].(int)
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+	offset, _ := args["offset"].(int)
+	if offset < 0 {
+		offset = 0
+	}
+
+	order := []string{"ID", "asc"}
+	if o, _ := args["order"].(string); o != "" {
+		field := s.getField(o)
+		if field == nil || field.password || !p.isFieldReadable(ctx, s.name, field.name) {
+			return nil, fmt.Errorf("invalid order field %s", o)
+		}
+		order[0] = field.name
+	}
+	if d, _ := args["orderDirection"].(string); strings.ToLower(d) == "desc" {
+		order[1] = "desc"
+	}
+
+	return getORMFromContext(ctx).Get(s.constructor, order, limit, offset, filters, nil)
+}
+
+func (s *modelStruct) getField(jsonName string) *modelField {
+	for i := range s.fields {
+		if s.fields[i].jsonName == jsonName {
+			return &s.fields[i]
+		}
+	}
+	return nil
+}
+
+// getObjectFilters converts filter input to ORM filters with values of the field types
+func getObjectFilters(s *modelStruct, input interface{}) (map[string]interface{}, error) {
+	m, _ := input.(map[string]interface{})
+	if len(m) == 0 {
+		return nil, nil
+	}
+	filters := map[string]interface{}{}
+	for k, v := range m {
+		field := s.getField(k)
+		if field == nil || v == nil {
+			continue
+		}
+		if field.password {
+			return nil, fmt.Errorf("invalid filter field %s", k)
+		}
+		val, err := convertValue(v, field.typ)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s", k)
+		}
+		filters[field.name] = val.Interface()
+	}
+	return filters, nil
+}
+
+// getReadableObjectFilters returns filters from getObjectFilters when logged user can read all the filtered fields
+func (p *Prototype) getReadableObjectFilters(ctx context.Context, s *modelStruct, input interface{}) (map[string]interface{}, error) {
+	filters, err := getObjectFilters(s, input)
+	if err != nil {
+		return nil, err
+	}
+	for k := range filters {
+		if !p.isFieldReadable(ctx, s.name, k) {
+			return nil, fmt.Errorf("invalid filter field %s", k)
+		}
+	}
+	return filters, nil
+}
+
+func convertValue(v interface{}, t reflect.Type) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if !rv.Type().ConvertibleTo(t) || (rv.Kind() == reflect.String) != (t.Kind() == reflect.String) {
+		return reflect.Value{}, errors.New("invalid type")
+	}
+	return rv.Convert(t), nil
+}
+
+// saveObject creates a new object when id is nil or updates existing one with the values from input. Object is
+// validated with its ui tags the same way as in the REST API.
+func (p *Prototype) saveObject(ctx context.Context, s *modelStruct, id interface{}, input interface{}) (interface{}, error) {
+	orm := getORMFromContext(ctx)
+	writeOp := umbrella.OpsCreate
+	obj := s.constructor()
+	if id != nil {
+		writeOp = umbrella.OpsUpdate
+		loaded, err := p.loadObject(ctx, s, id)
+		if err != nil {
+			return nil, err
+		}
+		if loaded == nil {
+			return nil, errors.New("NotFound")
+		}
+		obj = loaded
+	}
+
+	values, _ := input.(map[string]interface{})
+	v := reflect.ValueOf(obj).Elem()
+	for k, val := range values {
+		field := s.getField(k)
+		if field == nil || field.name == "ID" || val == nil {
+			continue
+		}
+		for _, rule := range p.fieldRules[s.name] {
+			if rule.name == field.name && (rule.readOnly || (rule.write && !isTypeAllowed(getAllowedTypesFromContext(ctx, writeOp), fmt.Sprintf("%s.%s", s.name, field.name)))) {
+				return nil, errors.New("FieldNotAllowed")
+			}
+		}
+		if field.password {
+			str, _ := val.(string)
+			val = p.generatePassword(str)
+		}
+		cv, err := convertValue(val, field.typ)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s", k)
+		}
+		v.FieldByName(field.name).Set(cv)
+	}
+
+	var userID int64
+	if lu := getLoggedUserFromContext(ctx); lu != nil {
+		userID = lu.id
+	}
+	now := time.Now().Unix()
+	setInt64Field := func(name string, value int64) {
+		f := v.FieldByName(name)
+		if f.IsValid() && f.Kind() == reflect.Int64 {
+			f.SetInt(value)
+		}
+	}
+	if id == nil {
+		setInt64Field("CreatedAt", now)
+		setInt64Field("CreatedBy", userID)
+	}
+	setInt64Field("LastModifiedAt", now)
+	setInt64Field("LastModifiedBy", userID)
+
+	err := validateObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	err = orm.Save(obj)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
@@ -0,0 +1,354 @@
+package prototyping
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-phings/umbrella"
+	"golang.org/x/oauth2"
+)
+
+const oidcStateCookieName = "ProtoOIDCState"
+
+// OIDCConfig contains OpenID Connect identity provider settings for the administration panel login
+type OIDCConfig struct {
+	// IssuerURL is used for discovery of the provider's endpoints
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL must point to /ui/r/oidc/callback/ of the prototype
+	RedirectURL string
+	// Scopes are requested in addition to "openid", defaults to "profile" and "email"
+	Scopes []string
+	// EmailClaim and NameClaim are names of the ID token claims mapped to user's email and name
+	EmailClaim string
+	NameClaim  string
+	// AutoProvision creates users that log in for the first time
+	AutoProvision bool
+	// DefaultPermissionOps and DefaultPermissionTypes describe the permission given to auto-provisioned users
+	DefaultPermissionOps   int64
+	DefaultPermissionTypes []string
+	// DisablePasswordLogin redirects the login page to the identity provider
+	DisablePasswordLogin bool
+}
+
+type oidcClient struct {
+	cfg      *OIDCConfig
+	mu       sync.Mutex
+	provider *oidc.Provider
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func newOIDCClient(cfg *OIDCConfig) *oidcClient {
+	c := &oidcClient{
+		cfg: cfg,
+	}
+	if c.cfg.EmailClaim == "" {
+		c.cfg.EmailClaim = "email"
+	}
+	if c.cfg.NameClaim == "" {
+		c.cfg.NameClaim = "name"
+	}
+	if len(c.cfg.Scopes) == 0 {
+		c.cfg.Scopes = []string{"profile", "email"}
+	}
+	return c
+}
+
+// init runs discovery on first use so that the prototype starts even when the provider is not reachable
+func (c *oidcClient) init(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.provider != nil {
+		return nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, c.cfg.IssuerURL)
+	if err != nil {
+		return fmt.Errorf("error with oidc discovery: %w", err)
+	}
+	c.provider = provider
+	c.oauth2 = &oauth2.Config{
+		ClientID:     c.cfg.ClientID,
+		ClientSecret: c.cfg.ClientSecret,
+		RedirectURL:  c.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID}, c.cfg.Scopes...),
+	}
+	c.verifier = provider.Verifier(&oidc.Config{ClientID: c.cfg.ClientID})
+	return nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("error generating random bytes: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// oidcIdentity contains claims of a verified ID token
+type oidcIdentity struct {
+	issuer        string
+	subject       string
+	email         string
+	emailVerified bool
+	name          string
+}
+
+// getOIDCLoginHTTPHandler returns handler that redirects to the identity provider, keeping state, nonce and PKCE
+// verifier in a signed cookie
+func (p *Prototype) getOIDCLoginHTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := p.oidc.init(r.Context())
+		if err != nil {
+			p.logger.Error("error initializing oidc", slog.Any("error", err))
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		state, err := randomString()
+		if err != nil {
+			p.logger.Error("error generating oidc state", slog.Any("error", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		nonce, err := randomString()
+		if err != nil {
+			p.logger.Error("error generating oidc nonce", slog.Any("error", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		verifier := oauth2.GenerateVerifier()
+
+		err = p.setSignedCookie(w, oidcStateCookieName, p.uriUI, &signedValue{
+			ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
+			Values: map[string]string{
+				"state":    state,
+				"nonce":    nonce,
+				"verifier": verifier,
+			},
+		})
+		if err != nil {
+			p.logger.Error("error setting oidc state cookie", slog.Any("error", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, p.oidc.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), http.StatusFound)
+	})
+}
+
+// getOIDCCallbackHTTPHandler returns handler that exchanges the code, verifies ID token and logs the user in
+func (p *Prototype) getOIDCCallbackHTTPHandler(successRedirectURL string, failureRedirectURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := p.handleOIDCCallback(w, r)
+		if err != nil {
+			p.logger.Error("error with oidc login", slog.Any("error", err))
+			http.Redirect(w, r, failureRedirectURL, http.StatusSeeOther)
+			return
+		}
+
+		expiresAt := time.Now().Add(p.sessionExpiration).Unix()
+		sid, err := p.createSession(r.Context(), userID, expiresAt)
+		if err != nil {
+			p.logger.Error("error creating session", slog.Any("error", err))
+			http.Redirect(w, r, failureRedirectURL, http.StatusSeeOther)
+			return
+		}
+		err = p.setSignedCookie(w, sessionCookieName, p.uriUI, &signedValue{
+			UserID:    userID,
+			ExpiresAt: expiresAt,
+			Values:    map[string]string{"sid": sid},
+		})
+		if err != nil {
+			p.logger.Error("error setting session cookie", slog.Any("error", err))
+			http.Redirect(w, r, failureRedirectURL, http.StatusSeeOther)
+			return
+		}
+
+		http.Redirect(w, r, successRedirectURL, http.StatusSeeOther)
+	})
+}
+
+func (p *Prototype) handleOIDCCallback(w http.ResponseWriter, r *http.Request) (int64, error) {
+	identity, err := p.getOIDCIdentity(w, r)
+	if err != nil {
+		return 0, err
+	}
+	return p.getOrProvisionOIDCUser(r.Context(), identity)
+}
+
+// getOIDCIdentity exchanges the code from the callback request and returns claims of the verified ID token
+func (p *Prototype) getOIDCIdentity(w http.ResponseWriter, r *http.Request) (*oidcIdentity, error) {
+	err := p.oidc.init(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	stateCookie := p.getSignedCookie(r, oidcStateCookieName)
+	clearCookie(w, oidcStateCookieName, p.uriUI)
+	if stateCookie == nil {
+		return nil, errors.New("missing state cookie")
+	}
+	if r.URL.Query().Get("error") != "" {
+		return nil, fmt.Errorf("provider returned error: %s", r.URL.Query().Get("error"))
+	}
+	if r.URL.Query().Get("state") != stateCookie.Values["state"] {
+		return nil, errors.New("invalid state")
+	}
+
+	token, err := p.oidc.oauth2.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(stateCookie.Values["verifier"]))
+	if err != nil {
+		return nil, fmt.Errorf("error exchanging code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("missing id token")
+	}
+	idToken, err := p.oidc.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying id token: %w", err)
+	}
+	if idToken.Nonce != stateCookie.Values["nonce"] {
+		return nil, errors.New("invalid nonce")
+	}
+
+	claims := map[string]interface{}{}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("error getting claims: %w", err)
+	}
+	verified, ok := claims["email_verified"].(bool)
+	if ok && !verified {
+		return nil, errors.New("email is not verified")
+	}
+	email, _ := claims[p.oidc.cfg.EmailClaim].(string)
+	if email == "" {
+		return nil, errors.New("missing email claim")
+	}
+	name, _ := claims[p.oidc.cfg.NameClaim].(string)
+
+	return &oidcIdentity{
+		issuer:        idToken.Issuer,
+		subject:       idToken.Subject,
+		email:         email,
+		emailVerified: verified,
+		name:          name,
+	}, nil
+}
+
+// getOrProvisionOIDCUser returns ID of the user linked to the identity. When there is none, user with the same
+// email is linked if the provider has verified the email, or a new user is created when auto-provisioning is
+// enabled.
+func (p *Prototype) getOrProvisionOIDCUser(ctx context.Context, identity *oidcIdentity) (int64, error) {
+	rows, err := p.ormWithContext(ctx).Get(func() interface{} { return &UserIdentity{} }, []string{"ID", "asc"}, 1, 0, map[string]interface{}{
+		"Issuer":  identity.issuer,
+		"Subject": identity.subject,
+	}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error getting user identity: %w", err)
+	}
+	user := p.getUserInterface(ctx)
+	if len(rows) > 0 {
+		userID := rows[0].(*UserIdentity).UserID
+		found, err := user.GetByID(userID)
+		if err != nil {
+			return 0, fmt.Errorf("error getting user: %w", err)
+		}
+		if !found {
+			return 0, fmt.Errorf("user %d linked to subject %s does not exist", userID, identity.subject)
+		}
+		if !isUserActive(user) {
+			return 0, fmt.Errorf("user %d linked to subject %s is not active", userID, identity.subject)
+		}
+		return userID, nil
+	}
+
+	email, name := identity.email, identity.name
+	found, err := user.GetByEmail(email)
+	if err != nil {
+		return 0, fmt.Errorf("error getting user: %w", err)
+	}
+	if found {
+		if !identity.emailVerified {
+			return 0, fmt.Errorf("user %s cannot be linked as the provider has not verified the email", email)
+		}
+		if !isUserActive(user) {
+			return 0, fmt.Errorf("user %s is not active", email)
+		}
+		return user.GetID(), p.linkOIDCIdentity(ctx, user.GetID(), identity)
+	}
+	if !p.oidc.cfg.AutoProvision {
+		return 0, fmt.Errorf("user %s does not exist", email)
+	}
+
+	if name == "" {
+		name = email
+	}
+	password, err := randomString()
+	if err != nil {
+		return 0, err
+	}
+	key, errUmb := p.umbrella.CreateUser(email, password, map[string]string{
+		"Name": name,
+	})
+	if errUmb != nil {
+		return 0, fmt.Errorf("error creating user: %w", errUmb.Unwrap())
+	}
+	errUmb = p.umbrella.ConfirmEmail(key)
+	if errUmb != nil {
+		return 0, fmt.Errorf("error confirming user email: %w", errUmb.Unwrap())
+	}
+
+	user = p.getUserInterface(ctx)
+	found, err = user.GetByEmail(email)
+	if err != nil || !found {
+		return 0, fmt.Errorf("error getting created user: %w", err)
+	}
+
+	for _, t := range p.oidc.cfg.DefaultPermissionTypes {
+		perm := &umbrella.Permission{
+			Flags:   umbrella.FlagTypeAllow,
+			ForType: umbrella.ForTypeUser,
+			ForItem: user.GetID(),
+			Ops:     p.oidc.cfg.DefaultPermissionOps,
+			ToType:  t,
+		}
+		err = p.ormWithContext(ctx).Save(perm)
+		if err != nil {
+			return 0, fmt.Errorf("error saving default permission: %w", err)
+		}
+	}
+	err = p.linkOIDCIdentity(ctx, user.GetID(), identity)
+	if err != nil {
+		return 0, err
+	}
+	p.callUserHook(ctx, "Created", p.userHooks.Created, user.GetID())
+
+	return user.GetID(), nil
+}
+
+func (p *Prototype) linkOIDCIdentity(ctx context.Context, userID int64, identity *oidcIdentity) error {
+	err := p.ormWithContext(ctx).Save(&UserIdentity{
+		UserID:    userID,
+		Issuer:    identity.issuer,
+		Subject:   identity.subject,
+		CreatedAt: time.Now().Unix(),
+		CreatedBy: userID,
+	})
+	if err != nil {
+		return fmt.Errorf("error saving user identity: %w", err)
+	}
+	return nil
+}
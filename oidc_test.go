@@ -0,0 +1,239 @@
+package prototyping
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// testOIDCProvider is an identity provider that issues ID tokens signed with its key for any code
+type testOIDCProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	nonce string
+	// challenge is the PKCE code challenge sent to the authorization endpoint
+	challenge string
+	// claims overwrite the default ones of the issued ID token
+	claims map[string]interface{}
+	// signingKey overwrites the key that ID token is signed with
+	signingKey *rsa.PrivateKey
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err)
+	}
+	o := &testOIDCProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                o.URL,
+			"authorization_endpoint":                o.URL + "/auth",
+			"token_endpoint":                        o.URL + "/token",
+			"jwks_uri":                              o.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &o.key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		h := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "testcode" || base64.RawURLEncoding.EncodeToString(h[:]) != o.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     o.getIDToken(t),
+		})
+	})
+	o.Server = httptest.NewServer(mux)
+	t.Cleanup(o.Close)
+	return o
+}
+
+func (o *testOIDCProvider) getIDToken(t *testing.T) string {
+	claims := map[string]interface{}{
+		"iss":            o.URL,
+		"sub":            "subject-1",
+		"aud":            "client",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          o.nonce,
+		"email":          "user@example.com",
+		"email_verified": true,
+		"name":           "User",
+	}
+	for k, v := range o.claims {
+		claims[k] = v
+	}
+	key := o.key
+	if o.signingKey != nil {
+		key = o.signingKey
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatalf("error creating signer: %s", err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatalf("error signing id token: %s", err)
+	}
+	return token
+}
+
+func newTestOIDCPrototype(provider *testOIDCProvider) *Prototype {
+	return &Prototype{
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		tracer:     newNoopTracer(),
+		uriUI:      "/ui/",
+		sessionKey: []byte(strings.Repeat("k", 32)),
+		oidc: newOIDCClient(&OIDCConfig{
+			IssuerURL:    provider.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			RedirectURL:  "http://localhost/ui/r/oidc/callback/",
+		}),
+	}
+}
+
+// loginWithTestOIDCProvider goes through the login redirect and returns the callback request
+func loginWithTestOIDCProvider(t *testing.T, p *Prototype, provider *testOIDCProvider) *http.Request {
+	rec := httptest.NewRecorder()
+	p.getOIDCLoginHTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ui/r/oidc/login/", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect to the provider, got %d", rec.Code)
+	}
+	u, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(u.String(), provider.URL+"/auth") {
+		t.Fatalf("invalid redirect to the provider: %s", rec.Header().Get("Location"))
+	}
+	q := u.Query()
+	if q.Get("client_id") != "client" || q.Get("code_challenge_method") != "S256" || q.Get("state") == "" || q.Get("nonce") == "" {
+		t.Fatalf("invalid authorization request: %s", u.RawQuery)
+	}
+	provider.mu.Lock()
+	provider.nonce = q.Get("nonce")
+	provider.challenge = q.Get("code_challenge")
+	provider.mu.Unlock()
+
+	req := httptest.NewRequest(http.MethodGet, "/ui/r/oidc/callback/?code=testcode&state="+url.QueryEscape(q.Get("state")), nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestOIDCIdentity(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	p := newTestOIDCPrototype(provider)
+
+	req := loginWithTestOIDCProvider(t, p, provider)
+	identity, err := p.getOIDCIdentity(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatalf("error getting identity: %s", err)
+	}
+	expected := oidcIdentity{
+		issuer:        provider.URL,
+		subject:       "subject-1",
+		email:         "user@example.com",
+		emailVerified: true,
+		name:          "User",
+	}
+	if *identity != expected {
+		t.Fatalf("expected identity %+v, got %+v", expected, *identity)
+	}
+}
+
+func TestOIDCIdentityRejected(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		claims     map[string]interface{}
+		signingKey *rsa.PrivateKey
+		modify     func(r *http.Request) *http.Request
+	}{
+		{name: "invalid nonce", claims: map[string]interface{}{"nonce": "other"}},
+		{name: "other audience", claims: map[string]interface{}{"aud": "other"}},
+		{name: "other issuer", claims: map[string]interface{}{"iss": "https://other.example.com"}},
+		{name: "expired", claims: map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}},
+		{name: "unverified email", claims: map[string]interface{}{"email_verified": false}},
+		{name: "missing email", claims: map[string]interface{}{"email": ""}},
+		{name: "other key", signingKey: otherKey},
+		{name: "invalid state", modify: func(r *http.Request) *http.Request {
+			r.URL.RawQuery = "code=testcode&state=other"
+			return r
+		}},
+		{name: "missing state cookie", modify: func(r *http.Request) *http.Request {
+			return httptest.NewRequest(http.MethodGet, r.URL.String(), nil)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestOIDCProvider(t)
+			provider.claims = tt.claims
+			provider.signingKey = tt.signingKey
+			p := newTestOIDCPrototype(provider)
+
+			req := loginWithTestOIDCProvider(t, p, provider)
+			if tt.modify != nil {
+				req = tt.modify(req)
+			}
+			identity, err := p.getOIDCIdentity(httptest.NewRecorder(), req)
+			if err == nil {
+				t.Fatalf("expected error, got identity %+v", *identity)
+			}
+		})
+	}
+}
+
+func TestOIDCConfigRequiresSessionKey(t *testing.T) {
+	cfg := Config{
+		DatabaseDSN: "postgres://localhost/db",
+		OIDC: &OIDCConfig{
+			IssuerURL:   "https://idp.example.com",
+			ClientID:    "client",
+			RedirectURL: "https://example.com/ui/r/oidc/callback/",
+		},
+	}
+	err := validateConfig(&cfg)
+	if err == nil || !strings.Contains(err.Error(), "session key is required") {
+		t.Fatalf("expected session key error, got %v", err)
+	}
+
+	cfg.SessionKey = strings.Repeat("k", 32)
+	if err := validateConfig(&cfg); err != nil {
+		t.Fatalf("expected valid config, got %s", err)
+	}
+}
@@ -3,8 +3,14 @@ package prototyping
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 
 	struct2db "github.com/go-phings/struct-db-postgres"
+	sqldb "github.com/go-phings/struct-sql-postgres"
+	validator "github.com/mikolajgs/struct-validator"
 )
 
 type ORMError interface {
@@ -193,3 +199,180 @@ func (w *wrappedStruct2db) RegisterStruct(obj interface{}, inheritFromObj interf
 	}
 	return nil
 }
+
+// fieldsUpdater is implemented by ORMs that can update only specific fields of the rows matching filters. Unlike
+// Save, it does not overwrite fields changed in the meantime, and filters make the update conditional, so the number
+// of updated rows tells whether the condition was met.
+type fieldsUpdater interface {
+	updateFields(obj interface{}, values map[string]interface{}, filters map[string]interface{}) (int64, error)
+}
+
+var errUpdateFieldsNotSupported = errors.New("orm does not support updating fields")
+
+// updateFields updates fields in values of the rows matching filters and returns the number of updated rows
+func updateFields(orm ORM, obj interface{}, values map[string]interface{}, filters map[string]interface{}) (int64, error) {
+	u, ok := orm.(fieldsUpdater)
+	if !ok {
+		return 0, errUpdateFieldsNotSupported
+	}
+	return u.updateFields(obj, values, filters)
+}
+
+func (w *wrappedStruct2db) updateFields(obj interface{}, values map[string]interface{}, filters map[string]interface{}) (int64, error) {
+	s := sqldb.NewStructSQL(obj, sqldb.StructSQLOptions{DatabaseTablePrefix: w.tblPrefix, TagName: w.tagName})
+	if s.Err() != nil {
+		return 0, s.Err()
+	}
+	q := s.GetQueryUpdate(values, filters, nil, nil)
+	args, err := getUpdateArgs(s, obj, q, values, filters)
+	if err != nil {
+		return 0, err
+	}
+	if raw, ok := filters["_raw"]; ok {
+		args = append(args, w.orm.GetFiltersInterfaces(map[string]interface{}{"_raw": raw})...)
+	}
+
+	res, err := w.dbConn.Exec(q, args...)
+	if err != nil {
+		return 0, errors.New("update failed")
+	}
+	return res.RowsAffected()
+}
+
+// getUpdateArgs returns arguments of the query generated by struct-sql-postgres, which are values sorted by their
+// columns followed by filters sorted by their fields, without the ones of the raw filter
+func getUpdateArgs(s *sqldb.StructSQL, obj interface{}, q string, values map[string]interface{}, filters map[string]interface{}) ([]interface{}, error) {
+	set := strings.SplitN(q, " SET ", 2)
+	if len(set) != 2 {
+		return nil, fmt.Errorf("invalid query: %s", q)
+	}
+	cols := strings.Split(strings.SplitN(set[1], " WHERE ", 2)[0], ",")
+	if len(values) == 0 || len(cols) != len(values) || cols[0] == "" {
+		return nil, fmt.Errorf("values are not columns of %s", sqldb.GetStructName(obj))
+	}
+	args := []interface{}{}
+	for _, col := range cols {
+		args = append(args, values[s.GetFieldNameFromDBCol(strings.SplitN(col, "=", 2)[0])])
+	}
+
+	names := []string{}
+	for k := range filters {
+		if k == "_raw" || k == "_rawConjuction" {
+			continue
+		}
+		if !sqldb.IsStructField(obj, strings.SplitN(k, ":", 2)[0]) {
+			return nil, fmt.Errorf("filter %s is not a column of %s", k, sqldb.GetStructName(obj))
+		}
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.SplitN(names[i], ":", 2)[0] < strings.SplitN(names[j], ":", 2)[0]
+	})
+	for _, k := range names {
+		args = append(args, filters[k])
+	}
+	return args, nil
+}
+
+// columnsInserter is implemented by ORMs that can insert an object along with columns that are not its fields
+type columnsInserter interface {
+	insertWithColumns(obj interface{}, columns map[string]interface{}) error
+}
+
+var errInsertWithColumnsNotSupported = errors.New("orm does not support inserting additional columns")
+
+// insertWithColumns inserts object with additional columns, eg. the tenant column, and sets its ID
+func insertWithColumns(orm ORM, obj interface{}, columns map[string]interface{}) error {
+	i, ok := orm.(columnsInserter)
+	if !ok {
+		return errInsertWithColumnsNotSupported
+	}
+	return i.insertWithColumns(obj, columns)
+}
+
+func (w *wrappedStruct2db) insertWithColumns(obj interface{}, columns map[string]interface{}) error {
+	s := sqldb.NewStructSQL(obj, sqldb.StructSQLOptions{DatabaseTablePrefix: w.tblPrefix, TagName: w.tagName})
+	if s.Err() != nil {
+		return s.Err()
+	}
+	q := s.GetQueryInsert()
+	cols, vals, err := getColumnsAndValues(q, obj)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for k := range columns {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		cols = append(cols, k)
+		vals = append(vals, columns[k])
+	}
+
+	params := make([]string, 0, len(cols))
+	for i := 1; i <= len(cols); i++ {
+		params = append(params, fmt.Sprintf("$%d", i))
+	}
+	tbl := strings.TrimPrefix(q[:strings.Index(q, "(")], "INSERT INTO ")
+	idCol := q[strings.LastIndex(q, " RETURNING ")+len(" RETURNING "):]
+	err = w.dbConn.QueryRow(fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s) RETURNING %s", tbl, strings.Join(cols, ","), strings.Join(params, ","), idCol), vals...).Scan(reflect.ValueOf(obj).Elem().FieldByName("ID").Addr().Interface())
+	if err != nil {
+		return errors.New("insert failed")
+	}
+	return nil
+}
+
+// getColumnsAndValues returns columns of the INSERT query generated by struct-sql-postgres and values of the fields
+// that they are for, which are all the fields of supported kinds apart from ID, in the order of the struct
+func getColumnsAndValues(insertQuery string, obj interface{}) ([]string, []interface{}, error) {
+	start := strings.Index(insertQuery, "(")
+	end := strings.Index(insertQuery, ") VALUES (")
+	if start == -1 || end < start || !strings.Contains(insertQuery, " RETURNING ") {
+		return nil, nil, fmt.Errorf("invalid query: %s", insertQuery)
+	}
+	cols := strings.Split(insertQuery[start+1:end], ",")
+
+	vals := []interface{}{}
+	v := reflect.ValueOf(obj).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Name == "ID" || !sqldb.IsFieldKindSupported(v.Field(i).Kind()) {
+			continue
+		}
+		vals = append(vals, v.Field(i).Interface())
+	}
+	if len(cols) != len(vals) {
+		return nil, nil, fmt.Errorf("fields do not match columns of query: %s", insertQuery)
+	}
+	return cols, vals, nil
+}
+
+// getFieldValues returns values of the fields of supported kinds apart from ID, which are the ones that have columns
+func getFieldValues(obj interface{}) map[string]interface{} {
+	values := map[string]interface{}{}
+	v := reflect.ValueOf(obj).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Name == "ID" || !v.Type().Field(i).IsExported() || !sqldb.IsFieldKindSupported(v.Field(i).Kind()) {
+			continue
+		}
+		values[v.Type().Field(i).Name] = v.Field(i).Interface()
+	}
+	return values
+}
+
+// validateObject checks field values against the ui tag the same way as the default ORM does before saving
+func validateObject(obj interface{}) error {
+	valid, failed := validator.Validate(obj, &validator.ValidationOptions{
+		ValidateWhenSuffix: true,
+		OverwriteTagName:   "ui",
+	})
+	if valid {
+		return nil
+	}
+	fields := make([]string, 0, len(failed))
+	for k := range failed {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	return fmt.Errorf("invalid value of %s", strings.Join(fields, ", "))
+}
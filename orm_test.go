@@ -0,0 +1,71 @@
+package prototyping
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+)
+
+type testUpdateItem struct {
+	ID         int64
+	Flags      int64
+	Name       string
+	LastUsedAt int64
+	Tags       []string
+}
+
+func TestGetUpdateArgs(t *testing.T) {
+	obj := &testUpdateItem{}
+	s := sqldb.NewStructSQL(obj, sqldb.StructSQLOptions{TagName: "ui"})
+	values := map[string]interface{}{"Name": "a", "LastUsedAt": int64(5)}
+	filters := map[string]interface{}{"ID": int64(1), "Flags:&": 1, "LastUsedAt:<": int64(5), "_raw": []interface{}{"tenant_id = ?", int64(2)}}
+	q := s.GetQueryUpdate(values, filters, nil, nil)
+	if q != "UPDATE test_update_items SET last_used_at=$1,name=$2 WHERE (test_update_item_flags&$3>0 AND test_update_item_id=$4 AND last_used_at<$5) AND (tenant_id = $6)" {
+		t.Fatalf("unexpected query: %s", q)
+	}
+
+	args, err := getUpdateArgs(s, obj, q, values, filters)
+	if err != nil {
+		t.Fatalf("error getting args: %s", err)
+	}
+	expected := []interface{}{int64(5), "a", 1, int64(1), int64(5)}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %v, got %v", expected, args)
+	}
+
+	_, err = getUpdateArgs(s, obj, s.GetQueryUpdate(map[string]interface{}{"Tags": nil}, nil, nil, nil), map[string]interface{}{"Tags": nil}, nil)
+	if err == nil {
+		t.Error("expected error with value that is not a column")
+	}
+	_, err = getUpdateArgs(s, obj, q, values, map[string]interface{}{"Tags": nil})
+	if err == nil {
+		t.Error("expected error with filter that is not a column")
+	}
+}
+
+func TestUpdateFieldsThroughWrappers(t *testing.T) {
+	o := &testORM{}
+	c := newUserCache(time.Minute)
+	c.set(&loggedUser{id: 1}, c.getGeneration())
+	orm := &cacheInvalidatingORM{orm: o, cache: c, userStructName: "testUpdateItem"}
+
+	cnt, err := updateFields(orm, &testUpdateItem{}, map[string]interface{}{"LastUsedAt": int64(2)}, map[string]interface{}{"ID": int64(1)})
+	if err != nil || cnt != 1 {
+		t.Fatalf("expected 1 updated row, got %d %v", cnt, err)
+	}
+	if len(o.calls) != 1 || o.calls[0] != "UpdateFields" {
+		t.Fatalf("expected call to be passed to the wrapped orm, got %v", o.calls)
+	}
+	if c.get(1) != nil {
+		t.Fatal("expected cache to be invalidated")
+	}
+
+	// ORM that does not implement it, eg. the one passed in the config
+	_, err = updateFields(struct{ ORM }{o}, &testUpdateItem{}, nil, nil)
+	if !errors.Is(err, errUpdateFieldsNotSupported) {
+		t.Fatalf("expected error with orm that does not support it, got %v", err)
+	}
+}
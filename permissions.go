@@ -0,0 +1,410 @@
+package prototyping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	ui "github.com/go-phings/crud-ui"
+	sqldb "github.com/go-phings/struct-sql-postgres"
+	"github.com/go-phings/umbrella"
+)
+
+var permissionsTemplate = template.Must(template.New("permissions").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Permissions</title></head>
+<body>
+<h1>Permissions</h1>
+<form method="get" action="{{.URI}}">
+<p><label>Add group ID <input type="number" name="group_id" value="{{if .GroupID}}{{.GroupID}}{{end}}" min="1"></label>
+<input type="submit" value="Add"></p>
+</form>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+{{if .Saved}}<p>Permissions saved.</p>{{end}}
+<form method="post" action="{{.URI}}?page={{.Page}}{{if .GroupID}}&group_id={{.GroupID}}{{end}}">
+<table>
+<tr><th>Subject</th>{{range .Types}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr><td>{{.Label}}<input type="hidden" name="subject" value="{{.Key}}">{{if .UserID}} <a href="{{$.URI}}?effective={{.UserID}}">effective</a>{{end}}</td>
+{{range .Cells}}<td>{{$name := .Name}}{{range .Ops}}<label title="{{.Name}}"><input type="checkbox" name="{{$name}}" value="{{.Value}}"{{if .Checked}} checked{{end}}>{{slice .Name 0 1}}</label> {{end}}</td>{{end}}</tr>
+{{end}}
+</table>
+<p><input type="submit" value="Save"></p>
+</form>
+{{if or (gt .Page 1) .NextPage}}<p>Users page {{.Page}}
+{{if gt .Page 1}}<a href="{{.URI}}?page={{.PrevPage}}{{if .GroupID}}&group_id={{.GroupID}}{{end}}">Previous</a>{{end}}
+{{if .NextPage}}<a href="{{.URI}}?page={{.NextPage}}{{if .GroupID}}&group_id={{.GroupID}}{{end}}">Next</a>{{end}}</p>{{end}}
+{{if .Effective}}
+<h2>Effective permissions of {{.EffectiveUser}}</h2>
+<table>
+<tr><th>Type</th><th>Operations</th></tr>
+{{range .Effective}}<tr><td>{{.Type}}</td><td>{{.Ops}}</td></tr>
+{{end}}
+</table>
+{{end}}
+<p><a href="/ui/">Back</a></p>
+</body>
+</html>
+`))
+
+// permissionSubject is a user, a group or everyone that permissions are given to
+type permissionSubject struct {
+	ForType int8
+	ForItem int64
+	Label   string
+}
+
+func (s permissionSubject) getKey() string {
+	return fmt.Sprintf("%d_%d", s.ForType, s.ForItem)
+}
+
+type permissionsTemplateOp struct {
+	Name    string
+	Value   int
+	Checked bool
+}
+
+type permissionsTemplateCell struct {
+	Name string
+	Ops  []permissionsTemplateOp
+}
+
+type permissionsTemplateRow struct {
+	Key    string
+	Label  string
+	UserID int64
+	Cells  []permissionsTemplateCell
+}
+
+type permissionsTemplateEffective struct {
+	Type string
+	Ops  string
+}
+
+// getPermissionTypes returns values for Permission's ToType field, which are registered structs and their restricted
+// fields
+func (p *Prototype) getPermissionTypes() []string {
+	names := []string{}
+	for _, f := range p.getExposedConstructors() {
+		names = append(names, sqldb.GetStructName(f()))
+	}
+	sort.Strings(names)
+	types := append([]string{"all"}, names...)
+
+	fields := []string{}
+	for structName, rules := range p.fieldRules {
+		for _, rule := range rules {
+			if !rule.read && !rule.write {
+				continue
+			}
+			fields = append(fields, fmt.Sprintf("%s.%s", structName, rule.name))
+		}
+	}
+	sort.Strings(fields)
+	return append(types, fields...)
+}
+
+// setDefaultPermissionFieldValues fills choices of Permission fields in the UI unless they are already configured
+func (p *Prototype) setDefaultPermissionFieldValues() {
+	if p.intFieldValues == nil {
+		p.intFieldValues = map[string]ui.IntFieldValues{}
+	}
+	if p.stringFieldValues == nil {
+		p.stringFieldValues = map[string]ui.StringFieldValues{}
+	}
+
+	if _, ok := p.intFieldValues["Permission_Flags"]; !ok {
+		p.intFieldValues["Permission_Flags"] = ui.IntFieldValues{Type: ui.ValuesMultipleBitChoice, Values: umbrella.GetPermissionFlagsMultipleBitChoice()}
+	}
+	if _, ok := p.intFieldValues["Permission_ForType"]; !ok {
+		p.intFieldValues["Permission_ForType"] = ui.IntFieldValues{Type: ui.ValuesSingleChoice, Values: umbrella.GetPermissionForTypeSingleChoice()}
+	}
+	if _, ok := p.intFieldValues["Permission_Ops"]; !ok {
+		p.intFieldValues["Permission_Ops"] = ui.IntFieldValues{Type: ui.ValuesMultipleBitChoice, Values: umbrella.GetPermissionOpsMultipleBitChoice()}
+	}
+	if _, ok := p.stringFieldValues["Permission_ToType"]; !ok {
+		values := map[string]string{}
+		for _, t := range p.getPermissionTypes() {
+			values[t] = t
+		}
+		p.stringFieldValues["Permission_ToType"] = ui.StringFieldValues{Type: ui.ValuesSingleChoice, Values: values}
+	}
+}
+
+// permissionsUsersPerPage is the number of users shown on one page of the permissions page
+const permissionsUsersPerPage = 100
+
+// getPermissionSubjects returns everyone, the groups that already have permissions or the one that is being added,
+// and users on the given page, which are rows of the permissions page. It also returns whether there are more users
+// after the page.
+func (p *Prototype) getPermissionSubjects(ctx context.Context, perms map[string]map[string]*umbrella.Permission, groupID int64, page int) ([]permissionSubject, bool, error) {
+	subjects := []permissionSubject{{ForType: umbrella.ForTypeEveryone, Label: "Everyone"}}
+
+	groups := map[int64]bool{}
+	if groupID > 0 {
+		groups[groupID] = true
+	}
+	for key := range perms {
+		var forType int8
+		var forItem int64
+		fmt.Sscanf(key, "%d_%d", &forType, &forItem)
+		if forType == umbrella.ForTypeGroup && forItem > 0 {
+			groups[forItem] = true
+		}
+	}
+	groupIDs := make([]int64, 0, len(groups))
+	for id := range groups {
+		groupIDs = append(groupIDs, id)
+	}
+	sort.Slice(groupIDs, func(i, j int) bool { return groupIDs[i] < groupIDs[j] })
+	for _, id := range groupIDs {
+		subjects = append(subjects, permissionSubject{ForType: umbrella.ForTypeGroup, ForItem: id, Label: fmt.Sprintf("Group #%d", id)})
+	}
+
+	constructor := func() interface{} { return &umbrella.User{} }
+	if p.umbrellaUserConstructor != nil {
+		constructor = p.umbrellaUserConstructor
+	}
+	rows, err := p.ormWithContext(ctx).Get(constructor, []string{"ID", "asc"}, permissionsUsersPerPage+1, (page-1)*permissionsUsersPerPage, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	more := len(rows) > permissionsUsersPerPage
+	if more {
+		rows = rows[:permissionsUsersPerPage]
+	}
+	for _, row := range rows {
+		v := reflect.ValueOf(row).Elem()
+		id := v.FieldByName("ID")
+		email := v.FieldByName("Email")
+		if !id.IsValid() || id.Kind() != reflect.Int64 {
+			continue
+		}
+		label := fmt.Sprintf("User #%d", id.Int())
+		if email.IsValid() && email.Kind() == reflect.String {
+			label = fmt.Sprintf("%s (#%d)", email.String(), id.Int())
+		}
+		subjects = append(subjects, permissionSubject{ForType: umbrella.ForTypeUser, ForItem: id.Int(), Label: label})
+	}
+	return subjects, more, nil
+}
+
+// getSubjectPermissions returns allow permissions to whole types that are given directly to users, groups or
+// everyone, by subject key and type
+func (p *Prototype) getSubjectPermissions(ctx context.Context) (map[string]map[string]*umbrella.Permission, error) {
+	rows, err := p.ormWithContext(ctx).Get(func() interface{} { return &umbrella.Permission{} }, []string{"ID", "asc"}, 0, 0, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	perms := map[string]map[string]*umbrella.Permission{}
+	for _, row := range rows {
+		perm := row.(*umbrella.Permission)
+		if perm.Flags&umbrella.FlagTypeAllow == 0 || perm.ToItem != 0 {
+			continue
+		}
+		key := permissionSubject{ForType: perm.ForType, ForItem: perm.ForItem}.getKey()
+		if perms[key] == nil {
+			perms[key] = map[string]*umbrella.Permission{}
+		}
+		if _, ok := perms[key][perm.ToType]; !ok {
+			perms[key][perm.ToType] = perm
+		}
+	}
+	return perms, nil
+}
+
+// savePermissionMatrix creates, updates or removes permission of each subject and type according to the submitted
+// form. Only subjects that were submitted are changed.
+func (p *Prototype) savePermissionMatrix(r *http.Request, subjects []permissionSubject, perms map[string]map[string]*umbrella.Permission, types []string) error {
+	err := r.ParseForm()
+	if err != nil {
+		return errors.New("invalid form")
+	}
+
+	validOps := map[int64]bool{}
+	for _, o := range getTemplateOps() {
+		validOps[int64(o.Value)] = true
+	}
+	submitted := map[string]bool{}
+	for _, key := range r.PostForm["subject"] {
+		submitted[key] = true
+	}
+
+	orm := p.ormWithContext(r.Context())
+	for _, s := range subjects {
+		key := s.getKey()
+		if !submitted[key] {
+			continue
+		}
+		for _, t := range types {
+			var ops int64
+			for _, v := range r.PostForm[fmt.Sprintf("ops_%s_%s", key, t)] {
+				o, err := strconv.ParseInt(v, 10, 64)
+				if err != nil || !validOps[o] {
+					return errors.New("invalid operation")
+				}
+				ops |= o
+			}
+
+			perm, ok := perms[key][t]
+			switch {
+			case ok && ops == 0:
+				err = orm.Delete(perm)
+			case ok && perm.Ops != ops:
+				perm.Ops = ops
+				err = orm.Save(perm)
+			case !ok && ops != 0:
+				err = orm.Save(&umbrella.Permission{
+					Flags:   umbrella.FlagTypeAllow,
+					ForType: s.ForType,
+					ForItem: s.ForItem,
+					Ops:     ops,
+					ToType:  t,
+				})
+			}
+			if err != nil {
+				return fmt.Errorf("error saving permission: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// getEffectivePermissions returns names of the operations that user is allowed to perform on each type, taking all
+// the permissions into account
+func (p *Prototype) getEffectivePermissions(userID int64, types []string) (map[string]string, error) {
+	effective := map[string]string{}
+	for _, o := range getTemplateOps() {
+		allowedTypes, err := p.umbrella.GetUserOperationAllowedTypes(userID, o.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range types {
+			if !isTypeAllowed(allowedTypes, t) {
+				continue
+			}
+			if effective[t] != "" {
+				effective[t] += ", "
+			}
+			effective[t] += o.Name
+		}
+	}
+	for _, t := range types {
+		if effective[t] == "" {
+			effective[t] = "-"
+		}
+	}
+	return effective, nil
+}
+
+// getPermissionsHTTPHandler returns handler of a page where permissions of users, groups and everyone can be edited
+// as a matrix of subjects and types, and where effective permissions of a user can be previewed. Only admins can
+// access it.
+func (p *Prototype) getPermissionsHTTPHandler(uri string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lu := getLoggedUserFromContext(r.Context())
+		if lu == nil || !lu.isAdmin() {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("NoAccess"))
+			return
+		}
+
+		groupID, _ := strconv.ParseInt(r.URL.Query().Get("group_id"), 10, 64)
+		effectiveUserID, _ := strconv.ParseInt(r.URL.Query().Get("effective"), 10, 64)
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 1 {
+			page = 1
+		}
+		types := p.getPermissionTypes()
+		data := map[string]interface{}{
+			"URI":      uri,
+			"GroupID":  groupID,
+			"Types":    types,
+			"Page":     page,
+			"PrevPage": page - 1,
+		}
+
+		perms, err := p.getSubjectPermissions(r.Context())
+		if err != nil {
+			p.logger.Error("error getting permissions", slog.Any("error", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		subjects, more, err := p.getPermissionSubjects(r.Context(), perms, groupID, page)
+		if err != nil {
+			p.logger.Error("error getting users", slog.Any("error", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if more {
+			data["NextPage"] = page + 1
+		}
+
+		if r.Method == http.MethodPost {
+			err = p.savePermissionMatrix(r, subjects, perms, types)
+			if err != nil {
+				p.logger.Error("error saving permissions", slog.Any("error", err))
+				data["Error"] = "error saving permissions"
+			} else {
+				data["Saved"] = true
+			}
+			perms, err = p.getSubjectPermissions(r.Context())
+			if err != nil {
+				p.logger.Error("error getting permissions", slog.Any("error", err))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		rows := []permissionsTemplateRow{}
+		for _, s := range subjects {
+			row := permissionsTemplateRow{Key: s.getKey(), Label: s.Label}
+			if s.ForType == umbrella.ForTypeUser {
+				row.UserID = s.ForItem
+			}
+			for _, t := range types {
+				cell := permissionsTemplateCell{Name: fmt.Sprintf("ops_%s_%s", row.Key, t)}
+				for _, o := range getTemplateOps() {
+					checked := false
+					if perm, ok := perms[row.Key][t]; ok {
+						checked = perm.Ops&int64(o.Value) > 0
+					}
+					cell.Ops = append(cell.Ops, permissionsTemplateOp{Name: o.Name, Value: o.Value, Checked: checked})
+				}
+				row.Cells = append(row.Cells, cell)
+			}
+			rows = append(rows, row)
+		}
+		data["Rows"] = rows
+
+		if effectiveUserID > 0 {
+			effective, err := p.getEffectivePermissions(effectiveUserID, types)
+			if err != nil {
+				p.logger.Error("error getting effective permissions", slog.Any("error", err))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			list := []permissionsTemplateEffective{}
+			for _, t := range types {
+				list = append(list, permissionsTemplateEffective{Type: t, Ops: effective[t]})
+			}
+			data["Effective"] = list
+			data["EffectiveUser"] = fmt.Sprintf("user #%d", effectiveUserID)
+			for _, s := range subjects {
+				if s.ForType == umbrella.ForTypeUser && s.ForItem == effectiveUserID {
+					data["EffectiveUser"] = s.Label
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		permissionsTemplate.Execute(w, data)
+	})
+}
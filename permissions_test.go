@@ -0,0 +1,175 @@
+package prototyping
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-phings/umbrella"
+)
+
+type testPermissionItem struct {
+	ID    int64
+	Price int `perm:"read"`
+}
+
+func newTestPermissionsPrototype() *Prototype {
+	p := &Prototype{
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		orm:          &testORM{},
+		constructors: []func() interface{}{func() interface{} { return &testPermissionItem{} }},
+	}
+	p.fieldRules = getFieldRules(p.constructors)
+	return p
+}
+
+func TestGetPermissionTypes(t *testing.T) {
+	p := newTestPermissionsPrototype()
+	types := p.getPermissionTypes()
+	for _, expected := range []string{"all", "testPermissionItem", "testPermissionItem.Price", "User", "Permission", "APIKey"} {
+		found := false
+		for _, typ := range types {
+			found = found || typ == expected
+		}
+		if !found {
+			t.Errorf("expected type %s in %v", expected, types)
+		}
+	}
+}
+
+func TestPermissionsPageIsAdminOnly(t *testing.T) {
+	p := newTestPermissionsPrototype()
+	h := p.getPermissionsHTTPHandler("/ui/r/permissions/")
+
+	for _, lu := range []*loggedUser{nil, newTestLoggedUser("Permission")} {
+		r := httptest.NewRequest(http.MethodGet, "/ui/r/permissions/", nil)
+		r = r.WithContext(context.WithValue(r.Context(), loggedUserContextKey, lu))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected forbidden, got %d", rec.Code)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/ui/r/permissions/?group_id=7", nil)
+	r = r.WithContext(context.WithValue(r.Context(), loggedUserContextKey, newTestLoggedUser("all")))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected page, got %d", rec.Code)
+	}
+	group := permissionSubject{ForType: umbrella.ForTypeGroup, ForItem: 7}.getKey()
+	for _, s := range []string{"<th>testPermissionItem</th>", "Everyone", "Group #7", `name="ops_` + group + `_testPermissionItem"`} {
+		if !strings.Contains(rec.Body.String(), s) {
+			t.Errorf("expected %s in the page", s)
+		}
+	}
+}
+
+func TestSavePermissionMatrix(t *testing.T) {
+	p := newTestPermissionsPrototype()
+	orm := p.orm.(*testORM)
+	subjects := []permissionSubject{
+		{ForType: umbrella.ForTypeEveryone},
+		{ForType: umbrella.ForTypeUser, ForItem: 2},
+		{ForType: umbrella.ForTypeUser, ForItem: 3},
+	}
+	everyone, user2, user3 := subjects[0].getKey(), subjects[1].getKey(), subjects[2].getKey()
+	perms := map[string]map[string]*umbrella.Permission{
+		user2: {"testPermissionItem": {ID: 1, Flags: umbrella.FlagTypeAllow, ForType: umbrella.ForTypeUser, ForItem: 2, Ops: int64(umbrella.OpsRead), ToType: "testPermissionItem"}},
+		user3: {"testPermissionItem": {ID: 2, Flags: umbrella.FlagTypeAllow, ForType: umbrella.ForTypeUser, ForItem: 3, Ops: int64(umbrella.OpsRead), ToType: "testPermissionItem"}},
+	}
+
+	form := url.Values{
+		"subject":                              {everyone, user2},
+		"ops_" + everyone + "_all":             {fmt.Sprint(umbrella.OpsRead), fmt.Sprint(umbrella.OpsList)},
+		"ops_" + user2 + "_testPermissionItem": {fmt.Sprint(umbrella.OpsRead)},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/ui/r/permissions/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	err := p.savePermissionMatrix(r, subjects, perms, []string{"all", "testPermissionItem"})
+	if err != nil {
+		t.Fatalf("error saving permissions: %s", err)
+	}
+	// new permission of everyone, unchanged one of user 2, and user 3 was not submitted
+	if strings.Join(orm.calls, ",") != "Save" {
+		t.Fatalf("unexpected calls: %v", orm.calls)
+	}
+
+	form = url.Values{"subject": {user2}}
+	r = httptest.NewRequest(http.MethodPost, "/ui/r/permissions/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	orm.calls = nil
+	p.savePermissionMatrix(r, subjects, perms, []string{"all", "testPermissionItem"})
+	if strings.Join(orm.calls, ",") != "Delete" {
+		t.Fatalf("expected unchecked permission to be removed, got %v", orm.calls)
+	}
+
+	form = url.Values{"subject": {user2}, "ops_" + user2 + "_all": {"3"}}
+	r = httptest.NewRequest(http.MethodPost, "/ui/r/permissions/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.savePermissionMatrix(r, subjects, perms, []string{"all"}) == nil {
+		t.Fatal("expected error with invalid operation")
+	}
+}
+
+// testPermissionsUsersORM returns the given number of users, and no permissions
+type testPermissionsUsersORM struct {
+	testORM
+	users int
+}
+
+func (o *testPermissionsUsersORM) Get(newObjFunc func() interface{}, order []string, limit int, offset int, filters map[string]interface{}, rowObjTransformFunc func(interface{}) interface{}) ([]interface{}, error) {
+	rows := []interface{}{}
+	if _, ok := newObjFunc().(*umbrella.User); !ok {
+		return rows, nil
+	}
+	for i := offset; i < o.users && i < offset+limit; i++ {
+		rows = append(rows, &umbrella.User{ID: int64(i + 1), Email: fmt.Sprintf("user%d@example.com", i+1)})
+	}
+	return rows, nil
+}
+
+func TestPermissionsPageUsersArePaginated(t *testing.T) {
+	p := newTestPermissionsPrototype()
+	p.orm = &testPermissionsUsersORM{users: permissionsUsersPerPage + 5}
+	h := p.getPermissionsHTTPHandler("/ui/r/permissions/")
+
+	get := func(query string) string {
+		r := httptest.NewRequest(http.MethodGet, "/ui/r/permissions/"+query, nil)
+		r = r.WithContext(context.WithValue(r.Context(), loggedUserContextKey, newTestLoggedUser("all")))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected page, got %d", rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	body := get("?group_id=7")
+	last := fmt.Sprintf("(#%d)", permissionsUsersPerPage)
+	for _, s := range []string{"Everyone", "Group #7", "user1@example.com (#1)", last, `action="/ui/r/permissions/?page=1&group_id=7"`, `href="/ui/r/permissions/?page=2&group_id=7">Next`} {
+		if !strings.Contains(body, s) {
+			t.Errorf("expected %s in the first page", s)
+		}
+	}
+	if strings.Contains(body, fmt.Sprintf("(#%d)", permissionsUsersPerPage+1)) || strings.Contains(body, "Previous") {
+		t.Error("expected first page to have only its users")
+	}
+
+	body = get("?page=2")
+	for _, s := range []string{"Everyone", fmt.Sprintf("(#%d)", permissionsUsersPerPage+5), `action="/ui/r/permissions/?page=2"`, `href="/ui/r/permissions/?page=1">Previous`} {
+		if !strings.Contains(body, s) {
+			t.Errorf("expected %s in the second page", s)
+		}
+	}
+	if strings.Contains(body, last) || strings.Contains(body, "Next") {
+		t.Error("expected second page to be the last one with its users only")
+	}
+}
@@ -0,0 +1,254 @@
+// Package prototypingtest runs a prototype against a throwaway database schema so that apps built with prototyping
+// can be tested end to end with the standard testing package.
+package prototypingtest
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mikolajgs/prototyping"
+
+	_ "github.com/lib/pq"
+)
+
+// DatabaseDSNEnv is the environment variable with the database used when Config.DatabaseDSN is empty
+const DatabaseDSNEnv = "PROTO_TEST_DSN"
+
+// Server is a prototype served by httptest.Server. Its tables are created in a schema that is dropped when the test
+// finishes.
+type Server struct {
+	*httptest.Server
+	Prototype *prototyping.Prototype
+	// Schema is empty when the server uses Config.ORM without a dsn
+	Schema string
+
+	tb      testing.TB
+	clients int
+}
+
+// NewServer creates tables of the prototype in a new schema and starts serving it. The test is skipped when there
+// is no database to connect to. When Config.ORM is set without a dsn, the tables are created with the ORM as it is,
+// without a schema, and the ORM is responsible for isolating the test data.
+func NewServer(tb testing.TB, cfg prototyping.Config, constructors ...func() interface{}) *Server {
+	tb.Helper()
+
+	if cfg.ORM != nil && cfg.DatabaseDSN == "" {
+		return newServer(tb, cfg, "", constructors...)
+	}
+	if cfg.DatabaseDSN == "" {
+		cfg.DatabaseDSN = os.Getenv(DatabaseDSNEnv)
+	}
+	if cfg.DatabaseDSN == "" {
+		tb.Skipf("%s is not set", DatabaseDSNEnv)
+	}
+
+	schema := fmt.Sprintf("proto_test_%s", getRandomHex(tb, 6))
+	db, err := sql.Open("postgres", cfg.DatabaseDSN)
+	if err != nil {
+		tb.Fatalf("error connecting to db: %s", err.Error())
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE SCHEMA %s", schema))
+	if err != nil {
+		db.Close()
+		tb.Fatalf("error creating schema: %s", err.Error())
+	}
+	tb.Cleanup(func() {
+		_, err := db.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", schema))
+		if err != nil {
+			tb.Errorf("error dropping schema: %s", err.Error())
+		}
+		db.Close()
+	})
+
+	prefix := cfg.DatabaseTablePrefix
+	if prefix == "" {
+		prefix = "proto_"
+	}
+	cfg.DatabaseTablePrefix = schema + "." + prefix
+	return newServer(tb, cfg, schema, constructors...)
+}
+
+func newServer(tb testing.TB, cfg prototyping.Config, schema string, constructors ...func() interface{}) *Server {
+	tb.Helper()
+
+	p, err := prototyping.NewPrototype(cfg, constructors...)
+	if err != nil {
+		tb.Fatalf("error creating new prototype: %s", err.Error())
+	}
+	err = p.CreateDB()
+	if err != nil {
+		tb.Fatalf("error creating database: %s", err.Error())
+	}
+	h, err := p.Handler()
+	if err != nil {
+		tb.Fatalf("error getting handler: %s", err.Error())
+	}
+
+	s := &Server{
+		Server:    httptest.NewServer(h),
+		Prototype: p,
+		Schema:    schema,
+		tb:        tb,
+	}
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Seed seeds fixtures and fails the test on error
+func (s *Server) Seed(funcs ...prototyping.SeedFunc) {
+	s.tb.Helper()
+	err := s.Prototype.Seed(funcs...)
+	if err != nil {
+		s.tb.Fatalf("error seeding database: %s", err.Error())
+	}
+}
+
+// Client returns a client that is not logged in
+func (s *Server) Client() *Client {
+	s.tb.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		s.tb.Fatalf("error creating cookie jar: %s", err.Error())
+	}
+	httpClient := s.Server.Client()
+	httpClient.Jar = jar
+	// redirects are returned so that they can be asserted
+	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &Client{HTTPClient: httpClient, server: s, tb: s.tb}
+}
+
+// UserClient creates a user with permissions to struct names, eg. {"Item": umbrella.OpsRead | umbrella.OpsList},
+// and returns a client logged in as the user both to the API and the UI
+func (s *Server) UserClient(permissions map[string]int64) *Client {
+	s.tb.Helper()
+	s.clients++
+	email := fmt.Sprintf("user%d@example.com", s.clients)
+	password := getRandomHex(s.tb, 12)
+
+	id, err := s.Prototype.CreateUser(email, password, "", permissions)
+	if err != nil {
+		s.tb.Fatalf("error creating user: %s", err.Error())
+	}
+
+	c := s.Client()
+	c.UserID = id
+	c.Login(email, password)
+	return c
+}
+
+// APIKeyClient creates a service account API key for ops on types and returns a client that sends it
+func (s *Server) APIKeyClient(ops int64, types ...string) *Client {
+	s.tb.Helper()
+	s.clients++
+	key, err := s.Prototype.CreateAPIKey(fmt.Sprintf("test%d", s.clients), 0, ops, types, 0)
+	if err != nil {
+		s.tb.Fatalf("error creating api key: %s", err.Error())
+	}
+
+	c := s.Client()
+	c.apiKey = key
+	return c
+}
+
+// Client sends requests to the server and fails the test on transport errors
+type Client struct {
+	HTTPClient *http.Client
+	UserID     int64
+
+	server *Server
+	tb     testing.TB
+	token  string
+	apiKey string
+}
+
+// Login gets a token for email and password. The token is sent in Authorization header to the API and as a cookie
+// to the UI.
+func (c *Client) Login(email string, password string) {
+	c.tb.Helper()
+	form := url.Values{}
+	form.Set("email", email)
+	form.Set("password", password)
+	res := c.PostForm("/umbrella/login", form).AssertOK()
+
+	data := struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}{}
+	res.DecodeJSON(&data)
+	if data.Data.Token == "" {
+		c.tb.Fatalf("login response has no token: %s", res.Body)
+	}
+	c.token = data.Data.Token
+
+	u, _ := url.Parse(c.server.URL + "/ui/")
+	c.HTTPClient.Jar.SetCookies(u, []*http.Cookie{{Name: "UmbrellaToken", Value: c.token, Path: "/ui/"}})
+}
+
+// Do sends a request with body, which is encoded to JSON unless it is nil, a string or url.Values
+func (c *Client) Do(method string, path string, body interface{}) *Response {
+	c.tb.Helper()
+	b, contentType := getRequestBody(c.tb, body)
+	req, err := http.NewRequest(method, c.server.URL+path, b)
+	if err != nil {
+		c.tb.Fatalf("error creating request: %s", err.Error())
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	} else if c.token != "" && !strings.HasPrefix(path, "/ui/") {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		c.tb.Fatalf("error sending %s %s: %s", method, path, err.Error())
+	}
+	return newResponse(c.tb, method, path, res)
+}
+
+// Get sends a GET request
+func (c *Client) Get(path string) *Response {
+	c.tb.Helper()
+	return c.Do(http.MethodGet, path, nil)
+}
+
+// Put sends a PUT request with body encoded to JSON
+func (c *Client) Put(path string, body interface{}) *Response {
+	c.tb.Helper()
+	return c.Do(http.MethodPut, path, body)
+}
+
+// Delete sends a DELETE request
+func (c *Client) Delete(path string) *Response {
+	c.tb.Helper()
+	return c.Do(http.MethodDelete, path, nil)
+}
+
+// PostForm sends a POST request with form values
+func (c *Client) PostForm(path string, form url.Values) *Response {
+	c.tb.Helper()
+	return c.Do(http.MethodPost, path, form)
+}
+
+func getRandomHex(tb testing.TB, n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		tb.Fatalf("error generating random string: %s", err.Error())
+	}
+	return hex.EncodeToString(b)
+}
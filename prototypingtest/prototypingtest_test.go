@@ -0,0 +1,87 @@
+package prototypingtest
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/go-phings/umbrella"
+	"github.com/mikolajgs/prototyping"
+)
+
+type Item struct {
+	ID    int64  `json:"item_id"`
+	Flags int64  `json:"item_flags"`
+	Name  string `json:"name" ui:"req lenmin:1 lenmax:50"`
+	Price int    `json:"price" perm:"read write"`
+}
+
+func newItem() interface{} { return &Item{} }
+
+func TestNewServerSkipsWithoutDatabase(t *testing.T) {
+	if os.Getenv(DatabaseDSNEnv) != "" {
+		t.Skipf("%s is set", DatabaseDSNEnv)
+	}
+	skipped := false
+	t.Run("server", func(t *testing.T) {
+		defer func() {
+			skipped = t.Skipped()
+		}()
+		NewServer(t, prototyping.Config{}, newItem)
+	})
+	if !skipped {
+		t.Fatal("expected test to be skipped")
+	}
+}
+
+func TestServerAPI(t *testing.T) {
+	s := NewServer(t, prototyping.Config{}, newItem)
+	s.Client().Get("/healthz").AssertStatus(http.StatusOK)
+
+	c := s.UserClient(map[string]int64{"Item": umbrella.OpsCreate | umbrella.OpsRead | umbrella.OpsUpdate | umbrella.OpsList})
+	id := c.Put("/api/Item/", map[string]interface{}{"name": "item"}).AssertOK().ID()
+	c.Get(fmt.Sprintf("/api/Item/%d", id)).AssertOK().AssertJSON("data.item.name", "item")
+	c.Put(fmt.Sprintf("/api/Item/%d", id), map[string]interface{}{"name": "renamed"}).AssertOK()
+	c.Get("/api/Item/?filter_name=renamed").AssertOK().AssertJSON("data.items.0.name", "renamed")
+
+	// price needs a permission to Item.Price type
+	c.Put("/api/Item/", map[string]interface{}{"name": "item", "price": 5}).AssertError(http.StatusForbidden, "FieldNotAllowed")
+	c.Put("/api/Item/", map[string]interface{}{"name": "item", "Price": 5}).AssertError(http.StatusForbidden, "FieldNotAllowed")
+	c.Get("/api/Item/?filter_price=5").AssertError(http.StatusForbidden, "FieldNotAllowed")
+	c.Get("/api/Item/?order=price").AssertError(http.StatusForbidden, "FieldNotAllowed")
+	c.Get(fmt.Sprintf("/api/Item/%d", id)).AssertNotContains(`"price"`)
+
+	admin := s.UserClient(map[string]int64{"Item": umbrella.OpsCreate | umbrella.OpsRead | umbrella.OpsList, "Item.Price": umbrella.OpsCreate | umbrella.OpsRead})
+	id = admin.Put("/api/Item/", map[string]interface{}{"name": "priced", "price": 5}).AssertOK().ID()
+	admin.Get(fmt.Sprintf("/api/Item/%d", id)).AssertOK().AssertJSON("data.item.price", 5)
+
+	// delete was not granted
+	if res := c.Delete(fmt.Sprintf("/api/Item/%d", id)); res.StatusCode == http.StatusOK {
+		t.Fatalf("expected delete to be denied, got %d", res.StatusCode)
+	}
+}
+
+func TestServerAPIKey(t *testing.T) {
+	s := NewServer(t, prototyping.Config{}, newItem)
+	s.Seed(func() ([]prototyping.Fixture, error) {
+		return []prototyping.Fixture{{Object: &Item{Name: "seeded"}}}, nil
+	})
+
+	c := s.APIKeyClient(umbrella.OpsRead|umbrella.OpsList, "Item")
+	c.Get("/api/Item/?filter_name=seeded").AssertOK().AssertJSON("data.items.0.name", "seeded")
+	if res := c.Put("/api/Item/", map[string]interface{}{"name": "item"}); res.StatusCode == http.StatusOK || res.StatusCode == http.StatusCreated {
+		t.Fatalf("expected create to be denied, got %d", res.StatusCode)
+	}
+}
+
+func TestServerUI(t *testing.T) {
+	s := NewServer(t, prototyping.Config{}, newItem)
+
+	s.Client().Get("/ui/").AssertRedirect("/ui/login/")
+	s.Client().Get("/ui/login/").AssertOK()
+
+	c := s.UserClient(map[string]int64{"Item": umbrella.OpsRead | umbrella.OpsList})
+	c.Get("/ui/").AssertOK().AssertContains("Item")
+	c.Get("/ui/r/permissions/").AssertStatus(http.StatusForbidden)
+}
@@ -0,0 +1,196 @@
+package prototypingtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Response is a read response with assertion helpers. Failed assertions stop the test.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	tb      testing.TB
+	request string
+}
+
+func newResponse(tb testing.TB, method string, path string, res *http.Response) *Response {
+	tb.Helper()
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		tb.Fatalf("error reading response of %s %s: %s", method, path, err.Error())
+	}
+	return &Response{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       b,
+		tb:         tb,
+		request:    fmt.Sprintf("%s %s", method, path),
+	}
+}
+
+func getRequestBody(tb testing.TB, body interface{}) (io.Reader, string) {
+	switch v := body.(type) {
+	case nil:
+		return nil, ""
+	case string:
+		return strings.NewReader(v), ""
+	case url.Values:
+		return strings.NewReader(v.Encode()), "application/x-www-form-urlencoded"
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		tb.Fatalf("error encoding request body: %s", err.Error())
+	}
+	return bytes.NewReader(b), "application/json"
+}
+
+// AssertStatus checks the status code
+func (r *Response) AssertStatus(code int) *Response {
+	r.tb.Helper()
+	if r.StatusCode != code {
+		r.tb.Fatalf("%s: got status %d, want %d, body: %s", r.request, r.StatusCode, code, r.Body)
+	}
+	return r
+}
+
+// AssertOK checks that the API responded with a success
+func (r *Response) AssertOK() *Response {
+	r.tb.Helper()
+	if r.StatusCode != http.StatusOK && r.StatusCode != http.StatusCreated {
+		r.tb.Fatalf("%s: got status %d, want success, body: %s", r.request, r.StatusCode, r.Body)
+	}
+	if ok, found := r.getJSONValue("ok"); found && fmt.Sprint(ok) != "1" && ok != true {
+		r.tb.Fatalf("%s: got ok %v, want 1, body: %s", r.request, ok, r.Body)
+	}
+	return r
+}
+
+// AssertError checks the status code and the error code, which is err_text of a JSON response or the plain text
+// body, eg. FieldNotAllowed. Empty errText is not checked.
+func (r *Response) AssertError(code int, errText string) *Response {
+	r.tb.Helper()
+	r.AssertStatus(code)
+	if errText == "" {
+		return r
+	}
+	got := strings.TrimSpace(string(r.Body))
+	if v, found := r.getJSONValue("err_text"); found {
+		got = fmt.Sprint(v)
+	}
+	if got != errText {
+		r.tb.Fatalf("%s: got error %q, want %q", r.request, got, errText)
+	}
+	return r
+}
+
+// AssertJSON checks a value in a JSON response at a dot separated path, eg. "data.item.name" or "data.items.0.id".
+// Values are compared after encoding want to JSON, so numbers of any type can be used.
+func (r *Response) AssertJSON(path string, want interface{}) *Response {
+	r.tb.Helper()
+	got, found := r.getJSONValue(path)
+	if !found {
+		r.tb.Fatalf("%s: %s not found in body: %s", r.request, path, r.Body)
+	}
+	b, err := json.Marshal(want)
+	if err != nil {
+		r.tb.Fatalf("error encoding %v: %s", want, err.Error())
+	}
+	var w interface{}
+	_ = json.Unmarshal(b, &w)
+	if !reflect.DeepEqual(got, w) {
+		r.tb.Fatalf("%s: got %s %v, want %v", r.request, path, got, want)
+	}
+	return r
+}
+
+// AssertContains checks that the body, eg. a UI page, contains all the strings
+func (r *Response) AssertContains(values ...string) *Response {
+	r.tb.Helper()
+	for _, v := range values {
+		if !bytes.Contains(r.Body, []byte(v)) {
+			r.tb.Fatalf("%s: body does not contain %q", r.request, v)
+		}
+	}
+	return r
+}
+
+// AssertNotContains checks that the body contains none of the strings
+func (r *Response) AssertNotContains(values ...string) *Response {
+	r.tb.Helper()
+	for _, v := range values {
+		if bytes.Contains(r.Body, []byte(v)) {
+			r.tb.Fatalf("%s: body contains %q", r.request, v)
+		}
+	}
+	return r
+}
+
+// AssertRedirect checks that the response redirects to location
+func (r *Response) AssertRedirect(location string) *Response {
+	r.tb.Helper()
+	if r.StatusCode < 300 || r.StatusCode > 399 {
+		r.tb.Fatalf("%s: got status %d, want redirect", r.request, r.StatusCode)
+	}
+	if got := r.Header.Get("Location"); got != location {
+		r.tb.Fatalf("%s: got redirect to %q, want %q", r.request, got, location)
+	}
+	return r
+}
+
+// DecodeJSON decodes the body to v
+func (r *Response) DecodeJSON(v interface{}) *Response {
+	r.tb.Helper()
+	err := json.Unmarshal(r.Body, v)
+	if err != nil {
+		r.tb.Fatalf("%s: error decoding body: %s, body: %s", r.request, err.Error(), r.Body)
+	}
+	return r
+}
+
+// ID returns data.id of a response to creating an object
+func (r *Response) ID() int64 {
+	r.tb.Helper()
+	v, found := r.getJSONValue("data.id")
+	id, ok := v.(float64)
+	if !found || !ok {
+		r.tb.Fatalf("%s: data.id not found in body: %s", r.request, r.Body)
+	}
+	return int64(id)
+}
+
+func (r *Response) getJSONValue(path string) (interface{}, bool) {
+	var v interface{}
+	if json.Unmarshal(r.Body, &v) != nil {
+		return nil, false
+	}
+	for _, k := range strings.Split(path, ".") {
+		switch o := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			v, ok = o[k]
+			if !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(o) {
+				return nil, false
+			}
+			v = o[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,347 @@
+package prototyping
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig contains token bucket limits and login lockout settings. Rate is a number of requests per second
+// and zero rate disables a specific limit.
+type RateLimitConfig struct {
+	IPRate      float64
+	IPBurst     int
+	UserRate    float64
+	UserBurst   int
+	APIKeyRate  float64
+	APIKeyBurst int
+	// LoginMaxFailures is the number of failed logins after which the account is locked, defaults to 5
+	LoginMaxFailures int
+	// LoginLockout is the lockout after reaching LoginMaxFailures. It doubles with each next failure up to
+	// LoginMaxLockout. Defaults to 1 minute and 1 hour.
+	LoginLockout    time.Duration
+	LoginMaxLockout time.Duration
+	// TrustedProxyHops is the number of reverse proxies in front of the app that append to the X-Forwarded-For
+	// header. Client IP is taken from the entry that many hops from the right, eg. 1 means the right-most one.
+	// Header is ignored when 0.
+	TrustedProxyHops int
+	// Store keeps the state, defaults to an in-memory store
+	Store RateLimitStore
+}
+
+type rateLimiter struct {
+	cfg   *RateLimitConfig
+	store RateLimitStore
+}
+
+// newRateLimiter creates rate limiter with defaults set on a copy of the config, which is left unchanged
+func newRateLimiter(cfg *RateLimitConfig) *rateLimiter {
+	c := *cfg
+	l := &rateLimiter{
+		cfg:   &c,
+		store: c.Store,
+	}
+	if l.store == nil {
+		l.store = NewMemoryRateLimitStore()
+	}
+	if l.cfg.LoginMaxFailures == 0 {
+		l.cfg.LoginMaxFailures = 5
+	}
+	if l.cfg.LoginLockout == 0 {
+		l.cfg.LoginLockout = time.Minute
+	}
+	if l.cfg.LoginMaxLockout == 0 {
+		l.cfg.LoginMaxLockout = time.Hour
+	}
+	return l
+}
+
+// getClientIP returns address of the client. Entries of X-Forwarded-For left to the ones added by the trusted
+// proxies are set by the client, so they are not used.
+func (l *rateLimiter) getClientIP(r *http.Request) string {
+	if l.cfg.TrustedProxyHops > 0 {
+		ips := []string{}
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			for _, ip := range strings.Split(v, ",") {
+				ips = append(ips, strings.TrimSpace(ip))
+			}
+		}
+		if len(ips) >= l.cfg.TrustedProxyHops {
+			ip := ips[len(ips)-l.cfg.TrustedProxyHops]
+			if net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allow takes a token from a bucket and writes 429 response when there are none left
+func (l *rateLimiter) allow(w http.ResponseWriter, key string, rate float64, burst int, logger *slog.Logger) bool {
+	if rate <= 0 {
+		return true
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	ok, retryAfter, err := l.store.Take(key, rate, burst)
+	if err != nil {
+		// limits should not make the app unavailable when the store fails
+		logger.Error("error with rate limit store", slog.Any("error", err))
+		return true
+	}
+	if !ok {
+		writeTooManyRequests(w, retryAfter)
+		return false
+	}
+	return true
+}
+
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte("TooManyRequests"))
+}
+
+// wrapHandlerWithIPRateLimit limits requests per client IP
+func (p *Prototype) wrapHandlerWithIPRateLimit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.rateLimiter.allow(w, fmt.Sprintf("ip:%s", p.rateLimiter.getClientIP(r)), p.rateLimiter.cfg.IPRate, p.rateLimiter.cfg.IPBurst, p.logger) {
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// limitByIP wraps handler with per IP rate limit when rate limiting is enabled
+func (p *Prototype) limitByIP(h http.Handler) http.Handler {
+	if p.rateLimiter == nil {
+		return h
+	}
+	return p.wrapHandlerWithIPRateLimit(h)
+}
+
+// loginResult is the outcome of a login request
+type loginResult int
+
+const (
+	loginFailed loginResult = iota
+	loginSucceeded
+	// loginPending is a correct password of a user that still has to enter the second factor
+	loginPending
+)
+
+// wrapLoginHandlerWithLockout rejects logins to an account that failed too many times, with lockout growing
+// exponentially. getResult tells whether the login succeeded based on the response. Pending logins are counted
+// when the second factor is verified.
+func (p *Prototype) wrapLoginHandlerWithLockout(isLogin func(*http.Request) bool, getResult func(*responseRecorder) loginResult, h http.Handler) http.Handler {
+	if p.rateLimiter == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLogin(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		form, err := getLoginForm(w, r)
+		if err != nil {
+			writeLoginBodyError(w)
+			return
+		}
+		email := form.Email
+		if strings.TrimSpace(email) == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if lockout := p.getLoginLockout(email); lockout > 0 {
+			writeTooManyRequests(w, lockout)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+
+		switch getResult(rec) {
+		case loginSucceeded:
+			p.registerLoginResult(email, true)
+		case loginFailed:
+			p.registerLoginResult(email, false)
+		}
+	})
+}
+
+func getLoginLockoutKey(email string) string {
+	return fmt.Sprintf("login:%s", strings.ToLower(strings.TrimSpace(email)))
+}
+
+// getLoginLockout returns how long logins to an account are locked, it is always 0 when rate limiting is disabled
+func (p *Prototype) getLoginLockout(email string) time.Duration {
+	if p.rateLimiter == nil {
+		return 0
+	}
+	lockout, err := p.rateLimiter.store.GetLockout(getLoginLockoutKey(email))
+	if err != nil {
+		p.logger.Error("error with rate limit store", slog.Any("error", err))
+	}
+	return lockout
+}
+
+// registerLoginResult resets failures of an account after successful login, or counts a failure
+func (p *Prototype) registerLoginResult(email string, ok bool) {
+	if p.rateLimiter == nil {
+		return
+	}
+	var err error
+	if ok {
+		err = p.rateLimiter.store.ResetFailures(getLoginLockoutKey(email))
+	} else {
+		err = p.registerLoginFailure(getLoginLockoutKey(email))
+	}
+	if err != nil {
+		p.logger.Error("error with rate limit store", slog.Any("error", err))
+	}
+}
+
+func (p *Prototype) registerLoginFailure(key string) error {
+	failures, err := p.rateLimiter.store.RegisterFailure(key)
+	if err != nil {
+		return err
+	}
+	if failures < p.rateLimiter.cfg.LoginMaxFailures {
+		return nil
+	}
+	lockout := p.rateLimiter.cfg.LoginLockout
+	for i := p.rateLimiter.cfg.LoginMaxFailures; i < failures && lockout < p.rateLimiter.cfg.LoginMaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > p.rateLimiter.cfg.LoginMaxLockout {
+		lockout = p.rateLimiter.cfg.LoginMaxLockout
+	}
+	p.logger.Warn("login locked", slog.String("key", key), slog.Int("failures", failures), slog.Duration("lockout", lockout))
+	return p.rateLimiter.store.SetLockout(key, lockout)
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type memoryFailures struct {
+	count       int
+	lockedUntil time.Time
+	updatedAt   time.Time
+}
+
+// MemoryRateLimitStore is a RateLimitStore that keeps the state in memory of a single instance
+type MemoryRateLimitStore struct {
+	mu          sync.Mutex
+	buckets     map[string]*memoryBucket
+	failures    map[string]*memoryFailures
+	lastCleanup time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:     map[string]*memoryBucket{},
+		failures:    map[string]*memoryFailures{},
+		lastCleanup: time.Now(),
+	}
+}
+
+// cleanup removes state that has not been used for a while so that the maps do not grow forever
+func (s *MemoryRateLimitStore) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanup) < time.Minute {
+		return
+	}
+	s.lastCleanup = now
+	for k, b := range s.buckets {
+		if now.Sub(b.updatedAt) > time.Hour {
+			delete(s.buckets, k)
+		}
+	}
+	for k, f := range s.failures {
+		if now.After(f.lockedUntil) && now.Sub(f.updatedAt) > 24*time.Hour {
+			delete(s.failures, k)
+		}
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, rate float64, burst int) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.cleanup(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(burst), updatedAt: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.updatedAt).Seconds()*rate)
+	b.updatedAt = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+func (s *MemoryRateLimitStore) RegisterFailure(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.failures[key]
+	if !ok {
+		f = &memoryFailures{}
+		s.failures[key] = f
+	}
+	f.count++
+	f.updatedAt = time.Now()
+	return f.count, nil
+}
+
+func (s *MemoryRateLimitStore) ResetFailures(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, key)
+	return nil
+}
+
+func (s *MemoryRateLimitStore) SetLockout(key string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.failures[key]
+	if !ok {
+		f = &memoryFailures{}
+		s.failures[key] = f
+	}
+	f.lockedUntil = time.Now().Add(d)
+	f.updatedAt = time.Now()
+	return nil
+}
+
+func (s *MemoryRateLimitStore) GetLockout(key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.failures[key]
+	if !ok {
+		return 0, nil
+	}
+	d := time.Until(f.lockedUntil)
+	if d < 0 {
+		return 0, nil
+	}
+	return d, nil
+}
@@ -0,0 +1,139 @@
+package prototyping
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStoreTake(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	for i := 0; i < 3; i++ {
+		ok, _, err := s.Take("ip:1.2.3.4", 1, 3)
+		if !ok || err != nil {
+			t.Fatalf("expected token %d to be taken, got %v %v", i, ok, err)
+		}
+	}
+	ok, retryAfter, _ := s.Take("ip:1.2.3.4", 1, 3)
+	if ok || retryAfter <= 0 || retryAfter > time.Second {
+		t.Fatalf("expected bucket to be empty, got %v %s", ok, retryAfter)
+	}
+	if ok, _, _ := s.Take("ip:5.6.7.8", 1, 3); !ok {
+		t.Fatal("expected buckets to be separate")
+	}
+
+	// bucket refills with the rate
+	s.buckets["ip:1.2.3.4"].updatedAt = time.Now().Add(-2 * time.Second)
+	if ok, _, _ := s.Take("ip:1.2.3.4", 1, 3); !ok {
+		t.Fatal("expected bucket to be refilled")
+	}
+
+	// unused buckets are removed
+	s.buckets["ip:5.6.7.8"].updatedAt = time.Now().Add(-2 * time.Hour)
+	s.lastCleanup = time.Now().Add(-2 * time.Minute)
+	s.Take("ip:1.2.3.4", 1, 3)
+	if _, ok := s.buckets["ip:5.6.7.8"]; ok {
+		t.Fatal("expected unused bucket to be removed")
+	}
+}
+
+func TestMemoryRateLimitStoreLockout(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	for i := 1; i <= 2; i++ {
+		if n, _ := s.RegisterFailure("login:a"); n != i {
+			t.Fatalf("expected %d failures, got %d", i, n)
+		}
+	}
+	if d, _ := s.GetLockout("login:a"); d != 0 {
+		t.Fatalf("expected no lockout, got %s", d)
+	}
+	s.SetLockout("login:a", time.Minute)
+	if d, _ := s.GetLockout("login:a"); d <= 0 || d > time.Minute {
+		t.Fatalf("expected lockout, got %s", d)
+	}
+	s.ResetFailures("login:a")
+	if d, _ := s.GetLockout("login:a"); d != 0 {
+		t.Fatalf("expected lockout to be reset, got %s", d)
+	}
+	if n, _ := s.RegisterFailure("login:a"); n != 1 {
+		t.Fatalf("expected failures to be reset, got %d", n)
+	}
+}
+
+func TestNewRateLimiterDefaults(t *testing.T) {
+	cfg := &RateLimitConfig{IPRate: 1}
+	l := newRateLimiter(cfg)
+	if l.cfg.LoginMaxFailures != 5 || l.cfg.LoginLockout != time.Minute || l.cfg.LoginMaxLockout != time.Hour || l.store == nil {
+		t.Fatalf("expected defaults to be set, got %+v", l.cfg)
+	}
+	if *cfg != (RateLimitConfig{IPRate: 1}) {
+		t.Fatalf("expected config passed to be unchanged, got %+v", cfg)
+	}
+}
+
+func TestLoginLockoutGrows(t *testing.T) {
+	p := &Prototype{
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		rateLimiter: newRateLimiter(&RateLimitConfig{LoginMaxFailures: 2, LoginLockout: time.Minute, LoginMaxLockout: 3 * time.Minute}),
+	}
+	expected := []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute}
+	for i, e := range expected {
+		p.registerLoginResult("User@Example.com ", false)
+		d := p.getLoginLockout("user@example.com")
+		if d > e || d < e-time.Second {
+			t.Fatalf("expected lockout %s after %d failures, got %s", e, i+1, d)
+		}
+	}
+	p.registerLoginResult("user@example.com", true)
+	if d := p.getLoginLockout("user@example.com"); d != 0 {
+		t.Fatalf("expected lockout to be reset, got %s", d)
+	}
+}
+
+func TestGetClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		hops    int
+		headers []string
+		ip      string
+	}{
+		{name: "no proxy", headers: []string{"1.1.1.1"}, ip: "10.0.0.1"},
+		{name: "one proxy", hops: 1, headers: []string{"1.1.1.1, 2.2.2.2"}, ip: "2.2.2.2"},
+		{name: "two proxies", hops: 2, headers: []string{"1.1.1.1, 2.2.2.2", "3.3.3.3"}, ip: "2.2.2.2"},
+		{name: "too few entries", hops: 2, headers: []string{"2.2.2.2"}, ip: "10.0.0.1"},
+		{name: "no header", hops: 1, ip: "10.0.0.1"},
+		{name: "invalid entry", hops: 1, headers: []string{"1.1.1.1, unknown"}, ip: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(&RateLimitConfig{TrustedProxyHops: tt.hops})
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "10.0.0.1:4321"
+			for _, h := range tt.headers {
+				r.Header.Add("X-Forwarded-For", h)
+			}
+			if ip := l.getClientIP(r); ip != tt.ip {
+				t.Fatalf("expected %s, got %s", tt.ip, ip)
+			}
+		})
+	}
+}
+
+func TestIPRateLimit(t *testing.T) {
+	p := &Prototype{
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		rateLimiter: newRateLimiter(&RateLimitConfig{IPRate: 1, IPBurst: 1}),
+	}
+	h := p.limitByIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i, code := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != code {
+			t.Fatalf("expected %d for request %d, got %d", code, i+1, rec.Code)
+		}
+	}
+}
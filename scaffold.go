@@ -0,0 +1,423 @@
+package prototyping
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+)
+
+// ScaffoldConfig describes an existing database that Scaffold generates structs for
+type ScaffoldConfig struct {
+	// DatabaseDSN is the address of the database
+	DatabaseDSN string
+	// Schema containing the tables, defaults to public
+	Schema string
+	// TablePrefix selects the tables and is removed from the struct names, all the tables in the schema are used when
+	// it is empty. The generated main.go sets Config.DatabaseTablePrefix to the schema and the prefix, eg. "public.".
+	TablePrefix string
+	// Dir is a directory where models.go and main.go are written
+	Dir string
+}
+
+type scaffoldColumn struct {
+	name       string
+	dataType   string
+	maxLength  int
+	nullable   bool
+	hasDefault bool
+	unique     bool
+}
+
+type scaffoldField struct {
+	Name string
+	Type string
+	Tag  string
+}
+
+type scaffoldStruct struct {
+	Name    string
+	Table   string
+	Fields  []scaffoldField
+	Skipped []string
+}
+
+// getUnderscoredName converts a struct or a field name to a table or a column name the same way struct-sql-postgres
+// does
+func getUnderscoredName(s string) string {
+	o := ""
+	var prev rune
+	for i, ch := range s {
+		switch {
+		case i == 0:
+			o += strings.ToLower(string(ch))
+		case unicode.IsUpper(ch) && !(prev == 'I' && ch == 'D'):
+			o += "_" + strings.ToLower(string(ch))
+		default:
+			o += strings.ToLower(string(ch))
+		}
+		prev = ch
+	}
+	return o
+}
+
+// getPluralName returns a table name the same way struct-sql-postgres does
+func getPluralName(s string) string {
+	switch {
+	case strings.HasSuffix(s, "y"):
+		return strings.TrimSuffix(s, "y") + "ies"
+	case strings.HasSuffix(s, "s"):
+		return s + "es"
+	}
+	return s + "s"
+}
+
+// getSingularName returns a name that getPluralName turns into the table name, or empty string if there is none
+func getSingularName(table string) string {
+	candidates := []string{}
+	switch {
+	case strings.HasSuffix(table, "ies"):
+		candidates = append(candidates, strings.TrimSuffix(table, "ies")+"y")
+	case strings.HasSuffix(table, "sses"), strings.HasSuffix(table, "uses") && !strings.HasSuffix(table, "ouses"):
+		candidates = append(candidates, strings.TrimSuffix(table, "es"))
+	}
+	candidates = append(candidates, strings.TrimSuffix(table, "s"))
+	for _, c := range candidates {
+		if c != table && getPluralName(c) == table {
+			return c
+		}
+	}
+	return ""
+}
+
+// getScaffoldName converts a table or a column name to a struct or a field name. Empty string is returned when
+// struct-sql-postgres would not convert the name back to the same column.
+func getScaffoldName(col string) string {
+	parts := strings.Split(col, "_")
+	for i, part := range parts {
+		if part == "" {
+			return ""
+		}
+		if part == "id" {
+			parts[i] = "ID"
+			continue
+		}
+		parts[i] = strings.ToUpper(part[:1]) + part[1:]
+	}
+	name := strings.Join(parts, "")
+	if !token.IsIdentifier(name) || getUnderscoredName(name) != col {
+		return ""
+	}
+	return name
+}
+
+// getScaffoldType returns field type and ui tag options of a column, and false when the column type is not supported
+func getScaffoldType(c scaffoldColumn) (string, []string, bool) {
+	switch c.dataType {
+	case "bigint":
+		return "int64", nil, true
+	case "integer":
+		return "int32", nil, true
+	case "smallint":
+		return "int16", nil, true
+	case "boolean":
+		return "bool", nil, true
+	case "real":
+		return "float32", nil, true
+	case "double precision", "numeric":
+		return "float64", nil, true
+	case "text":
+		return "string", []string{"db_type:TEXT"}, true
+	case "character varying", "character":
+		if c.maxLength == 0 {
+			return "string", []string{"db_type:TEXT"}, true
+		}
+		opts := []string{fmt.Sprintf("lenmax:%d", c.maxLength)}
+		switch {
+		case c.dataType == "character":
+			opts = append(opts, fmt.Sprintf("db_type:CHAR(%d)", c.maxLength))
+		case c.maxLength != 255:
+			opts = append(opts, fmt.Sprintf("db_type:VARCHAR(%d)", c.maxLength))
+		}
+		return "string", opts, true
+	}
+	return "", nil, false
+}
+
+// getScaffoldStruct converts table columns to struct fields. Struct is nil when the table cannot be used with
+// struct-db-postgres, and the reason is returned instead.
+func getScaffoldStruct(table string, name string, columns []scaffoldColumn) (*scaffoldStruct, string) {
+	singular := getSingularName(name)
+	structName := getScaffoldName(singular)
+	if singular == "" || structName == "" {
+		return nil, "name cannot be converted to a struct name"
+	}
+
+	s := &scaffoldStruct{Name: structName, Table: table}
+	hasID := false
+	for _, c := range columns {
+		fieldName := getScaffoldName(c.name)
+		switch c.name {
+		case singular + "_id":
+			hasID = true
+			s.Fields = append([]scaffoldField{{Name: "ID", Type: "int64", Tag: fmt.Sprintf("`json:\"%s\"`", c.name)}}, s.Fields...)
+			continue
+		case singular + "_flags":
+			fieldName = "Flags"
+		}
+		if fieldName == "" || fieldName == "ID" || (fieldName == "Flags" && c.name != singular+"_flags") {
+			s.Skipped = append(s.Skipped, fmt.Sprintf("%s: name cannot be converted to a field name", c.name))
+			continue
+		}
+		if c.nullable {
+			s.Skipped = append(s.Skipped, fmt.Sprintf("%s: nullable columns are not supported", c.name))
+			continue
+		}
+		typ, opts, ok := getScaffoldType(c)
+		if !ok {
+			s.Skipped = append(s.Skipped, fmt.Sprintf("%s: type %s is not supported", c.name, c.dataType))
+			continue
+		}
+		if fieldName == "Flags" && typ != "int64" {
+			s.Skipped = append(s.Skipped, fmt.Sprintf("%s: flags must be bigint", c.name))
+			continue
+		}
+
+		if c.unique {
+			opts = append([]string{"uniq"}, opts...)
+		}
+		if !c.hasDefault {
+			opts = append([]string{"req"}, opts...)
+		}
+		tag := fmt.Sprintf("json:\"%s\"", c.name)
+		if len(opts) > 0 {
+			tag += fmt.Sprintf(" ui:\"%s\"", strings.Join(opts, " "))
+		}
+		s.Fields = append(s.Fields, scaffoldField{Name: fieldName, Type: typ, Tag: "`" + tag + "`"})
+	}
+	if !hasID {
+		return nil, fmt.Sprintf("primary key column %s_id is missing", singular)
+	}
+	return s, ""
+}
+
+// getScaffoldColumns returns columns of the tables in schema which names start with prefix
+func getScaffoldColumns(db *sql.DB, schema string, prefix string) ([]string, map[string][]scaffoldColumn, error) {
+	unique := map[string]bool{}
+	rows, err := db.Query(`SELECT tc.table_name, kcu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu ON kcu.constraint_schema = tc.constraint_schema AND kcu.constraint_name = tc.constraint_name
+		WHERE tc.table_schema = $1 AND tc.constraint_type = 'UNIQUE' AND (
+			SELECT COUNT(*) FROM information_schema.key_column_usage k
+			WHERE k.constraint_schema = tc.constraint_schema AND k.constraint_name = tc.constraint_name
+		) = 1`, schema)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting unique constraints: %w", err)
+	}
+	for rows.Next() {
+		var table, column string
+		err = rows.Scan(&table, &column)
+		if err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("error getting unique constraints: %w", err)
+		}
+		unique[table+"."+column] = true
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT c.table_name, c.column_name, c.data_type, COALESCE(c.character_maximum_length, 0),
+			c.is_nullable = 'YES', c.column_default IS NOT NULL
+		FROM information_schema.columns c
+		JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = $1 AND t.table_type = 'BASE TABLE' AND LEFT(c.table_name, LENGTH($2)) = $2
+		ORDER BY c.table_name, c.ordinal_position`, schema, prefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting columns: %w", err)
+	}
+	defer rows.Close()
+
+	tables := []string{}
+	columns := map[string][]scaffoldColumn{}
+	for rows.Next() {
+		var table string
+		c := scaffoldColumn{}
+		err = rows.Scan(&table, &c.name, &c.dataType, &c.maxLength, &c.nullable, &c.hasDefault)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting columns: %w", err)
+		}
+		c.unique = unique[table+"."+c.name]
+		if _, ok := columns[table]; !ok {
+			tables = append(tables, table)
+		}
+		columns[table] = append(columns[table], c)
+	}
+	return tables, columns, rows.Err()
+}
+
+// Scaffold generates structs for the tables of an existing database, and main.go that runs a prototype with them.
+// Tables and columns that struct-db-postgres cannot work with are skipped and listed in comments of models.go.
+func Scaffold(cfg ScaffoldConfig) error {
+	if cfg.DatabaseDSN == "" || cfg.Dir == "" {
+		return fmt.Errorf("database dsn and dir are required")
+	}
+	schema := cfg.Schema
+	if schema == "" {
+		schema = "public"
+	}
+
+	db, err := sql.Open("postgres", cfg.DatabaseDSN)
+	if err != nil {
+		return fmt.Errorf("error connecting to db: %w", err)
+	}
+	defer db.Close()
+
+	tables, columns, err := getScaffoldColumns(db, schema, cfg.TablePrefix)
+	if err != nil {
+		return err
+	}
+
+	builtin := map[string]bool{}
+	for _, f := range (&Prototype{}).getBuiltinConstructors() {
+		builtin[sqldb.GetStructName(f())] = true
+	}
+
+	structs := []*scaffoldStruct{}
+	skipped := []string{}
+	for _, table := range tables {
+		s, reason := getScaffoldStruct(table, strings.TrimPrefix(table, cfg.TablePrefix), columns[table])
+		if s != nil && builtin[s.Name] {
+			s, reason = nil, fmt.Sprintf("struct %s is used by prototyping", s.Name)
+		}
+		if s == nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %s", table, reason))
+			continue
+		}
+		structs = append(structs, s)
+	}
+
+	err = os.MkdirAll(cfg.Dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	data := map[string]interface{}{
+		"Schema":      schema,
+		"TablePrefix": schema + "." + cfg.TablePrefix,
+		"Structs":     structs,
+		"Skipped":     skipped,
+	}
+	for name, tpl := range map[string]*template.Template{"models.go": scaffoldModelsTemplate, "main.go": scaffoldMainTemplate} {
+		b := &bytes.Buffer{}
+		err = tpl.Execute(b, data)
+		if err != nil {
+			return fmt.Errorf("error generating %s: %w", name, err)
+		}
+		src, err := format.Source(b.Bytes())
+		if err != nil {
+			return fmt.Errorf("error formatting %s: %w", name, err)
+		}
+		err = os.WriteFile(filepath.Join(cfg.Dir, name), src, 0644)
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// runScaffoldCommand parses arguments of the scaffold command and runs Scaffold
+func (p *Prototype) runScaffoldCommand(w io.Writer, args []string) error {
+	cfg := ScaffoldConfig{}
+	fs := flag.NewFlagSet("scaffold", flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.StringVar(&cfg.DatabaseDSN, "dsn", p.dbDSN, "address of the database")
+	fs.StringVar(&cfg.Schema, "schema", "public", "schema containing the tables")
+	fs.StringVar(&cfg.TablePrefix, "prefix", "", "prefix of the tables, removed from the struct names")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("scaffold requires a directory")
+	}
+	cfg.Dir = fs.Arg(0)
+	return Scaffold(cfg)
+}
+
+var scaffoldModelsTemplate = template.Must(template.New("models").Parse(`// Code generated by prototyping from the {{.Schema}} schema.
+{{if .Skipped}}
+// Tables that were skipped:
+{{range .Skipped}}//   - {{.}}
+{{end}}{{end}}
+package main
+{{range .Structs}}
+// {{.Name}} is stored in the {{.Table}} table{{if .Skipped}}. Columns that were skipped:
+{{range .Skipped}}//   - {{.}}
+{{end}}{{else}}
+{{end}}type {{.Name}} struct {
+{{range .Fields}}	{{.Name}} {{.Type}} {{.Tag}}
+{{end}}}
+{{end}}`))
+
+var scaffoldMainTemplate = template.Must(template.New("main").Parse(`package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/mikolajgs/prototyping"
+)
+
+func main() {
+	// config is read from PROTO_* environment variables and the file in PROTO_CONFIG_FILE, eg. PROTO_DATABASE_DSN
+	cfg, err := prototyping.LoadConfig(os.Getenv("PROTO_CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("error loading config: %s", err.Error())
+	}
+	cfg.DatabaseTablePrefix = "{{.TablePrefix}}"
+
+	// "create-db" creates tables for users, sessions, permissions etc. as the tables of the models exist already
+	if len(os.Args) > 1 && os.Args[1] == "create-db" {
+		p, err := prototyping.NewPrototype(cfg)
+		if err != nil {
+			log.Fatalf("error creating new prototype: %s", err.Error())
+		}
+		err = p.CreateDB()
+		if err != nil {
+			log.Fatalf("error creating database: %s", err.Error())
+		}
+		return
+	}
+
+	p, err := prototyping.NewPrototype(
+		cfg,
+{{range .Structs}}		func() interface{} { return &{{.Name}}{} },
+{{end}}	)
+	if err != nil {
+		log.Fatalf("error creating new prototype: %s", err.Error())
+	}
+
+	// "export-proto", "generate-ts <dir>" and "generate-go-client <package>" generate code instead of running
+	ok, err := p.RunCommand(os.Args[1:])
+	if err != nil {
+		log.Fatalf("error running command: %s", err.Error())
+	}
+	if ok {
+		return
+	}
+
+	err = p.Run()
+	if err != nil {
+		log.Fatalf("error running prototype: %s", err.Error())
+	}
+}
+`))
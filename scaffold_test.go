@@ -0,0 +1,148 @@
+package prototyping
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+)
+
+type Category struct{ ID int64 }
+type Address struct{ ID int64 }
+type Status struct{ ID int64 }
+type House struct{ ID int64 }
+type OrderItem struct{ ID int64 }
+
+func TestGetSingularName(t *testing.T) {
+	tests := []struct {
+		table    string
+		singular string
+		obj      interface{}
+	}{
+		{table: "categories", singular: "category", obj: &Category{}},
+		{table: "addresses", singular: "address", obj: &Address{}},
+		{table: "statuses", singular: "status", obj: &Status{}},
+		{table: "houses", singular: "house", obj: &House{}},
+		{table: "order_items", singular: "order_item", obj: &OrderItem{}},
+		{table: "boxes", singular: "boxe"},
+		{table: "data"},
+		{table: "s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+			singular := getSingularName(tt.table)
+			if singular != tt.singular {
+				t.Fatalf("expected %q, got %q", tt.singular, singular)
+			}
+			if tt.obj == nil {
+				return
+			}
+			if name := getScaffoldName(singular); name != sqldb.GetStructName(tt.obj) {
+				t.Fatalf("expected struct %s, got %s", sqldb.GetStructName(tt.obj), name)
+			}
+			// struct-sql-postgres uses the same table
+			q := sqldb.NewStructSQL(tt.obj, sqldb.StructSQLOptions{}).GetQueryInsert()
+			if !strings.HasPrefix(q, "INSERT INTO "+tt.table+"(") {
+				t.Fatalf("expected table %s, got %s", tt.table, q)
+			}
+		})
+	}
+}
+
+func TestGetScaffoldName(t *testing.T) {
+	tests := []struct {
+		col  string
+		name string
+	}{
+		{col: "name", name: "Name"},
+		{col: "customer_id", name: "CustomerID"},
+		{col: "created_at", name: "CreatedAt"},
+		{col: "user_id_hash", name: "UserIDHash"},
+		{col: "address2", name: "Address2"},
+		{col: "userName"},
+		{col: "_name"},
+		{col: "first__name"},
+		{col: "2fa_code"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.col, func(t *testing.T) {
+			name := getScaffoldName(tt.col)
+			if name != tt.name {
+				t.Fatalf("expected %q, got %q", tt.name, name)
+			}
+			if name == "" {
+				return
+			}
+			// struct-sql-postgres uses the same column
+			typ := reflect.StructOf([]reflect.StructField{
+				{Name: "ID", Type: reflect.TypeOf(int64(0))},
+				{Name: name, Type: reflect.TypeOf("")},
+			})
+			q := sqldb.NewStructSQL(reflect.New(typ).Interface(), sqldb.StructSQLOptions{}).GetQueryInsert()
+			if !strings.Contains(q, "("+tt.col+")") {
+				t.Fatalf("expected column %s, got %s", tt.col, q)
+			}
+		})
+	}
+}
+
+func TestGetScaffoldType(t *testing.T) {
+	tests := []struct {
+		column scaffoldColumn
+		typ    string
+		opts   string
+		dbType string
+	}{
+		{column: scaffoldColumn{dataType: "bigint"}, typ: "int64", dbType: "BIGINT"},
+		{column: scaffoldColumn{dataType: "integer"}, typ: "int32", dbType: "INTEGER"},
+		{column: scaffoldColumn{dataType: "smallint"}, typ: "int16", dbType: "SMALLINT"},
+		{column: scaffoldColumn{dataType: "boolean"}, typ: "bool", dbType: "BOOLEAN"},
+		{column: scaffoldColumn{dataType: "real"}, typ: "float32"},
+		{column: scaffoldColumn{dataType: "numeric"}, typ: "float64"},
+		{column: scaffoldColumn{dataType: "text"}, typ: "string", opts: "db_type:TEXT", dbType: "TEXT"},
+		{column: scaffoldColumn{dataType: "character varying"}, typ: "string", opts: "db_type:TEXT", dbType: "TEXT"},
+		{column: scaffoldColumn{dataType: "character varying", maxLength: 255}, typ: "string", opts: "lenmax:255", dbType: "VARCHAR(255)"},
+		{column: scaffoldColumn{dataType: "character varying", maxLength: 100}, typ: "string", opts: "lenmax:100 db_type:VARCHAR(100)", dbType: "VARCHAR(100)"},
+		{column: scaffoldColumn{dataType: "character", maxLength: 2}, typ: "string", opts: "lenmax:2 db_type:CHAR(2)", dbType: "CHAR(2)"},
+		{column: scaffoldColumn{dataType: "jsonb"}},
+		{column: scaffoldColumn{dataType: "timestamp with time zone"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.column.dataType, func(t *testing.T) {
+			typ, opts, ok := getScaffoldType(tt.column)
+			if ok != (tt.typ != "") || typ != tt.typ || strings.Join(opts, " ") != tt.opts {
+				t.Fatalf("expected %q %q, got %q %q %v", tt.typ, tt.opts, typ, strings.Join(opts, " "), ok)
+			}
+			if tt.dbType == "" {
+				return
+			}
+			// struct-sql-postgres creates a column of the same type
+			fieldType := map[string]reflect.Type{
+				"int64":  reflect.TypeOf(int64(0)),
+				"int32":  reflect.TypeOf(int32(0)),
+				"int16":  reflect.TypeOf(int16(0)),
+				"bool":   reflect.TypeOf(false),
+				"string": reflect.TypeOf(""),
+			}[typ]
+			st := reflect.StructOf([]reflect.StructField{
+				{Name: "ID", Type: reflect.TypeOf(int64(0))},
+				{Name: "Value", Type: fieldType, Tag: reflect.StructTag(`ui:"` + strings.Join(opts, " ") + `"`)},
+			})
+			q := sqldb.NewStructSQL(reflect.New(st).Interface(), sqldb.StructSQLOptions{TagName: "ui"}).GetQueryCreateTable()
+			if !strings.Contains(q, "value "+tt.dbType+" NOT NULL") {
+				t.Fatalf("expected column of type %s, got %s", tt.dbType, q)
+			}
+		})
+	}
+}
+
+func TestRunScaffoldCommand(t *testing.T) {
+	p := &Prototype{}
+	b := &strings.Builder{}
+	for _, args := range [][]string{{"scaffold"}, {"scaffold", "-unknown", "dir"}, {"scaffold", "dir", "other"}, {"scaffold", "-schema", "app", "dir"}} {
+		if ok, err := p.runCommand(b, args); !ok || err == nil {
+			t.Errorf("expected error with %v", args)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package prototyping
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+	"gopkg.in/yaml.v3"
+)
+
+// fixtureRefPrefix marks a value in a fixture file that is replaced with ID of the referenced fixture
+const fixtureRefPrefix = "$ref:"
+
+// Fixture is an object that Seed creates, or updates when an object with the same natural key exists
+type Fixture struct {
+	// Ref names the fixture so that other fixtures can reference its ID
+	Ref string
+	// Key lists fields that identify an existing object. It defaults to the uniq fields, or all the fields that are
+	// set when there are none.
+	Key []string
+	// Object is a pointer to a struct passed to NewPrototype
+	Object interface{}
+	// Struct and Values can be used instead of Object to set fields by their json names
+	Struct string
+	Values map[string]interface{}
+	// Refs sets fields to IDs of the fixtures seeded earlier, eg. {"ItemGroupID": "first_group"}
+	Refs map[string]string
+}
+
+// SeedFunc returns fixtures to be seeded
+type SeedFunc func() ([]Fixture, error)
+
+type fixtureDefinition struct {
+	Struct string                 `yaml:"struct"`
+	Ref    string                 `yaml:"ref"`
+	Key    []string               `yaml:"key"`
+	Values map[string]interface{} `yaml:"values"`
+}
+
+// SeedFile returns fixtures from a YAML or a JSON file containing a list of objects with struct, values and optional
+// ref and key. String values starting with "$ref:" are replaced with ID of the referenced fixture.
+func SeedFile(path string) SeedFunc {
+	return func() ([]Fixture, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading fixtures: %w", err)
+		}
+		defs := []fixtureDefinition{}
+		err = yaml.Unmarshal(b, &defs)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing fixtures from %s: %w", path, err)
+		}
+
+		fixtures := []Fixture{}
+		for _, d := range defs {
+			f := Fixture{Ref: d.Ref, Key: d.Key, Struct: d.Struct, Values: map[string]interface{}{}, Refs: map[string]string{}}
+			for k, v := range d.Values {
+				if s, ok := v.(string); ok && strings.HasPrefix(s, fixtureRefPrefix) {
+					f.Refs[k] = strings.TrimPrefix(s, fixtureRefPrefix)
+					continue
+				}
+				f.Values[k] = v
+			}
+			fixtures = append(fixtures, f)
+		}
+		return fixtures, nil
+	}
+}
+
+// getFieldByName returns a field by its json or struct field name
+func (s *modelStruct) getFieldByName(name string) *modelField {
+	if field := s.getField(name); field != nil {
+		return field
+	}
+	for i := range s.fields {
+		if s.fields[i].name == name {
+			return &s.fields[i]
+		}
+	}
+	return nil
+}
+
+// getFixtureKey returns filters that find an existing object of the fixture
+func getFixtureKey(s *modelStruct, key []string, v reflect.Value) (map[string]interface{}, error) {
+	filters := map[string]interface{}{}
+	for _, k := range key {
+		field := s.getFieldByName(k)
+		if field == nil {
+			return nil, fmt.Errorf("invalid key field %s", k)
+		}
+		filters[field.name] = v.FieldByName(field.name).Interface()
+	}
+	if len(filters) > 0 {
+		return filters, nil
+	}
+
+	t := v.Type()
+	for _, field := range s.fields {
+		sf, _ := t.FieldByName(field.name)
+		if strings.Contains(" "+sf.Tag.Get("ui")+" ", " uniq ") && !v.FieldByName(field.name).IsZero() {
+			filters[field.name] = v.FieldByName(field.name).Interface()
+		}
+	}
+	if len(filters) > 0 {
+		return filters, nil
+	}
+
+	for _, field := range s.fields {
+		switch field.name {
+		case "ID", "CreatedAt", "CreatedBy", "LastModifiedAt", "LastModifiedBy":
+			continue
+		}
+		if field.password || v.FieldByName(field.name).IsZero() {
+			continue
+		}
+		filters[field.name] = v.FieldByName(field.name).Interface()
+	}
+	if len(filters) == 0 {
+		return nil, errors.New("fixture has no values to identify it")
+	}
+	return filters, nil
+}
+
+// seedFixture creates or updates an object and returns its ID
+func (p *Prototype) seedFixture(structs map[string]*modelStruct, refs map[string]int64, f Fixture) (int64, error) {
+	name := f.Struct
+	if f.Object != nil {
+		name = sqldb.GetStructName(f.Object)
+	}
+	s := structs[name]
+	if s == nil {
+		return 0, fmt.Errorf("struct %s is not registered", name)
+	}
+
+	obj := f.Object
+	if obj == nil {
+		obj = s.constructor()
+	}
+	v := reflect.ValueOf(obj).Elem()
+	for k, val := range f.Values {
+		field := s.getFieldByName(k)
+		if field == nil || val == nil {
+			return 0, fmt.Errorf("invalid field %s", k)
+		}
+		if field.password {
+			str, _ := val.(string)
+			val = p.generatePassword(str)
+		}
+		cv, err := convertValue(val, field.typ)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value of %s", k)
+		}
+		v.FieldByName(field.name).Set(cv)
+	}
+	for k, ref := range f.Refs {
+		field := s.getFieldByName(k)
+		if field == nil {
+			return 0, fmt.Errorf("invalid field %s", k)
+		}
+		id, ok := refs[ref]
+		if !ok {
+			return 0, fmt.Errorf("fixture %s not found", ref)
+		}
+		cv, err := convertValue(id, field.typ)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value of %s", k)
+		}
+		v.FieldByName(field.name).Set(cv)
+	}
+
+	filters, err := getFixtureKey(s, f.Key, v)
+	if err != nil {
+		return 0, err
+	}
+	existing, err := p.orm.Get(s.constructor, []string{"ID", "asc"}, 1, 0, filters, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error getting existing object: %w", err)
+	}
+
+	now := time.Now().Unix()
+	setInt64Field := func(name string, value int64) {
+		field := v.FieldByName(name)
+		if field.IsValid() && field.Kind() == reflect.Int64 {
+			field.SetInt(value)
+		}
+	}
+	if len(existing) > 0 {
+		e := reflect.ValueOf(existing[0]).Elem()
+		for _, name := range []string{"ID", "CreatedAt", "CreatedBy"} {
+			if field := e.FieldByName(name); field.IsValid() && field.Kind() == reflect.Int64 {
+				setInt64Field(name, field.Int())
+			}
+		}
+	} else {
+		setInt64Field("ID", 0)
+		setInt64Field("CreatedAt", now)
+	}
+	setInt64Field("LastModifiedAt", now)
+
+	err = p.orm.Save(obj)
+	if err != nil {
+		return 0, fmt.Errorf("error saving object: %w", err)
+	}
+	return v.FieldByName("ID").Int(), nil
+}
+
+// Seed creates fixtures returned by funcs in the order they come. Fixtures that exist already are updated instead,
+// so Seed can be called every time the prototype starts.
+func (p *Prototype) Seed(funcs ...SeedFunc) error {
+	err := p.openDB()
+	if err != nil {
+		return err
+	}
+
+	structs := map[string]*modelStruct{}
+	for _, f := range p.getExposedConstructors() {
+		s := getModelStruct(f)
+		structs[s.name] = s
+	}
+
+	refs := map[string]int64{}
+	for _, fn := range funcs {
+		fixtures, err := fn()
+		if err != nil {
+			return fmt.Errorf("error getting fixtures: %w", err)
+		}
+		for i, f := range fixtures {
+			id, err := p.seedFixture(structs, refs, f)
+			if err != nil {
+				return fmt.Errorf("error seeding fixture %d (%s): %w", i, f.Ref, err)
+			}
+			if f.Ref != "" {
+				refs[f.Ref] = id
+			}
+		}
+	}
+	return nil
+}
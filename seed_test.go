@@ -0,0 +1,152 @@
+package prototyping
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type SeedItem struct {
+	ID          int64
+	CreatedAt   int64
+	Title       string `json:"title" ui:"req lenmax:12"`
+	Email       string `json:"email" ui:"uniq"`
+	ItemGroupID int64  `json:"item_group_id"`
+	Count       int    `json:"count" ui:"valmin:1 valmax:5"`
+}
+
+func TestSeedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures.yaml")
+	err := os.WriteFile(path, []byte(`
+- struct: SeedItem
+  ref: first
+  key: [title]
+  values:
+    title: First
+    item_group_id: $ref:group
+`), 0o644)
+	if err != nil {
+		t.Fatalf("error writing fixtures: %s", err)
+	}
+
+	fixtures, err := SeedFile(path)()
+	if err != nil {
+		t.Fatalf("error reading fixtures: %s", err)
+	}
+	expected := []Fixture{{
+		Ref:    "first",
+		Key:    []string{"title"},
+		Struct: "SeedItem",
+		Values: map[string]interface{}{"title": "First"},
+		Refs:   map[string]string{"item_group_id": "group"},
+	}}
+	if !reflect.DeepEqual(fixtures, expected) {
+		t.Fatalf("expected %v, got %v", expected, fixtures)
+	}
+
+	_, err = SeedFile(filepath.Join(t.TempDir(), "missing.yaml"))()
+	if err == nil {
+		t.Fatal("expected error with missing file")
+	}
+}
+
+func TestGetFixtureKey(t *testing.T) {
+	s := getModelStruct(func() interface{} { return &SeedItem{} })
+	v := reflect.ValueOf(&SeedItem{ID: 4, CreatedAt: 5, Title: "First", Email: "a@example.com", Count: 2}).Elem()
+
+	tests := []struct {
+		name     string
+		key      []string
+		v        reflect.Value
+		expected map[string]interface{}
+		err      bool
+	}{
+		{name: "key by json name", key: []string{"title", "Count"}, v: v, expected: map[string]interface{}{"Title": "First", "Count": 2}},
+		{name: "uniq fields", v: v, expected: map[string]interface{}{"Email": "a@example.com"}},
+		{name: "fields that are set", v: reflect.ValueOf(&SeedItem{ID: 4, Title: "First"}).Elem(), expected: map[string]interface{}{"Title": "First"}},
+		{name: "invalid key", key: []string{"missing"}, v: v, err: true},
+		{name: "no values", v: reflect.ValueOf(&SeedItem{ID: 4}).Elem(), err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := getFixtureKey(s, tt.key, tt.v)
+			if (err != nil) != tt.err || (!tt.err && !reflect.DeepEqual(filters, tt.expected)) {
+				t.Fatalf("expected %v, got %v and %v", tt.expected, filters, err)
+			}
+		})
+	}
+}
+
+func TestSeedFixture(t *testing.T) {
+	o := &testORM{}
+	p := &Prototype{orm: o}
+	s := getModelStruct(func() interface{} { return &SeedItem{} })
+	structs := map[string]*modelStruct{s.name: s}
+	refs := map[string]int64{"group": 7}
+
+	obj := &SeedItem{ID: 3}
+	_, err := p.seedFixture(structs, refs, Fixture{
+		Object: obj,
+		Values: map[string]interface{}{"title": "First", "Count": 2},
+		Refs:   map[string]string{"item_group_id": "group"},
+	})
+	if err != nil {
+		t.Fatalf("error seeding fixture: %s", err)
+	}
+	if obj.ID != 0 || obj.CreatedAt == 0 || obj.Title != "First" || obj.Count != 2 || obj.ItemGroupID != 7 {
+		t.Fatalf("unexpected object %+v", obj)
+	}
+	if strings.Join(o.calls, ",") != "Get,Save" {
+		t.Fatalf("expected object to be looked up and saved, got %v", o.calls)
+	}
+
+	for _, f := range []Fixture{
+		{Struct: "Other", Values: map[string]interface{}{"title": "First"}},
+		{Struct: "SeedItem", Values: map[string]interface{}{"missing": "First"}},
+		{Struct: "SeedItem", Values: map[string]interface{}{"title": 1}},
+		{Struct: "SeedItem", Values: map[string]interface{}{"title": "First"}, Refs: map[string]string{"item_group_id": "missing"}},
+	} {
+		if _, err := p.seedFixture(structs, refs, f); err == nil {
+			t.Errorf("expected error seeding %+v", f)
+		}
+	}
+}
+
+func TestFakeFixtures(t *testing.T) {
+	p := &Prototype{orm: &testORM{}}
+	fn := p.FakeFixtures(func() interface{} { return &SeedItem{} }, 3)
+
+	fixtures, err := fn()
+	if err != nil {
+		t.Fatalf("error getting fake fixtures: %s", err)
+	}
+	again, _ := fn()
+	if !reflect.DeepEqual(fixtures, again) {
+		t.Fatal("expected the same fixtures each time")
+	}
+	if len(fixtures) != 3 {
+		t.Fatalf("expected 3 fixtures, got %d", len(fixtures))
+	}
+
+	emails := map[string]bool{}
+	for _, f := range fixtures {
+		title, _ := f.Values["title"].(string)
+		email, _ := f.Values["email"].(string)
+		count, _ := f.Values["count"].(float64)
+		if title == "" || len(title) > 12 {
+			t.Errorf("unexpected title %q", title)
+		}
+		if !strings.Contains(email, "@") || emails[email] {
+			t.Errorf("unexpected email %q", email)
+		}
+		emails[email] = true
+		if count < 1 || count > 5 {
+			t.Errorf("unexpected count %v", f.Values["count"])
+		}
+		if !reflect.DeepEqual(f.Key, []string{"Title", "Email"}) {
+			t.Errorf("unexpected key %v", f.Key)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package prototyping
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-phings/umbrella"
+)
+
+const sessionCookieName = "ProtoSession"
+
+// signedValue is a payload stored in a cookie along with its HMAC signature
+type signedValue struct {
+	UserID    int64             `json:"u,omitempty"`
+	ExpiresAt int64             `json:"e"`
+	Values    map[string]string `json:"v,omitempty"`
+}
+
+func newSessionKey() ([]byte, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, fmt.Errorf("error generating random bytes: %w", err)
+	}
+	return b, nil
+}
+
+func (p *Prototype) sign(v *signedValue) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	mac := hmac.New(sha256.New, p.sessionKey)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (p *Prototype) verify(s string) (*signedValue, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return nil, errors.New("invalid format")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("invalid signature")
+	}
+	mac := hmac.New(sha256.New, p.sessionKey)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("invalid signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("invalid payload")
+	}
+	v := &signedValue{}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return nil, errors.New("invalid payload")
+	}
+	if v.ExpiresAt < time.Now().Unix() {
+		return nil, errors.New("expired")
+	}
+	return v, nil
+}
+
+// setSignedCookie writes a signed value into a cookie that expires along with the value
+func (p *Prototype) setSignedCookie(w http.ResponseWriter, name string, path string, v *signedValue) error {
+	s, err := p.sign(v)
+	if err != nil {
+		return err
+	}
+	sameSite := p.getCookieSameSite()
+	if name == oidcStateCookieName {
+		// state has to be sent back when identity provider redirects to the callback
+		sameSite = http.SameSiteLaxMode
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    s,
+		Path:     path,
+		Expires:  time.Unix(v.ExpiresAt, 0),
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+	return nil
+}
+
+func (p *Prototype) getCookieSameSite() http.SameSite {
+	switch p.cookieSameSite {
+	case "Strict":
+		return http.SameSiteStrictMode
+	case "None":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+func (p *Prototype) getSignedCookie(r *http.Request, name string) *signedValue {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return nil
+	}
+	v, err := p.verify(c.Value)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+func clearCookie(w http.ResponseWriter, name string, path string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     path,
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func hashSessionID(sid string) string {
+	h := sha256.Sum256([]byte(sid))
+	return hex.EncodeToString(h[:])
+}
+
+// createSession stores a session of the user in umbrella's sessions table and returns its ID that goes into the
+// session cookie. Only hash of the ID is stored. Session is checked on every request so removing it, eg. when
+// password is reset, logs the user out.
+func (p *Prototype) createSession(ctx context.Context, userID int64, expiresAt int64) (string, error) {
+	sid, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	err = p.ormWithContext(ctx).Save(&umbrella.Session{
+		Key:       hashSessionID(sid),
+		ExpiresAt: expiresAt,
+		UserID:    userID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error saving session: %w", err)
+	}
+	return sid, nil
+}
+
+// getSessionUserID returns ID of the user logged in with a session cookie (eg. after OIDC login) or 0 when the
+// cookie is invalid or its session does not exist anymore
+func (p *Prototype) getSessionUserID(r *http.Request) int64 {
+	v := p.getSignedCookie(r, sessionCookieName)
+	if v == nil || v.UserID == 0 || v.Values["sid"] == "" {
+		return 0
+	}
+	rows, err := p.ormWithContext(r.Context()).Get(func() interface{} { return &umbrella.Session{} }, []string{"ID", "asc"}, 1, 0, map[string]interface{}{
+		"Key":    hashSessionID(v.Values["sid"]),
+		"UserID": v.UserID,
+	}, nil)
+	if err != nil {
+		p.logger.Error("error getting session", slog.Any("error", err))
+		return 0
+	}
+	if len(rows) == 0 || rows[0].(*umbrella.Session).ExpiresAt < time.Now().Unix() {
+		return 0
+	}
+	return v.UserID
+}
+
+// wrapHandlerWithSessionLogout removes the session and clears its cookie before passing the request to umbrella's
+// logout handler
+func (p *Prototype) wrapHandlerWithSessionLogout(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := p.getSignedCookie(r, sessionCookieName); v != nil && v.Values["sid"] != "" {
+			err := p.ormWithContext(r.Context()).DeleteMultiple(&umbrella.Session{}, map[string]interface{}{"Key": hashSessionID(v.Values["sid"])})
+			if err != nil {
+				p.logger.Error("error deleting session", slog.Any("error", err))
+			}
+		}
+		clearCookie(w, sessionCookieName, p.uriUI)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,151 @@
+package prototyping
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-phings/umbrella"
+)
+
+// testSessionORM keeps umbrella sessions in memory
+type testSessionORM struct {
+	testORM
+	sessions []*umbrella.Session
+}
+
+func (o *testSessionORM) Save(obj interface{}) error {
+	s := obj.(*umbrella.Session)
+	s.ID = int64(len(o.sessions) + 1)
+	o.sessions = append(o.sessions, s)
+	return nil
+}
+
+func (o *testSessionORM) matches(s *umbrella.Session, filters map[string]interface{}) bool {
+	if k, ok := filters["Key"]; ok && s.Key != k {
+		return false
+	}
+	if id, ok := filters["UserID"]; ok && s.UserID != id {
+		return false
+	}
+	return true
+}
+
+func (o *testSessionORM) Get(newObjFunc func() interface{}, order []string, limit int, offset int, filters map[string]interface{}, rowObjTransformFunc func(interface{}) interface{}) ([]interface{}, error) {
+	rows := []interface{}{}
+	for _, s := range o.sessions {
+		if o.matches(s, filters) {
+			rows = append(rows, s)
+		}
+	}
+	return rows, nil
+}
+
+func (o *testSessionORM) DeleteMultiple(obj interface{}, filters map[string]interface{}) error {
+	sessions := []*umbrella.Session{}
+	for _, s := range o.sessions {
+		if !o.matches(s, filters) {
+			sessions = append(sessions, s)
+		}
+	}
+	o.sessions = sessions
+	return nil
+}
+
+func newTestSessionPrototype() (*Prototype, *testSessionORM) {
+	o := &testSessionORM{}
+	return &Prototype{
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		uriUI:      "/ui/",
+		sessionKey: []byte(strings.Repeat("k", 32)),
+		orm:        o,
+	}, o
+}
+
+func newTestSessionRequest(t *testing.T, p *Prototype, v *signedValue) *http.Request {
+	rec := httptest.NewRecorder()
+	err := p.setSignedCookie(rec, sessionCookieName, p.uriUI, v)
+	if err != nil {
+		t.Fatalf("error setting cookie: %s", err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/ui/", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestSession(t *testing.T) {
+	p, o := newTestSessionPrototype()
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	sid, err := p.createSession(context.Background(), 5, expiresAt)
+	if err != nil {
+		t.Fatalf("error creating session: %s", err)
+	}
+	if len(o.sessions) != 1 || o.sessions[0].Key == sid || o.sessions[0].UserID != 5 {
+		t.Fatalf("expected session with hashed id, got %+v", o.sessions)
+	}
+
+	r := newTestSessionRequest(t, p, &signedValue{UserID: 5, ExpiresAt: expiresAt, Values: map[string]string{"sid": sid}})
+	if id := p.getSessionUserID(r); id != 5 {
+		t.Fatalf("expected user 5, got %d", id)
+	}
+
+	// cookie signed for another user, or without the session, is not accepted
+	for _, v := range []*signedValue{
+		{UserID: 6, ExpiresAt: expiresAt, Values: map[string]string{"sid": sid}},
+		{UserID: 5, ExpiresAt: expiresAt},
+		{UserID: 5, ExpiresAt: expiresAt, Values: map[string]string{"sid": "other"}},
+	} {
+		if id := p.getSessionUserID(newTestSessionRequest(t, p, v)); id != 0 {
+			t.Errorf("expected no user with %+v, got %d", v, id)
+		}
+	}
+
+	// revoking sessions of the user, eg. on password reset, logs the user out
+	err = p.orm.DeleteMultiple(&umbrella.Session{}, map[string]interface{}{"UserID": int64(5)})
+	if err != nil {
+		t.Fatalf("error deleting sessions: %s", err)
+	}
+	if id := p.getSessionUserID(r); id != 0 {
+		t.Fatalf("expected revoked session not to be accepted, got user %d", id)
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	p, o := newTestSessionPrototype()
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	sid, err := p.createSession(context.Background(), 5, expiresAt)
+	if err != nil {
+		t.Fatalf("error creating session: %s", err)
+	}
+	o.sessions[0].ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	r := newTestSessionRequest(t, p, &signedValue{UserID: 5, ExpiresAt: expiresAt, Values: map[string]string{"sid": sid}})
+	if id := p.getSessionUserID(r); id != 0 {
+		t.Fatalf("expected expired session not to be accepted, got user %d", id)
+	}
+}
+
+func TestSessionLogout(t *testing.T) {
+	p, o := newTestSessionPrototype()
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	sid, err := p.createSession(context.Background(), 5, expiresAt)
+	if err != nil {
+		t.Fatalf("error creating session: %s", err)
+	}
+	r := newTestSessionRequest(t, p, &signedValue{UserID: 5, ExpiresAt: expiresAt, Values: map[string]string{"sid": sid}})
+
+	rec := httptest.NewRecorder()
+	p.wrapHandlerWithSessionLogout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, r)
+	if len(o.sessions) != 0 {
+		t.Fatalf("expected session to be removed, got %+v", o.sessions)
+	}
+	if c := rec.Result().Cookies(); len(c) != 1 || c[0].Name != sessionCookieName || c[0].MaxAge != -1 {
+		t.Fatalf("expected session cookie to be cleared, got %v", c)
+	}
+}
@@ -0,0 +1,406 @@
+package prototyping
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+	"github.com/go-phings/umbrella"
+)
+
+// TenancyConfig enables separating data of registered structs between tenants. Tenant is resolved from the header,
+// then from the subdomain, and then it is the first tenant that the logged user is a member of. Without a tenant,
+// registered structs cannot be accessed.
+type TenancyConfig struct {
+	// Header contains slug of the tenant, defaults to X-Tenant
+	Header string
+	// Domain enables resolving tenant from subdomain, eg. "example.com" for "slug.example.com"
+	Domain string
+}
+
+const tenantColumn = "tenant_id"
+const tenantContextKey = contextKey("tenant")
+
+var errTenantNotAllowed = errors.New("object belongs to another tenant")
+
+type tenancy struct {
+	header    string
+	domain    string
+	scoped    map[string]bool
+	db        *sql.DB
+	tblPrefix string
+}
+
+// newTenancy scopes structs created by constructors, which are the ones passed to NewPrototype
+func newTenancy(cfg *TenancyConfig, constructors []func() interface{}) *tenancy {
+	t := &tenancy{
+		header: "X-Tenant",
+		domain: strings.ToLower(strings.TrimPrefix(cfg.Domain, ".")),
+		scoped: map[string]bool{},
+	}
+	if cfg.Header != "" {
+		t.header = cfg.Header
+	}
+	for _, f := range constructors {
+		t.scoped[sqldb.GetStructName(f())] = true
+	}
+	return t
+}
+
+func (t *tenancy) setDatabase(db *sql.DB, tblPrefix string) {
+	t.db = db
+	t.tblPrefix = tblPrefix
+}
+
+func (t *tenancy) getTableAndIDColumn(obj interface{}) (string, string, error) {
+	return getTableAndIDColumn(obj, t.tblPrefix)
+}
+
+// addColumns adds tenant column to tables of the scoped structs
+func (t *tenancy) addColumns(constructors []func() interface{}) error {
+	for _, f := range constructors {
+		o := f()
+		if !t.scoped[sqldb.GetStructName(o)] {
+			continue
+		}
+		tbl, _, err := t.getTableAndIDColumn(o)
+		if err != nil {
+			return err
+		}
+		_, err = t.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s BIGINT NOT NULL DEFAULT 0", tbl, tenantColumn))
+		if err != nil {
+			return err
+		}
+		_, err = t.db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_%s_idx ON %s (%s)", tbl, tenantColumn, tbl, tenantColumn))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tenantORM limits all operations on scoped structs to a single tenant. Tenant column is not a struct field so it
+// is filtered with a raw filter, and it is set when a scoped object is inserted. When tenantID is 0,
+// request is not scoped to any tenant and scoped structs cannot be accessed at all.
+type tenantORM struct {
+	orm      ORM
+	t        *tenancy
+	tenantID int64
+}
+
+func (o *tenantORM) isScoped(obj interface{}) bool {
+	return o.t.scoped[sqldb.GetStructName(obj)]
+}
+
+func (o *tenantORM) getFilters(filters map[string]interface{}) (map[string]interface{}, error) {
+	f := map[string]interface{}{}
+	for k, v := range filters {
+		f[k] = v
+	}
+
+	raw, ok := f["_raw"].([]interface{})
+	if !ok || len(raw) == 0 || raw[0].(string) == "" {
+		f["_raw"] = []interface{}{fmt.Sprintf("%s = ?", tenantColumn), o.tenantID}
+		return f, nil
+	}
+
+	// with OR conjunction, regular filters would be an alternative to the tenant condition
+	if conjunction, ok := f["_rawConjuction"].(int); ok && conjunction == sqldb.RawConjuctionOR {
+		return nil, errors.New("raw filters with or conjunction are not supported with tenancy")
+	}
+	f["_raw"] = append([]interface{}{fmt.Sprintf("(%s) AND %s = ?", raw[0].(string), tenantColumn)}, append(raw[1:], o.tenantID)...)
+	return f, nil
+}
+
+// save inserts or updates scoped object through the wrapped ORM. New object is inserted with the tenant column set,
+// and existing object is updated only when it belongs to the tenant, so that it is never visible outside of the
+// tenant, and objects of other tenants cannot be overwritten.
+func (o *tenantORM) save(obj interface{}) error {
+	err := validateObject(obj)
+	if err != nil {
+		return err
+	}
+
+	id := o.orm.GetObjIDValue(obj)
+	if id == 0 {
+		err = insertWithColumns(o.orm, obj, map[string]interface{}{tenantColumn: o.tenantID})
+		if err != nil {
+			return fmt.Errorf("error inserting object: %w", err)
+		}
+		return nil
+	}
+
+	cnt, err := o.updateFields(obj, getFieldValues(obj), map[string]interface{}{"ID": id})
+	if err != nil {
+		return fmt.Errorf("error updating object: %w", err)
+	}
+	if cnt == 0 {
+		return errTenantNotAllowed
+	}
+	return nil
+}
+
+func (o *tenantORM) SetDatabase(dbConn *sql.DB, tblPrefix string) {
+	o.orm.SetDatabase(dbConn, tblPrefix)
+}
+
+func (o *tenantORM) RegisterStruct(obj interface{}, inheritFromObj interface{}, overwriteExisting bool, forceNameForDB string, useOnlyRootFromInheritedObj bool) error {
+	return o.orm.RegisterStruct(obj, inheritFromObj, overwriteExisting, forceNameForDB, useOnlyRootFromInheritedObj)
+}
+
+func (o *tenantORM) CreateTables(objs ...interface{}) error {
+	return o.orm.CreateTables(objs...)
+}
+
+func (o *tenantORM) Load(obj interface{}, id string) error {
+	if !o.isScoped(obj) {
+		return o.orm.Load(obj, id)
+	}
+	idInt, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("error converting id: %w", err)
+	}
+	// object of another tenant is the same as one that does not exist
+	o.orm.ResetFields(obj)
+	if o.tenantID == 0 {
+		return nil
+	}
+	t := reflect.TypeOf(obj).Elem()
+	rows, err := o.Get(func() interface{} { return reflect.New(t).Interface() }, []string{"ID", "asc"}, 1, 0, map[string]interface{}{"ID": idInt}, nil)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(rows[0]).Elem())
+	}
+	return nil
+}
+
+func (o *tenantORM) Save(obj interface{}) error {
+	if !o.isScoped(obj) {
+		return o.orm.Save(obj)
+	}
+	if o.tenantID == 0 {
+		return errTenantNotAllowed
+	}
+	return o.save(obj)
+}
+
+func (o *tenantORM) Delete(obj interface{}) error {
+	if !o.isScoped(obj) {
+		return o.orm.Delete(obj)
+	}
+	id := o.orm.GetObjIDValue(obj)
+	if o.tenantID == 0 || id == 0 {
+		return errTenantNotAllowed
+	}
+	cnt, err := o.GetCount(func() interface{} { return reflect.New(reflect.TypeOf(obj).Elem()).Interface() }, map[string]interface{}{"ID": id})
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return errTenantNotAllowed
+	}
+	// tenant is in the query as well, in case object was moved in the meantime
+	err = o.DeleteMultiple(obj, map[string]interface{}{"ID": id})
+	if err != nil {
+		return err
+	}
+	o.orm.ResetFields(obj)
+	return nil
+}
+
+func (o *tenantORM) DeleteMultiple(obj interface{}, filters map[string]interface{}) error {
+	if !o.isScoped(obj) {
+		return o.orm.DeleteMultiple(obj, filters)
+	}
+	if o.tenantID == 0 {
+		return errTenantNotAllowed
+	}
+	f, err := o.getFilters(filters)
+	if err != nil {
+		return err
+	}
+	return o.orm.DeleteMultiple(obj, f)
+}
+
+func (o *tenantORM) Get(newObjFunc func() interface{}, order []string, limit int, offset int, filters map[string]interface{}, rowObjTransformFunc func(interface{}) interface{}) ([]interface{}, error) {
+	if !o.isScoped(newObjFunc()) {
+		return o.orm.Get(newObjFunc, order, limit, offset, filters, rowObjTransformFunc)
+	}
+	if o.tenantID == 0 {
+		return []interface{}{}, nil
+	}
+	f, err := o.getFilters(filters)
+	if err != nil {
+		return nil, err
+	}
+	return o.orm.Get(newObjFunc, order, limit, offset, f, rowObjTransformFunc)
+}
+
+func (o *tenantORM) GetCount(newObjFunc func() interface{}, filters map[string]interface{}) (int64, error) {
+	if !o.isScoped(newObjFunc()) {
+		return o.orm.GetCount(newObjFunc, filters)
+	}
+	if o.tenantID == 0 {
+		return 0, nil
+	}
+	f, err := o.getFilters(filters)
+	if err != nil {
+		return 0, err
+	}
+	return o.orm.GetCount(newObjFunc, f)
+}
+
+func (o *tenantORM) updateFields(obj interface{}, values map[string]interface{}, filters map[string]interface{}) (int64, error) {
+	if !o.isScoped(obj) {
+		return updateFields(o.orm, obj, values, filters)
+	}
+	if o.tenantID == 0 {
+		return 0, errTenantNotAllowed
+	}
+	f, err := o.getFilters(filters)
+	if err != nil {
+		return 0, err
+	}
+	return updateFields(o.orm, obj, values, f)
+}
+
+func (o *tenantORM) GetFieldNameFromDBCol(obj interface{}, field string) (string, error) {
+	return o.orm.GetFieldNameFromDBCol(obj, field)
+}
+
+func (o *tenantORM) GetObjIDValue(obj interface{}) int64 {
+	return o.orm.GetObjIDValue(obj)
+}
+
+func (o *tenantORM) ResetFields(obj interface{}) {
+	o.orm.ResetFields(obj)
+}
+
+// GetTenantID returns ID of the tenant that the request is scoped to, or 0 when there is none
+func GetTenantID(ctx context.Context) int64 {
+	id, _ := ctx.Value(tenantContextKey).(int64)
+	return id
+}
+
+// getTenantSlug returns tenant slug from the header or the subdomain
+func (p *Prototype) getTenantSlug(r *http.Request) string {
+	if s := r.Header.Get(p.tenancy.header); s != "" {
+		return strings.ToLower(strings.TrimSpace(s))
+	}
+	if p.tenancy.domain == "" {
+		return ""
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, "."+p.tenancy.domain) {
+		return ""
+	}
+	sub := strings.TrimSuffix(host, "."+p.tenancy.domain)
+	if strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+// resolveTenant returns ID of the tenant for the request. Users that can read Tenant type can access any tenant, and
+// others only the tenants they are members of. When tenant is not requested, first tenant of the user is taken,
+// or 0 when there is none, with which scoped structs cannot be accessed.
+func (p *Prototype) resolveTenant(r *http.Request, lu *loggedUser) (int64, bool, error) {
+	orm := p.ormWithContext(r.Context())
+	isTenantAdmin := lu != nil && isTypeAllowed(lu.allowedTypes[umbrella.OpsRead], "Tenant")
+
+	slug := p.getTenantSlug(r)
+	if slug == "" {
+		if lu == nil || lu.id == 0 {
+			return 0, true, nil
+		}
+		rows, err := orm.Get(func() interface{} { return &TenantUser{} }, []string{"ID", "asc"}, 1, 0, map[string]interface{}{"UserID": lu.id}, nil)
+		if err != nil || len(rows) == 0 {
+			return 0, true, err
+		}
+		return rows[0].(*TenantUser).TenantID, true, nil
+	}
+
+	rows, err := orm.Get(func() interface{} { return &Tenant{} }, []string{"ID", "asc"}, 1, 0, map[string]interface{}{"Slug": slug}, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(rows) == 0 || rows[0].(*Tenant).Flags&TenantFlagActive == 0 {
+		return 0, false, nil
+	}
+	tenantID := rows[0].(*Tenant).ID
+	if isTenantAdmin {
+		return tenantID, true, nil
+	}
+	if lu == nil || lu.id == 0 {
+		return 0, false, nil
+	}
+
+	cnt, err := orm.GetCount(func() interface{} { return &TenantUser{} }, map[string]interface{}{"TenantID": tenantID, "UserID": lu.id})
+	if err != nil {
+		return 0, false, err
+	}
+	return tenantID, cnt > 0, nil
+}
+
+// wrapHandlerWithTenant resolves tenant and puts it into the request context, along with ORM that is scoped to
+// the tenant and traced under the request span, which handlers take with getRequestORM. It must be wrapped with
+// wrapHandlerWithUmbrella as it needs the logged user.
+func (p *Prototype) wrapHandlerWithTenant(h http.Handler) http.Handler {
+	if p.tenancy == nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ormContextKey, p.ormWithContext(r.Context()))))
+		})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID, ok, err := p.resolveTenant(r, getLoggedUserFromContext(r.Context()))
+		if err != nil {
+			p.logger.Error("error resolving tenant", slog.Any("error", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("TenantNotAllowed"))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), tenantContextKey, tenantID)
+		ctx = context.WithValue(ctx, ormContextKey, ORM(&tenantORM{orm: p.ormWithContext(r.Context()), t: p.tenancy, tenantID: tenantID}))
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newTenantHandler returns handler that passes request to the handler created by newHandler with ORM scoped to the
+// tenant of the request. crud and crud-ui controllers get the ORM when they are created and do not pass the request
+// context to it, so a handler is created once for every tenant, and their ORM spans are not under the request span.
+func (p *Prototype) newTenantHandler(newHandler func(orm ORM) http.Handler) http.Handler {
+	if p.tenancy == nil {
+		return newHandler(p.orm)
+	}
+
+	handlers := sync.Map{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID := GetTenantID(r.Context())
+		h, ok := handlers.Load(tenantID)
+		if !ok {
+			h, _ = handlers.LoadOrStore(tenantID, newHandler(&tenantORM{orm: p.orm, t: p.tenancy, tenantID: tenantID}))
+		}
+		h.(http.Handler).ServeHTTP(w, r)
+	})
+}
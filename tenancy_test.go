@@ -0,0 +1,170 @@
+package prototyping
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	sqldb "github.com/go-phings/struct-sql-postgres"
+)
+
+type testTenantItem struct {
+	ID      int64
+	Flags   int64
+	Name    string `ui:"req lenmax:50"`
+	Price   int
+	Parents []int64
+}
+
+func TestTenantORMWithoutTenant(t *testing.T) {
+	orm := &testORM{}
+	o := &tenantORM{orm: orm, t: &tenancy{scoped: map[string]bool{"testTenantItem": true}}}
+	newItem := func() interface{} { return &testTenantItem{} }
+
+	if err := o.Save(&testTenantItem{Name: "a"}); !errors.Is(err, errTenantNotAllowed) {
+		t.Fatalf("expected save to be denied, got %v", err)
+	}
+	if err := o.Delete(&testTenantItem{ID: 1}); !errors.Is(err, errTenantNotAllowed) {
+		t.Fatalf("expected delete to be denied, got %v", err)
+	}
+	if err := o.DeleteMultiple(&testTenantItem{}, nil); !errors.Is(err, errTenantNotAllowed) {
+		t.Fatalf("expected delete to be denied, got %v", err)
+	}
+	rows, err := o.Get(newItem, nil, 0, 0, nil, nil)
+	if err != nil || len(rows) != 0 {
+		t.Fatalf("expected no rows, got %v %v", rows, err)
+	}
+	cnt, err := o.GetCount(newItem, nil)
+	if err != nil || cnt != 0 {
+		t.Fatalf("expected no rows, got %d %v", cnt, err)
+	}
+	item := &testTenantItem{ID: 1, Name: "a"}
+	if err := o.Load(item, "1"); err != nil || item.ID != 0 {
+		t.Fatalf("expected item not to be loaded, got %+v %v", item, err)
+	}
+	if len(orm.calls) != 0 {
+		t.Fatalf("expected no queries, got %v", orm.calls)
+	}
+
+	if err := o.Save(&Tenant{Name: "a"}); err != nil {
+		t.Fatalf("expected struct that is not scoped to be saved, got %s", err)
+	}
+}
+
+// testTenantSaveORM records the writes made when saving scoped objects
+type testTenantSaveORM struct {
+	testORM
+	updated int64
+	columns map[string]interface{}
+	values  map[string]interface{}
+	filters map[string]interface{}
+}
+
+func (o *testTenantSaveORM) GetObjIDValue(obj interface{}) int64 {
+	return reflect.ValueOf(obj).Elem().FieldByName("ID").Int()
+}
+
+func (o *testTenantSaveORM) insertWithColumns(obj interface{}, columns map[string]interface{}) error {
+	o.columns = columns
+	reflect.ValueOf(obj).Elem().FieldByName("ID").SetInt(7)
+	return nil
+}
+
+func (o *testTenantSaveORM) updateFields(obj interface{}, values map[string]interface{}, filters map[string]interface{}) (int64, error) {
+	o.values = values
+	o.filters = filters
+	return o.updated, nil
+}
+
+func TestTenantORMSave(t *testing.T) {
+	orm := &testTenantSaveORM{}
+	o := &tenantORM{orm: orm, t: &tenancy{scoped: map[string]bool{"testTenantItem": true}}, tenantID: 3}
+
+	item := &testTenantItem{Name: "a", Price: 5}
+	if err := o.Save(item); err != nil {
+		t.Fatalf("error saving item: %s", err)
+	}
+	if item.ID != 7 || !reflect.DeepEqual(orm.columns, map[string]interface{}{"tenant_id": int64(3)}) {
+		t.Fatalf("expected item to be inserted with tenant column, got %+v %v", item, orm.columns)
+	}
+
+	// item of another tenant is not updated
+	err := o.Save(item)
+	if !errors.Is(err, errTenantNotAllowed) {
+		t.Fatalf("expected update to be denied, got %v", err)
+	}
+	if !reflect.DeepEqual(orm.values, map[string]interface{}{"Flags": int64(0), "Name": "a", "Price": 5}) {
+		t.Fatalf("unexpected values: %v", orm.values)
+	}
+	if !reflect.DeepEqual(orm.filters, map[string]interface{}{"ID": int64(7), "_raw": []interface{}{"tenant_id = ?", int64(3)}}) {
+		t.Fatalf("unexpected filters: %v", orm.filters)
+	}
+
+	orm.updated = 1
+	if err := o.Save(item); err != nil {
+		t.Fatalf("error updating item: %s", err)
+	}
+	if err := o.Save(&testTenantItem{}); err == nil {
+		t.Fatal("expected invalid item not to be saved")
+	}
+	if len(orm.calls) != 0 {
+		t.Fatalf("expected writes not to use save, got %v", orm.calls)
+	}
+}
+
+func TestNewTenantHandler(t *testing.T) {
+	p := &Prototype{orm: &testORM{}, tenancy: &tenancy{scoped: map[string]bool{}}}
+	created := map[int64]int{}
+	h := p.newTenantHandler(func(orm ORM) http.Handler {
+		created[orm.(*tenantORM).tenantID]++
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	})
+	for _, tenantID := range []int64{1, 2, 1, 1, 2} {
+		r := httptest.NewRequest(http.MethodGet, "/api/Item/", nil)
+		h.ServeHTTP(httptest.NewRecorder(), r.WithContext(context.WithValue(r.Context(), tenantContextKey, tenantID)))
+	}
+	if !reflect.DeepEqual(created, map[int64]int{1: 1, 2: 1}) {
+		t.Fatalf("expected handler to be created once for every tenant, got %v", created)
+	}
+}
+
+func TestTenantORMFilters(t *testing.T) {
+	o := &tenantORM{tenantID: 3}
+	f, err := o.getFilters(map[string]interface{}{"Name": "a"})
+	if err != nil {
+		t.Fatalf("error getting filters: %s", err)
+	}
+	expected := map[string]interface{}{"Name": "a", "_raw": []interface{}{"tenant_id = ?", int64(3)}}
+	if !reflect.DeepEqual(f, expected) {
+		t.Fatalf("expected %v, got %v", expected, f)
+	}
+
+	f, _ = o.getFilters(map[string]interface{}{"_raw": []interface{}{"price > ?", 5}})
+	expected = map[string]interface{}{"_raw": []interface{}{"(price > ?) AND tenant_id = ?", 5, int64(3)}}
+	if !reflect.DeepEqual(f, expected) {
+		t.Fatalf("expected %v, got %v", expected, f)
+	}
+
+	_, err = o.getFilters(map[string]interface{}{"_raw": []interface{}{"price > ?", 5}, "_rawConjuction": sqldb.RawConjuctionOR})
+	if err == nil {
+		t.Fatal("expected error with or conjunction")
+	}
+}
+
+func TestGetColumnsAndValues(t *testing.T) {
+	item := &testTenantItem{ID: 5, Flags: 1, Name: "a", Price: 10}
+	s := sqldb.NewStructSQL(item, sqldb.StructSQLOptions{TagName: "ui"})
+	cols, vals, err := getColumnsAndValues(s.GetQueryInsert(), item)
+	if err != nil {
+		t.Fatalf("error getting columns: %s", err)
+	}
+	if !reflect.DeepEqual(cols, []string{"test_tenant_item_flags", "name", "price"}) {
+		t.Fatalf("unexpected columns: %v", cols)
+	}
+	if !reflect.DeepEqual(vals, []interface{}{int64(1), "a", 10}) {
+		t.Fatalf("unexpected values: %v", vals)
+	}
+}
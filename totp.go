@@ -0,0 +1,641 @@
+package prototyping
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpIssuer        = "Prototyping"
+	totpRecoveryCodes = 10
+	// totpRecoveryCodeBytes is the number of random bytes in a recovery code, which is encoded to 16 base32 characters
+	totpRecoveryCodeBytes = 10
+	// loginMaxBodySize limits body of login requests that is read before they are passed to the login handler
+	loginMaxBodySize      = 1 << 16
+	totpMaxAttempts       = 5
+	totpPendingCookieName = "ProtoTOTPPending"
+)
+
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// getTOTPCode returns code for a specific time step as described in RFC 6238
+func getTOTPCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid secret: %w", err)
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000), nil
+}
+
+// verifyTOTPCode checks code against the current time step and the adjacent ones. Steps not greater than
+// lastUsedStep are rejected so that a code cannot be used twice. Matched step is returned.
+func verifyTOTPCode(secret string, code string, lastUsedStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := time.Now().Unix() / totpPeriod
+	for _, step := range []int64{current - 1, current, current + 1} {
+		if step <= lastUsedStep {
+			continue
+		}
+		expected, err := getTOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// normalizeRecoveryCode removes separators so that code can be typed in any case, with or without dashes
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// generateRecoveryCodes returns plain codes to be shown to the user and their bcrypt hashes to be stored
+func generateRecoveryCodes() ([]string, string, error) {
+	codes := make([]string, totpRecoveryCodes)
+	hashes := make([]string, totpRecoveryCodes)
+	for i := range codes {
+		b := make([]byte, totpRecoveryCodeBytes)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, "", err
+		}
+		s := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+		codes[i] = fmt.Sprintf("%s-%s-%s-%s", s[0:4], s[4:8], s[8:12], s[12:16])
+		h, err := bcrypt.GenerateFromPassword([]byte(s), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, "", err
+		}
+		hashes[i] = string(h)
+	}
+	return codes, strings.Join(hashes, ","), nil
+}
+
+// verifyRecoveryCode returns index of the hash that matches code, or -1
+func verifyRecoveryCode(hashes []string, code string) int {
+	code = normalizeRecoveryCode(code)
+	if len(code) != base32.StdEncoding.WithPadding(base32.NoPadding).EncodedLen(totpRecoveryCodeBytes) {
+		return -1
+	}
+	for i, h := range hashes {
+		if h != "" && bcrypt.CompareHashAndPassword([]byte(h), []byte(code)) == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+func getTOTPURL(secret string, account string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("digits", fmt.Sprintf("%d", totpDigits))
+	v.Set("period", fmt.Sprintf("%d", totpPeriod))
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(totpIssuer), url.PathEscape(account), v.Encode())
+}
+
+// getUserTOTP returns two-factor settings of a user or nil when there are none
+func (p *Prototype) getUserTOTP(ctx context.Context, userID int64) (*UserTOTP, error) {
+	rows, err := p.ormWithContext(ctx).Get(func() interface{} { return &UserTOTP{} }, []string{"ID", "asc"}, 1, 0, map[string]interface{}{"UserID": userID}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user totp: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return rows[0].(*UserTOTP), nil
+}
+
+// verifySecondFactor checks TOTP or a recovery code of a user. Used step and recovery code are saved with
+// conditional updates, so that a code accepted by two concurrent logins is only accepted for the first of them.
+func (p *Prototype) verifySecondFactor(ctx context.Context, tu *UserTOTP, code string) (bool, error) {
+	if tu == nil || tu.Flags&UserTOTPFlagEnabled == 0 || tu.Secret == "" {
+		return false, nil
+	}
+	orm := p.ormWithContext(ctx)
+
+	if step, ok := verifyTOTPCode(tu.Secret, code, tu.LastUsedStep); ok {
+		cnt, err := updateFields(orm, tu, map[string]interface{}{"LastUsedStep": step}, map[string]interface{}{"ID": tu.ID, "LastUsedStep:<": step})
+		if err != nil || cnt == 0 {
+			return false, err
+		}
+		tu.LastUsedStep = step
+		return true, nil
+	}
+
+	hashes := strings.Split(tu.RecoveryCodes, ",")
+	if i := verifyRecoveryCode(hashes, code); i >= 0 {
+		codes := strings.Join(append(append([]string{}, hashes[:i]...), hashes[i+1:]...), ",")
+		cnt, err := updateFields(orm, tu, map[string]interface{}{"RecoveryCodes": codes}, map[string]interface{}{"ID": tu.ID, "RecoveryCodes": tu.RecoveryCodes})
+		if err != nil || cnt == 0 {
+			return false, err
+		}
+		tu.RecoveryCodes = codes
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// isTwoFactorEnrolmentRequired returns true when user has to enable two-factor authentication before accessing
+// anything
+func (p *Prototype) isTwoFactorEnrolmentRequired(lu *loggedUser) bool {
+	return (p.twoFactorRequired || lu.twoFactorRequired) && !lu.twoFactorEnabled
+}
+
+// loginForm contains values of a login request that are needed before passing it to the login handler
+type loginForm struct {
+	Email     string `json:"email"`
+	TOTPCode  string `json:"totp_code"`
+	CSRFToken string `json:"csrf_token"`
+}
+
+// getLoginForm returns values sent to a login handler, from either a JSON body or a form, read the same way as the
+// login handler does. Body is restored so that the login handler can read it again.
+func getLoginForm(w http.ResponseWriter, r *http.Request) (*loginForm, error) {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, loginMaxBodySize))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("error reading body: %w", err)
+	}
+
+	f := &loginForm{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		json.Unmarshal(b, f)
+		return f, nil
+	}
+
+	c := r.Clone(r.Context())
+	c.Body = io.NopCloser(bytes.NewReader(b))
+	f.Email = c.FormValue("email")
+	f.TOTPCode = c.FormValue("totp_code")
+	f.CSRFToken = c.FormValue(csrfFieldName)
+	return f, nil
+}
+
+// writeLoginBodyError responds to a login request which body could not be read
+func writeLoginBodyError(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	w.Write([]byte("RequestTooLarge"))
+}
+
+// getTOTPForEmail returns two-factor settings of the user with a specific email, when they are enabled
+func (p *Prototype) getTOTPForEmail(ctx context.Context, email string) (*UserTOTP, error) {
+	if email == "" {
+		return nil, nil
+	}
+	user := p.getUserInterface(ctx)
+	found, err := user.GetByEmail(email)
+	if err != nil || !found {
+		return nil, err
+	}
+	tu, err := p.getUserTOTP(ctx, user.GetID())
+	if err != nil || tu == nil || tu.Flags&UserTOTPFlagEnabled == 0 {
+		return nil, err
+	}
+	return tu, nil
+}
+
+// bufferedResponse keeps response of a handler so that it can be sent later or dropped
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(d []byte) (int, error) { return b.body.Write(d) }
+func (b *bufferedResponse) WriteHeader(code int)        { b.status = code }
+
+func (b *bufferedResponse) flush(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
+
+type totpPendingLogin struct {
+	userID    int64
+	email     string
+	cookies   []string
+	attempts  int
+	expiresAt time.Time
+}
+
+// totpPendingLogins keeps cookies set by a successful password login until the second factor is verified
+type totpPendingLogins struct {
+	mu     sync.Mutex
+	logins map[string]*totpPendingLogin
+}
+
+func newTOTPPendingLogins() *totpPendingLogins {
+	return &totpPendingLogins{
+		logins: map[string]*totpPendingLogin{},
+	}
+}
+
+func (t *totpPendingLogins) add(userID int64, email string, cookies []string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for id, l := range t.logins {
+		if now.After(l.expiresAt) {
+			delete(t.logins, id)
+		}
+	}
+	id, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	t.logins[id] = &totpPendingLogin{
+		userID:    userID,
+		email:     email,
+		cookies:   cookies,
+		expiresAt: now.Add(5 * time.Minute),
+	}
+	return id, nil
+}
+
+// get returns pending login and counts an attempt, login is removed after too many attempts
+func (t *totpPendingLogins) get(id string) *totpPendingLogin {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.logins[id]
+	if !ok {
+		return nil
+	}
+	l.attempts++
+	if time.Now().After(l.expiresAt) || l.attempts > totpMaxAttempts {
+		delete(t.logins, id)
+		return nil
+	}
+	return l
+}
+
+func (t *totpPendingLogins) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.logins, id)
+}
+
+// wrapLoginHandlerWithTOTP holds a successful password login of a user with two-factor authentication enabled and
+// redirects to the page where code has to be entered
+func (p *Prototype) wrapLoginHandlerWithTOTP(successRedirectURL string, totpURI string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		form, err := getLoginForm(w, r)
+		if err != nil {
+			writeLoginBodyError(w)
+			return
+		}
+		buf := &bufferedResponse{header: http.Header{}}
+		h.ServeHTTP(buf, r)
+		if buf.header.Get("Location") != successRedirectURL {
+			buf.flush(w)
+			return
+		}
+
+		tu, err := p.getTOTPForEmail(r.Context(), form.Email)
+		if err != nil {
+			p.logger.Error("error getting user totp", slog.Any("error", err))
+			w.Header().Set("Location", "/ui/login/")
+			w.WriteHeader(http.StatusSeeOther)
+			return
+		}
+		if tu == nil {
+			buf.flush(w)
+			return
+		}
+
+		id, err := p.totpPending.add(tu.UserID, form.Email, buf.header.Values("Set-Cookie"))
+		if err != nil {
+			p.logger.Error("error adding pending totp login", slog.Any("error", err))
+			w.Header().Set("Location", "/ui/login/")
+			w.WriteHeader(http.StatusSeeOther)
+			return
+		}
+		err = p.setSignedCookie(w, totpPendingCookieName, p.uriUI, &signedValue{
+			ExpiresAt: time.Now().Add(5 * time.Minute).Unix(),
+			Values:    map[string]string{"id": id},
+		})
+		if err != nil {
+			p.logger.Error("error setting totp cookie", slog.Any("error", err))
+		}
+		w.Header().Set("Location", totpURI)
+		w.WriteHeader(http.StatusSeeOther)
+	})
+}
+
+// wrapUmbrellaLoginWithTOTP requires a valid totp_code (or X-TOTP-Code header) when getting a token for a user with
+// two-factor authentication enabled
+func (p *Prototype) wrapUmbrellaLoginWithTOTP(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isUmbrellaLoginRequest(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		form, err := getLoginForm(w, r)
+		if err != nil {
+			writeLoginBodyError(w)
+			return
+		}
+		tu, err := p.getTOTPForEmail(r.Context(), form.Email)
+		if err != nil {
+			p.logger.Error("error getting user totp", slog.Any("error", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if tu == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// password is checked first so that neither the code is consumed nor two-factor authentication is revealed
+		// by a request with invalid credentials
+		buf := &bufferedResponse{header: http.Header{}}
+		h.ServeHTTP(buf, r)
+		if buf.status != 0 && buf.status != http.StatusOK && buf.status != http.StatusCreated {
+			buf.flush(w)
+			return
+		}
+
+		code := r.Header.Get("X-TOTP-Code")
+		if code == "" {
+			code = form.TOTPCode
+		}
+		if code == "" {
+			writeTOTPRequired(w)
+			return
+		}
+
+		ok, err := p.verifySecondFactor(r.Context(), tu, code)
+		if err != nil {
+			p.logger.Error("error verifying totp", slog.Any("error", err))
+		}
+		if !ok {
+			writeTOTPRequired(w)
+			return
+		}
+		buf.flush(w)
+	})
+}
+
+func writeTOTPRequired(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`{"ok":0,"err_text":"totp_required"}`))
+}
+
+var totpTemplate = template.Must(template.New("totp").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Two-factor authentication</title></head>
+<body>
+<h1>Two-factor authentication</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+{{if .RecoveryCodes}}
+<p>Two-factor authentication is enabled. Store the recovery codes in a safe place, they will not be shown again:</p>
+<pre>{{range .RecoveryCodes}}{{.}}
+{{end}}</pre>
+<p><a href="/ui/">Continue</a></p>
+{{else if .Enabled}}
+<p>Two-factor authentication is enabled.</p>
+{{if not .Required}}
+<form method="post" action="{{.URI}}">
+<input type="hidden" name="action" value="disable">
+<p><label>Code <input type="text" name="code" autocomplete="one-time-code" required></label></p>
+<p><input type="submit" value="Disable"></p>
+</form>
+{{end}}
+{{else if .QRCode}}
+<p>Scan the QR code with an authenticator app, or enter the secret manually, then type the generated code.</p>
+<p><img src="{{.QRCode}}" alt="QR code"></p>
+<p><code>{{.Secret}}</code></p>
+<form method="post" action="{{.URI}}">
+<input type="hidden" name="action" value="enable">
+<p><label>Code <input type="text" name="code" autocomplete="one-time-code" required></label></p>
+<p><input type="submit" value="Enable"></p>
+</form>
+{{else}}
+<form method="post" action="{{.URI}}">
+<p><label>Code or recovery code <input type="text" name="code" autocomplete="one-time-code" required autofocus></label></p>
+<p><input type="submit" value="Verify"></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+func writeTOTPPage(w http.ResponseWriter, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	totpTemplate.Execute(w, data)
+}
+
+// getTOTPHTTPHandler returns handler of the second login step where user enters the code
+func (p *Prototype) getTOTPHTTPHandler(uri string, successRedirectURL string, failureRedirectURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pendingCookie := p.getSignedCookie(r, totpPendingCookieName)
+		if pendingCookie == nil {
+			http.Redirect(w, r, failureRedirectURL, http.StatusSeeOther)
+			return
+		}
+
+		data := map[string]interface{}{"URI": uri}
+		if r.Method != http.MethodPost {
+			writeTOTPPage(w, data)
+			return
+		}
+
+		id := pendingCookie.Values["id"]
+		pending := p.totpPending.get(id)
+		if pending == nil {
+			clearCookie(w, totpPendingCookieName, p.uriUI)
+			http.Redirect(w, r, failureRedirectURL, http.StatusSeeOther)
+			return
+		}
+
+		// failed codes count towards the same lockout as failed passwords
+		if lockout := p.getLoginLockout(pending.email); lockout > 0 {
+			p.totpPending.remove(id)
+			clearCookie(w, totpPendingCookieName, p.uriUI)
+			writeTooManyRequests(w, lockout)
+			return
+		}
+
+		tu, err := p.getUserTOTP(r.Context(), pending.userID)
+		if err != nil {
+			p.logger.Error("error getting user totp", slog.Any("error", err))
+		}
+		ok, err := p.verifySecondFactor(r.Context(), tu, r.PostFormValue("code"))
+		if err != nil {
+			p.logger.Error("error verifying totp", slog.Any("error", err))
+		}
+		p.registerLoginResult(pending.email, ok)
+		if !ok {
+			data["Error"] = "Invalid code"
+			writeTOTPPage(w, data)
+			return
+		}
+
+		p.totpPending.remove(id)
+		clearCookie(w, totpPendingCookieName, p.uriUI)
+		for _, c := range pending.cookies {
+			w.Header().Add("Set-Cookie", c)
+		}
+		http.Redirect(w, r, successRedirectURL, http.StatusSeeOther)
+	})
+}
+
+// getTOTPSetupHTTPHandler returns handler of a page where logged user enables or disables two-factor authentication
+func (p *Prototype) getTOTPSetupHTTPHandler(uri string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lu := getLoggedUserFromContext(r.Context())
+		if lu == nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		tu, err := p.getUserTOTP(r.Context(), lu.id)
+		if err != nil {
+			p.logger.Error("error getting user totp", slog.Any("error", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if tu == nil {
+			tu = &UserTOTP{UserID: lu.id, CreatedAt: time.Now().Unix(), CreatedBy: lu.id}
+		}
+
+		data := map[string]interface{}{
+			"URI":      uri,
+			"Enabled":  tu.Flags&UserTOTPFlagEnabled > 0,
+			"Required": p.twoFactorRequired || tu.Flags&UserTOTPFlagRequired > 0,
+		}
+
+		if r.Method == http.MethodPost {
+			err = p.handleTOTPSetupForm(r, tu, data)
+			if err != nil {
+				p.logger.Error("error with totp setup", slog.Any("error", err))
+				data["Error"] = "Error saving two-factor authentication settings"
+			}
+		}
+
+		if tu.Flags&UserTOTPFlagEnabled == 0 && data["RecoveryCodes"] == nil {
+			if tu.Secret == "" {
+				tu.Secret, err = generateTOTPSecret()
+				if err == nil {
+					err = p.ormWithContext(r.Context()).Save(tu)
+				}
+				if err != nil {
+					p.logger.Error("error saving totp secret", slog.Any("error", err))
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+			}
+			png, err := qrcode.Encode(getTOTPURL(tu.Secret, fmt.Sprintf("%d", lu.id)), qrcode.Medium, 256)
+			if err != nil {
+				p.logger.Error("error generating qr code", slog.Any("error", err))
+			}
+			data["QRCode"] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+			data["Secret"] = tu.Secret
+		}
+
+		writeTOTPPage(w, data)
+	})
+}
+
+func (p *Prototype) handleTOTPSetupForm(r *http.Request, tu *UserTOTP, data map[string]interface{}) error {
+	code := r.PostFormValue("code")
+	switch r.PostFormValue("action") {
+	case "enable":
+		if tu.Flags&UserTOTPFlagEnabled > 0 || tu.Secret == "" {
+			return nil
+		}
+		step, ok := verifyTOTPCode(tu.Secret, code, tu.LastUsedStep)
+		if !ok {
+			data["Error"] = "Invalid code"
+			return nil
+		}
+		codes, hashes, err := generateRecoveryCodes()
+		if err != nil {
+			return err
+		}
+		tu.Flags |= UserTOTPFlagEnabled
+		tu.LastUsedStep = step
+		tu.RecoveryCodes = hashes
+		tu.LastModifiedAt = time.Now().Unix()
+		tu.LastModifiedBy = tu.UserID
+		data["RecoveryCodes"] = codes
+		data["Enabled"] = true
+		return p.ormWithContext(r.Context()).Save(tu)
+	case "disable":
+		if data["Required"].(bool) {
+			return nil
+		}
+		ok, err := p.verifySecondFactor(r.Context(), tu, code)
+		if err != nil || !ok {
+			data["Error"] = "Invalid code"
+			return err
+		}
+		tu.Flags &^= UserTOTPFlagEnabled
+		tu.Secret = ""
+		tu.RecoveryCodes = ""
+		tu.LastModifiedAt = time.Now().Unix()
+		tu.LastModifiedBy = tu.UserID
+		data["Enabled"] = false
+		return p.ormWithContext(r.Context()).Save(tu)
+	}
+	return nil
+}
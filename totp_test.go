@@ -0,0 +1,213 @@
+package prototyping
+
+import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key of the test vectors in RFC 6238 appendix B
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestGetTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 lists 8-digit codes, the 6-digit ones are their last digits
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		code, err := getTOTPCode(rfc6238Secret, tt.time/totpPeriod)
+		if err != nil {
+			t.Fatalf("error getting code: %s", err)
+		}
+		if code != tt.code[2:] {
+			t.Errorf("time %d: expected %s, got %s", tt.time, tt.code[2:], code)
+		}
+	}
+}
+
+func TestGetTOTPCodeLowercaseSecret(t *testing.T) {
+	code, err := getTOTPCode(strings.ToLower(rfc6238Secret), 1)
+	if err != nil || code != "287082" {
+		t.Fatalf("expected 287082, got %s (%v)", code, err)
+	}
+	_, err = getTOTPCode("not base32!", 1)
+	if err == nil {
+		t.Fatal("expected error with invalid secret")
+	}
+}
+
+func TestVerifyTOTPCode(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatalf("error generating secret: %s", err)
+	}
+	current := time.Now().Unix() / totpPeriod
+	code, _ := getTOTPCode(secret, current)
+
+	step, ok := verifyTOTPCode(secret, " "+code+" ", 0)
+	if !ok || step != current {
+		t.Fatalf("expected code to match step %d, got %d %v", current, step, ok)
+	}
+	if _, ok := verifyTOTPCode(secret, code, step); ok {
+		t.Fatal("expected used code to be rejected")
+	}
+
+	old, _ := getTOTPCode(secret, current-5)
+	if _, ok := verifyTOTPCode(secret, old, 0); ok && old != code {
+		t.Fatal("expected code of an old step to be rejected")
+	}
+	if _, ok := verifyTOTPCode(secret, "12345", 0); ok {
+		t.Fatal("expected code with invalid length to be rejected")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("error generating recovery codes: %s", err)
+	}
+	if len(codes) != totpRecoveryCodes {
+		t.Fatalf("expected %d codes, got %d", totpRecoveryCodes, len(codes))
+	}
+	if strings.Contains(hashes, codes[0]) || strings.Contains(hashes, normalizeRecoveryCode(codes[0])) {
+		t.Fatal("expected codes not to be stored in plain text")
+	}
+	if len(hashes) > 1000 {
+		t.Fatalf("hashes do not fit the column: %d", len(hashes))
+	}
+
+	list := strings.Split(hashes, ",")
+	for i, c := range []string{codes[0], strings.ToLower(codes[3]), strings.ReplaceAll(codes[9], "-", "")} {
+		expected := []int{0, 3, 9}[i]
+		if got := verifyRecoveryCode(list, c); got != expected {
+			t.Errorf("code %s: expected index %d, got %d", c, expected, got)
+		}
+	}
+	if verifyRecoveryCode(list, "AAAA-AAAA-AAAA-AAAA") != -1 {
+		t.Error("expected invalid code to be rejected")
+	}
+	if verifyRecoveryCode(list, "123456") != -1 {
+		t.Error("expected totp code not to match a recovery code")
+	}
+}
+
+// testUpdateORM records conditional updates and returns the set number of updated rows
+type testUpdateORM struct {
+	testORM
+	updated int64
+	filters []map[string]interface{}
+}
+
+func (o *testUpdateORM) updateFields(obj interface{}, values map[string]interface{}, filters map[string]interface{}) (int64, error) {
+	o.filters = append(o.filters, filters)
+	return o.updated, nil
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	orm := &testUpdateORM{updated: 1}
+	p := &Prototype{orm: orm}
+
+	secret, _ := generateTOTPSecret()
+	codes, hashes, _ := generateRecoveryCodes()
+	tu := &UserTOTP{ID: 3, Flags: UserTOTPFlagEnabled, Secret: secret, RecoveryCodes: hashes}
+
+	ok, err := p.verifySecondFactor(context.Background(), tu, codes[1])
+	if err != nil || !ok {
+		t.Fatalf("expected recovery code to be accepted, got %v %v", ok, err)
+	}
+	if len(strings.Split(tu.RecoveryCodes, ",")) != totpRecoveryCodes-1 {
+		t.Fatal("expected used recovery code to be removed")
+	}
+	if orm.filters[0]["ID"] != int64(3) || orm.filters[0]["RecoveryCodes"] != hashes {
+		t.Fatalf("expected update conditioned on the previous codes, got %v", orm.filters[0])
+	}
+	ok, _ = p.verifySecondFactor(context.Background(), tu, codes[1])
+	if ok {
+		t.Fatal("expected used recovery code to be rejected")
+	}
+
+	step := time.Now().Unix() / totpPeriod
+	code, _ := getTOTPCode(secret, step)
+	ok, err = p.verifySecondFactor(context.Background(), tu, code)
+	if err != nil || !ok {
+		t.Fatalf("expected totp code to be accepted, got %v %v", ok, err)
+	}
+	if f := orm.filters[len(orm.filters)-1]; f["LastUsedStep:<"] != tu.LastUsedStep || tu.LastUsedStep < step-1 {
+		t.Fatalf("expected update conditioned on the last used step, got %v", f)
+	}
+
+	tu.Flags = 0
+	if ok, _ := p.verifySecondFactor(context.Background(), tu, codes[2]); ok {
+		t.Fatal("expected code to be rejected when two-factor authentication is disabled")
+	}
+
+	tu = &UserTOTP{Flags: UserTOTPFlagEnabled, RecoveryCodes: hashes}
+	if ok, _ := p.verifySecondFactor(context.Background(), tu, codes[2]); ok {
+		t.Fatal("expected code to be rejected when secret is not set")
+	}
+}
+
+func TestVerifySecondFactorUsedConcurrently(t *testing.T) {
+	// another login has already saved the step or removed the code
+	p := &Prototype{orm: &testUpdateORM{}}
+
+	secret, _ := generateTOTPSecret()
+	codes, hashes, _ := generateRecoveryCodes()
+	tu := &UserTOTP{Flags: UserTOTPFlagEnabled, Secret: secret, RecoveryCodes: hashes}
+
+	code, _ := getTOTPCode(secret, time.Now().Unix()/totpPeriod)
+	if ok, err := p.verifySecondFactor(context.Background(), tu, code); ok || err != nil {
+		t.Fatalf("expected totp code to be rejected, got %v %v", ok, err)
+	}
+	if ok, err := p.verifySecondFactor(context.Background(), tu, codes[0]); ok || err != nil {
+		t.Fatalf("expected recovery code to be rejected, got %v %v", ok, err)
+	}
+	if tu.RecoveryCodes != hashes || tu.LastUsedStep != 0 {
+		t.Fatal("expected settings not to be changed")
+	}
+}
+
+func TestGetLoginForm(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/umbrella/login", strings.NewReader(`{"EMAIL":"a@example.com","totp_code":"123456"}`))
+	r.Header.Set("Content-Type", "application/json")
+	f, err := getLoginForm(httptest.NewRecorder(), r)
+	if err != nil || f.Email != "a@example.com" || f.TOTPCode != "123456" {
+		t.Fatalf("invalid json form: %+v %v", f, err)
+	}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	mw.WriteField("email", "b@example.com")
+	mw.Close()
+	r = httptest.NewRequest(http.MethodPost, "/ui/r/login/", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	f, err = getLoginForm(httptest.NewRecorder(), r)
+	if err != nil || f.Email != "b@example.com" {
+		t.Fatalf("invalid multipart form: %+v %v", f, err)
+	}
+	r.ParseMultipartForm(loginMaxBodySize)
+	if r.PostFormValue("email") != "b@example.com" {
+		t.Fatal("expected body to be restored for the login handler")
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/ui/r/login/", strings.NewReader("email=c%40example.com&x="+strings.Repeat("a", loginMaxBodySize)))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err = getLoginForm(httptest.NewRecorder(), r)
+	if err == nil {
+		t.Fatal("expected error with too large body")
+	}
+}
@@ -0,0 +1,218 @@
+package prototyping
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/mikolajgs/prototyping"
+
+// newTracerProvider creates a provider that exports spans over OTLP/HTTP to the specified endpoint
+func newTracerProvider(endpoint string, insecure bool) (*sdktrace.TracerProvider, error) {
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpoint),
+	}
+	if insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating otlp exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("prototyping"),
+		semconv.ServiceVersion(VERSION),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("error creating otel resource: %w", err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+func newNoopTracer() trace.Tracer {
+	return noop.NewTracerProvider().Tracer(tracerName)
+}
+
+// wrapHandlerWithTracing starts a server span for each request, continuing the trace from incoming headers. Span is
+// passed down in the request context.
+func (p *Prototype) wrapHandlerWithTracing(routeType string, h http.Handler) http.Handler {
+	propagator := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := p.tracer.Start(ctx, fmt.Sprintf("%s %s", r.Method, routeType),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				attribute.String("prototyping.route", routeType),
+			),
+		)
+		defer span.End()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.getStatus()))
+		if rec.getStatus() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.getStatus()))
+		}
+	})
+}
+
+// ormWithContext returns ORM which spans are children of the one in ctx
+func (p *Prototype) ormWithContext(ctx context.Context) ORM {
+	if o, ok := p.orm.(*tracingORM); ok {
+		return o.withContext(ctx)
+	}
+	return p.orm
+}
+
+// ormContextKey holds ORM of the request, which might be scoped to a tenant
+const ormContextKey = contextKey("orm")
+
+// getRequestORM returns ORM that wrapHandlerWithTenant put into the request context, or the one which spans are
+// children of the one in ctx
+func (p *Prototype) getRequestORM(ctx context.Context) ORM {
+	if o, ok := ctx.Value(ormContextKey).(ORM); ok {
+		return o
+	}
+	return p.ormWithContext(ctx)
+}
+
+// tracingORM is an ORM that creates a span for every call made to the wrapped ORM. ORM interface does not take
+// a context so spans are created under the one set with withContext, or as root spans.
+type tracingORM struct {
+	orm    ORM
+	tracer trace.Tracer
+	ctx    context.Context
+}
+
+func (o *tracingORM) withContext(ctx context.Context) *tracingORM {
+	return &tracingORM{
+		orm:    o.orm,
+		tracer: o.tracer,
+		ctx:    ctx,
+	}
+}
+
+func (o *tracingORM) start(method string) trace.Span {
+	ctx := o.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := o.tracer.Start(ctx, fmt.Sprintf("ORM.%s", method), trace.WithSpanKind(trace.SpanKindClient))
+	return span
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (o *tracingORM) SetDatabase(dbConn *sql.DB, tblPrefix string) {
+	o.orm.SetDatabase(dbConn, tblPrefix)
+}
+
+func (o *tracingORM) RegisterStruct(obj interface{}, inheritFromObj interface{}, overwriteExisting bool, forceNameForDB string, useOnlyRootFromInheritedObj bool) error {
+	span := o.start("RegisterStruct")
+	err := o.orm.RegisterStruct(obj, inheritFromObj, overwriteExisting, forceNameForDB, useOnlyRootFromInheritedObj)
+	endSpan(span, err)
+	return err
+}
+
+func (o *tracingORM) CreateTables(objs ...interface{}) error {
+	span := o.start("CreateTables")
+	err := o.orm.CreateTables(objs...)
+	endSpan(span, err)
+	return err
+}
+
+func (o *tracingORM) Load(obj interface{}, id string) error {
+	span := o.start("Load")
+	err := o.orm.Load(obj, id)
+	endSpan(span, err)
+	return err
+}
+
+func (o *tracingORM) Save(obj interface{}) error {
+	span := o.start("Save")
+	err := o.orm.Save(obj)
+	endSpan(span, err)
+	return err
+}
+
+func (o *tracingORM) Delete(obj interface{}) error {
+	span := o.start("Delete")
+	err := o.orm.Delete(obj)
+	endSpan(span, err)
+	return err
+}
+
+func (o *tracingORM) DeleteMultiple(obj interface{}, filters map[string]interface{}) error {
+	span := o.start("DeleteMultiple")
+	err := o.orm.DeleteMultiple(obj, filters)
+	endSpan(span, err)
+	return err
+}
+
+func (o *tracingORM) Get(newObjFunc func() interface{}, order []string, limit int, offset int, filters map[string]interface{}, rowObjTransformFunc func(interface{}) interface{}) ([]interface{}, error) {
+	span := o.start("Get")
+	xobj, err := o.orm.Get(newObjFunc, order, limit, offset, filters, rowObjTransformFunc)
+	endSpan(span, err)
+	return xobj, err
+}
+
+func (o *tracingORM) GetCount(newObjFunc func() interface{}, filters map[string]interface{}) (int64, error) {
+	span := o.start("GetCount")
+	cnt, err := o.orm.GetCount(newObjFunc, filters)
+	endSpan(span, err)
+	return cnt, err
+}
+
+func (o *tracingORM) updateFields(obj interface{}, values map[string]interface{}, filters map[string]interface{}) (int64, error) {
+	span := o.start("UpdateFields")
+	cnt, err := updateFields(o.orm, obj, values, filters)
+	endSpan(span, err)
+	return cnt, err
+}
+
+func (o *tracingORM) insertWithColumns(obj interface{}, columns map[string]interface{}) error {
+	span := o.start("InsertWithColumns")
+	err := insertWithColumns(o.orm, obj, columns)
+	endSpan(span, err)
+	return err
+}
+
+func (o *tracingORM) GetFieldNameFromDBCol(obj interface{}, field string) (string, error) {
+	return o.orm.GetFieldNameFromDBCol(obj, field)
+}
+
+func (o *tracingORM) GetObjIDValue(obj interface{}) int64 {
+	return o.orm.GetObjIDValue(obj)
+}
+
+func (o *tracingORM) ResetFields(obj interface{}) {
+	o.orm.ResetFields(obj)
+}
@@ -0,0 +1,201 @@
+package prototyping
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// testORM is an in-memory ORM that only counts the calls
+type testORM struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (o *testORM) call(method string) {
+	o.mu.Lock()
+	o.calls = append(o.calls, method)
+	o.mu.Unlock()
+}
+
+func (o *testORM) SetDatabase(dbConn *sql.DB, tblPrefix string) {}
+func (o *testORM) RegisterStruct(obj interface{}, inheritFromObj interface{}, overwriteExisting bool, forceNameForDB string, useOnlyRootFromInheritedObj bool) error {
+	return nil
+}
+func (o *testORM) CreateTables(objs ...interface{}) error { o.call("CreateTables"); return nil }
+func (o *testORM) Load(obj interface{}, id string) error  { o.call("Load"); return nil }
+func (o *testORM) Save(obj interface{}) error             { o.call("Save"); return nil }
+func (o *testORM) Delete(obj interface{}) error           { o.call("Delete"); return nil }
+func (o *testORM) DeleteMultiple(obj interface{}, filters map[string]interface{}) error {
+	o.call("DeleteMultiple")
+	return nil
+}
+func (o *testORM) Get(newObjFunc func() interface{}, order []string, limit int, offset int, filters map[string]interface{}, rowObjTransformFunc func(interface{}) interface{}) ([]interface{}, error) {
+	o.call("Get")
+	return nil, nil
+}
+func (o *testORM) GetCount(newObjFunc func() interface{}, filters map[string]interface{}) (int64, error) {
+	o.call("GetCount")
+	return 0, nil
+}
+func (o *testORM) updateFields(obj interface{}, values map[string]interface{}, filters map[string]interface{}) (int64, error) {
+	o.call("UpdateFields")
+	return 1, nil
+}
+func (o *testORM) GetFieldNameFromDBCol(obj interface{}, field string) (string, error) {
+	return field, nil
+}
+func (o *testORM) GetObjIDValue(obj interface{}) int64 { return 0 }
+func (o *testORM) ResetFields(obj interface{}) {
+	v := reflect.ValueOf(obj).Elem()
+	v.Set(reflect.Zero(v.Type()))
+}
+
+// testCollector is an OTLP/HTTP collector that keeps received spans
+type testCollector struct {
+	*httptest.Server
+	mu    sync.Mutex
+	spans []*tracev1.Span
+}
+
+func newTestCollector(t *testing.T) *testCollector {
+	c := &testCollector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req := &collectortrace.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(b, req); err != nil {
+			t.Errorf("collector got invalid request: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+		c.mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		b, _ = proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+		w.Write(b)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *testCollector) getSpans(name string) []*tracev1.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	spans := []*tracev1.Span{}
+	for _, s := range c.spans {
+		if s.Name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func newTestTracingPrototype(t *testing.T, c *testCollector) *Prototype {
+	tp, err := newTracerProvider(c.Listener.Addr().String(), true)
+	if err != nil {
+		t.Fatalf("error creating tracer provider: %s", err)
+	}
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	p := &Prototype{
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		tracerProvider: tp,
+		tracer:         tp.Tracer(tracerName),
+	}
+	p.orm = &tracingORM{orm: &testORM{}, tracer: p.tracer}
+	return p
+}
+
+func TestTracingORMSpansAreChildrenOfRequestSpan(t *testing.T) {
+	c := newTestCollector(t)
+	p := newTestTracingPrototype(t, c)
+
+	h := p.wrapHandlerWithTracing(routeTypeAPI, p.wrapHandlerWithTenant(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orm := p.getRequestORM(r.Context())
+		orm.Load(&struct{}{}, "1")
+		orm.Save(&struct{}{})
+	})))
+
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/Item/1", nil))
+	}
+	if err := p.tracerProvider.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("error flushing spans: %s", err)
+	}
+
+	requests := c.getSpans("GET " + routeTypeAPI)
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 request spans, got %d", len(requests))
+	}
+	for _, name := range []string{"ORM.Load", "ORM.Save"} {
+		spans := c.getSpans(name)
+		if len(spans) != 2 {
+			t.Fatalf("expected 2 %s spans, got %d", name, len(spans))
+		}
+		for i, s := range spans {
+			parent := requests[i]
+			if string(s.TraceId) != string(parent.TraceId) || string(s.ParentSpanId) != string(parent.SpanId) {
+				t.Errorf("%s span is not a child of the request span", name)
+			}
+		}
+	}
+}
+
+func TestUserHookGetsRequestSpan(t *testing.T) {
+	c := newTestCollector(t)
+	p := newTestTracingPrototype(t, c)
+
+	var gotUserID int64
+	p.userHooks.Created = func(ctx context.Context, userID int64) {
+		gotUserID = userID
+		_, span := p.tracer.Start(ctx, "hook")
+		span.End()
+	}
+
+	h := p.wrapHandlerWithTracing(routeTypeAPI, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.callUserHook(r.Context(), "Created", p.userHooks.Created, 7)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/r/register/", nil))
+	if err := p.tracerProvider.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("error flushing spans: %s", err)
+	}
+
+	if gotUserID != 7 {
+		t.Fatalf("expected hook to get user 7, got %d", gotUserID)
+	}
+	requests := c.getSpans("POST " + routeTypeAPI)
+	hooks := c.getSpans("UserHooks.Created")
+	inner := c.getSpans("hook")
+	if len(requests) != 1 || len(hooks) != 1 || len(inner) != 1 {
+		t.Fatalf("expected one request, hook and inner span, got %d, %d and %d", len(requests), len(hooks), len(inner))
+	}
+	if string(hooks[0].ParentSpanId) != string(requests[0].SpanId) {
+		t.Errorf("hook span is not a child of the request span")
+	}
+	if string(inner[0].ParentSpanId) != string(hooks[0].SpanId) || string(inner[0].TraceId) != string(requests[0].TraceId) {
+		t.Errorf("span started in the hook is not part of the request trace")
+	}
+}
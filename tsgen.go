@@ -0,0 +1,389 @@
+package prototyping
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// tsReservedNames are declared by the generated client and cannot be used as struct names
+var tsReservedNames = map[string]bool{
+	"Client":           true,
+	"ClientOptions":    true,
+	"APIError":         true,
+	"Resource":         true,
+	"ListQuery":        true,
+	"ListResult":       true,
+	"FieldConstraints": true,
+	"Constraints":      true,
+	"validate":         true,
+	"routes":           true,
+}
+
+type tsField struct {
+	Name        string
+	JSONName    string
+	Type        string
+	Optional    bool
+	Constraints string
+}
+
+type tsStruct struct {
+	Name     string
+	APIPath  string
+	GRPCPath string
+	Fields   []tsField
+	HasRules bool
+}
+
+func getTSType(k reflect.Kind) string {
+	switch k {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	}
+	return "number"
+}
+
+// getTSConstraints returns validation rules from the ui tag as a TypeScript object, or empty string when there are
+// none
+func getTSConstraints(tag string) string {
+	rules := []string{}
+	for _, s := range strings.Fields(tag) {
+		name, value, _ := strings.Cut(s, ":")
+		switch name {
+		case "req":
+			rules = append(rules, "required: true")
+		case "email":
+			rules = append(rules, "email: true")
+		case "lenmin", "lenmax", "valmin", "valmax":
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+			key := map[string]string{"lenmin": "minLength", "lenmax": "maxLength", "valmin": "min", "valmax": "max"}[name]
+			rules = append(rules, fmt.Sprintf("%s: %s", key, value))
+		case "regexp":
+			b, _ := json.Marshal(value)
+			rules = append(rules, fmt.Sprintf("pattern: %s", string(b)))
+		}
+	}
+	if len(rules) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("{ %s }", strings.Join(rules, ", "))
+}
+
+// GenerateTypeScript writes types.ts with interfaces and validation constraints of the structs, and client.ts with
+// a fetch based client of the routes registered in Run, to dir
+func (p *Prototype) GenerateTypeScript(dir string) error {
+	return p.generateTypeScript(dir, p.getAPIConstructors())
+}
+
+func (p *Prototype) generateTypeScript(dir string, constructors []func() interface{}) error {
+	structs := []tsStruct{}
+	for _, f := range constructors {
+		s := getModelStruct(f)
+		if tsReservedNames[s.name] || !token.IsIdentifier(s.name) {
+			return fmt.Errorf("struct name %s cannot be used in typescript", s.name)
+		}
+		ts := tsStruct{
+			Name:     s.name,
+			APIPath:  fmt.Sprintf("%s%s/", p.uriAPI, s.name),
+			GRPCPath: fmt.Sprintf("/%s.%sService/", p.grpcPackage, s.name),
+		}
+		t := reflect.TypeOf(f()).Elem()
+		for _, field := range s.fields {
+			// fields that are not marshaled are not sent by the API
+			sf, _ := t.FieldByName(field.name)
+			if sf.Tag.Get("json") == "-" {
+				continue
+			}
+			tf := tsField{
+				Name:        field.name,
+				JSONName:    field.jsonName,
+				Type:        getTSType(field.typ.Kind()),
+				Optional:    field.password,
+				Constraints: getTSConstraints(sf.Tag.Get("ui")),
+			}
+			if tf.Constraints != "" {
+				ts.HasRules = true
+			}
+			ts.Fields = append(ts.Fields, tf)
+		}
+		structs = append(structs, ts)
+	}
+
+	data := map[string]interface{}{
+		"Structs":    structs,
+		"LoginURI":   p.uriUmbrella + "login",
+		"GraphQLURI": p.uriGraphQL,
+		"GRPC":       p.grpc,
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	for name, tpl := range map[string]*template.Template{"types.ts": tsTypesTemplate, "client.ts": tsClientTemplate} {
+		b := &bytes.Buffer{}
+		err = tpl.Execute(b, data)
+		if err != nil {
+			return fmt.Errorf("error generating %s: %w", name, err)
+		}
+		err = os.WriteFile(filepath.Join(dir, name), b.Bytes(), 0644)
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+var tsTypesTemplate = template.Must(template.New("types").Parse(`// Code generated by prototyping. DO NOT EDIT.
+
+export interface FieldConstraints {
+  required?: boolean;
+  minLength?: number;
+  maxLength?: number;
+  min?: number;
+  max?: number;
+  email?: boolean;
+  pattern?: string;
+}
+
+export type Constraints<T> = { [K in keyof T]?: FieldConstraints };
+{{range .Structs}}{{$s := .Name}}
+export interface {{$s}} {
+{{range .Fields}}  {{.JSONName}}{{if .Optional}}?{{end}}: {{.Type}};
+{{end}}}
+
+export const {{$s}}Constraints: Constraints<{{$s}}> = {{if .HasRules}}{
+{{range .Fields}}{{if .Constraints}}  {{.JSONName}}: {{.Constraints}},
+{{end}}{{end}}}{{else}}{}{{end}};
+
+// {{$s}}Fields maps json names of {{$s}} fields to names used in filters and ordering
+export const {{$s}}Fields: Record<keyof {{$s}}, string> = {
+{{range .Fields}}  {{.JSONName}}: "{{.Name}}",
+{{end}}};
+{{end}}
+const emailPattern = /^[^@\s]+@[^@\s]+\.[^@\s]+$/;
+
+// validate returns names of the fields that do not meet the constraints
+export function validate<T>(o: Partial<T>, constraints: Constraints<T>): (keyof T)[] {
+  const invalid: (keyof T)[] = [];
+  for (const key of Object.keys(constraints) as (keyof T)[]) {
+    const c = constraints[key] as FieldConstraints;
+    const v = o[key] as unknown;
+    const empty = v === undefined || v === null || v === "" || v === 0;
+    if (empty) {
+      if (c.required) {
+        invalid.push(key);
+      }
+      continue;
+    }
+    if (typeof v === "string") {
+      if ((c.minLength !== undefined && v.length < c.minLength) ||
+        (c.maxLength !== undefined && v.length > c.maxLength) ||
+        (c.email && !emailPattern.test(v)) ||
+        (c.pattern !== undefined && !new RegExp(c.pattern).test(v))) {
+        invalid.push(key);
+      }
+    }
+    if (typeof v === "number") {
+      if ((c.min !== undefined && v < c.min) || (c.max !== undefined && v > c.max)) {
+        invalid.push(key);
+      }
+    }
+  }
+  return invalid;
+}
+`))
+
+var tsClientTemplate = template.Must(template.New("client").Parse(`// Code generated by prototyping. DO NOT EDIT.
+
+import type { {{range $i, $s := .Structs}}{{if $i}}, {{end}}{{$s.Name}}{{end}} } from "./types";
+import { {{range $i, $s := .Structs}}{{if $i}}, {{end}}{{$s.Name}}Fields{{end}} } from "./types";
+
+export const routes = {
+  login: "{{.LoginURI}}",
+{{if .GraphQLURI}}  graphql: "{{.GraphQLURI}}",
+{{end}}  api: {
+{{range .Structs}}    {{.Name}}: "{{.APIPath}}",
+{{end}}  },
+{{if .GRPC}}  connect: {
+{{range .Structs}}    {{.Name}}: "{{.GRPCPath}}",
+{{end}}  },
+{{end}}} as const;
+
+export class APIError extends Error {
+  // code is err_text of a JSON response or the plain text body, eg. FieldNotAllowed
+  constructor(public readonly status: number, public readonly code: string, public readonly retryAfter?: number) {
+    super(code ? ` + "`api error: status ${status}: ${code}`" + ` : ` + "`api error: status ${status}`" + `);
+  }
+
+  get isNotFound(): boolean { return this.status === 404; }
+  get isUnauthorized(): boolean { return this.status === 401; }
+  get isForbidden(): boolean { return this.status === 403; }
+  get isRateLimited(): boolean { return this.status === 429; }
+}
+
+export interface ListQuery<T> {
+  limit?: number;
+  offset?: number;
+  order?: keyof T;
+  orderDirection?: "asc" | "desc";
+  filters?: { [K in keyof T]?: T[K] };
+}
+
+export interface ListResult<T> {
+  items: T[];
+  total: number;
+}
+
+export interface ClientOptions {
+  // apiKey is sent in X-API-Key header
+  apiKey?: string;
+  // token is sent in Authorization header, it can be obtained with login
+  token?: string;
+  fetch?: typeof fetch;
+}
+
+interface APIResponse {
+  ok: number;
+  err_text?: string;
+  data?: unknown;
+}
+
+export class Resource<T> {
+  constructor(private readonly client: Client, private readonly path: string, private readonly fields: Record<keyof T, string>) {}
+
+  async get(id: number): Promise<T> {
+    const data = await this.client.request<{ item: T }>("GET", this.path + id);
+    return data.item;
+  }
+
+  async list(q: ListQuery<T> = {}): Promise<ListResult<T>> {
+    const params = new URLSearchParams();
+    if (q.limit) params.set("limit", String(q.limit));
+    if (q.offset) params.set("offset", String(q.offset));
+    if (q.order) {
+      params.set("order", this.fields[q.order]);
+      params.set("order_direction", q.orderDirection ?? "asc");
+    }
+    for (const [k, v] of Object.entries(q.filters ?? {})) {
+      params.set("filter_" + this.fields[k as keyof T], String(v));
+    }
+    const data = await this.client.request<ListResult<T>>("GET", this.path, params);
+    return { items: data.items ?? [], total: data.total ?? 0 };
+  }
+
+  // create returns ID of the new object
+  async create(o: Partial<T>): Promise<number> {
+    const data = await this.client.request<{ id: number }>("PUT", this.path, undefined, o);
+    return data.id;
+  }
+
+  async update(id: number, o: Partial<T>): Promise<void> {
+    await this.client.request("PUT", this.path + id, undefined, o);
+  }
+
+  async delete(id: number): Promise<void> {
+    await this.client.request("DELETE", this.path + id);
+  }
+
+  // iterate fetches pages of q's limit, 100 by default, as they are needed
+  async *iterate(q: ListQuery<T> = {}): AsyncGenerator<T> {
+    const limit = q.limit || 100;
+    let offset = q.offset ?? 0;
+    for (;;) {
+      const { items } = await this.list({ ...q, limit, offset });
+      yield* items;
+      if (items.length < limit) {
+        return;
+      }
+      offset += items.length;
+    }
+  }
+}
+
+export class Client {
+{{range .Structs}}  readonly {{.Name}}: Resource<{{.Name}}>;
+{{end}}
+  private readonly baseURL: string;
+  private readonly apiKey?: string;
+  private token?: string;
+  private readonly fetch: typeof fetch;
+
+  // baseURL is the address of the prototype, eg. http://localhost:9001
+  constructor(baseURL: string, opts: ClientOptions = {}) {
+    this.baseURL = baseURL.replace(/\/$/, "");
+    this.apiKey = opts.apiKey;
+    this.token = opts.token;
+    this.fetch = opts.fetch ?? globalThis.fetch.bind(globalThis);
+{{range .Structs}}    this.{{.Name}} = new Resource<{{.Name}}>(this, routes.api.{{.Name}}, {{.Name}}Fields);
+{{end}}  }
+
+  setToken(token: string): void {
+    this.token = token;
+  }
+
+  // login gets a token and sends it with the following requests, totpCode is required only for users with
+  // two-factor authentication
+  async login(email: string, password: string, totpCode?: string): Promise<void> {
+    const form = new URLSearchParams({ email, password });
+    if (totpCode) form.set("totp_code", totpCode);
+    const data = await this.request<{ token: string }>("POST", routes.login, undefined, form);
+    this.token = data.token;
+  }
+{{if .GraphQLURI}}
+  async graphql<T = unknown>(query: string, variables?: Record<string, unknown>): Promise<T> {
+    const res = await this.send("POST", routes.graphql, undefined, { query, variables });
+    const body = await res.json() as { data?: T; errors?: { message: string }[] };
+    if (!res.ok || body.errors?.length) {
+      throw new APIError(res.status, body.errors?.map((e) => e.message).join("; ") ?? "");
+    }
+    return body.data as T;
+  }
+{{end}}
+  async request<T = unknown>(method: string, path: string, query?: URLSearchParams, body?: unknown): Promise<T> {
+    const res = await this.send(method, path, query, body);
+    const text = await res.text();
+    let parsed: APIResponse | undefined;
+    try {
+      parsed = JSON.parse(text) as APIResponse;
+    } catch {
+      parsed = undefined;
+    }
+    if (!res.ok || (parsed && parsed.ok === 0 && parsed.err_text)) {
+      const retryAfter = Number(res.headers.get("Retry-After")) || undefined;
+      throw new APIError(res.status, parsed ? parsed.err_text ?? "" : text.trim(), retryAfter);
+    }
+    return (parsed?.data ?? {}) as T;
+  }
+
+  private send(method: string, path: string, query?: URLSearchParams, body?: unknown): Promise<Response> {
+    const headers: Record<string, string> = {};
+    if (this.apiKey) {
+      headers["X-API-Key"] = this.apiKey;
+    } else if (this.token) {
+      headers["Authorization"] = "Bearer " + this.token;
+    }
+    let payload: BodyInit | undefined;
+    if (body instanceof URLSearchParams) {
+      payload = body;
+    } else if (body !== undefined) {
+      payload = JSON.stringify(body);
+      headers["Content-Type"] = "application/json";
+    }
+    const qs = query && query.toString() ? "?" + query.toString() : "";
+    return this.fetch(this.baseURL + path + qs, { method, headers, body: payload });
+  }
+}
+`))
@@ -0,0 +1,78 @@
+package prototyping
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+type TSGroup struct {
+	ID   int64
+	Name string `json:"name"`
+}
+
+type TSItem struct {
+	ID        int64
+	Name      string  `json:"name" ui:"req lenmin:1 lenmax:50"`
+	Email     string  `json:"email" ui:"email regexp:^[a-z]+@"`
+	Price     float64 `json:"price" ui:"valmin:0 valmax:1000"`
+	Active    bool
+	TSGroupID int64  `json:"group_id"`
+	Password  string `json:"password" ui:"password"`
+	Internal  string `json:"-"`
+}
+
+// Resource is a class of the generated client
+type Resource struct {
+	ID int64
+}
+
+func TestGenerateTypeScript(t *testing.T) {
+	p := &Prototype{
+		uriAPI:      "/api/",
+		uriUmbrella: "/umbrella/",
+		uriGraphQL:  "/graphql",
+		grpc:        true,
+		grpcPackage: "prototyping",
+	}
+	dir := t.TempDir()
+	err := p.generateTypeScript(dir, []func() interface{}{
+		func() interface{} { return &TSGroup{} },
+		func() interface{} { return &TSItem{} },
+	})
+	if err != nil {
+		t.Fatalf("error generating typescript: %s", err)
+	}
+
+	for _, name := range []string{"types.ts", "client.ts"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("error reading %s: %s", name, err)
+		}
+		golden := filepath.Join("testdata", "tsgen", name)
+		if *updateGolden {
+			err = os.WriteFile(golden, b, 0644)
+			if err != nil {
+				t.Fatalf("error writing %s: %s", golden, err)
+			}
+		}
+		expected, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("error reading %s: %s", golden, err)
+		}
+		if string(b) != string(expected) {
+			t.Errorf("%s differs from %s, run go test -run TestGenerateTypeScript -update to update it:\n%s", name, golden, b)
+		}
+	}
+}
+
+func TestGenerateTypeScriptReservedName(t *testing.T) {
+	p := &Prototype{}
+	err := p.generateTypeScript(t.TempDir(), []func() interface{}{func() interface{} { return &Resource{} }})
+	if err == nil {
+		t.Fatal("expected error with struct named like a type of the client")
+	}
+}